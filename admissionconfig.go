@@ -0,0 +1,189 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+	admissionapi "k8s.io/pod-security-admission/admission/api"
+	"k8s.io/pod-security-admission/admission/api/load"
+	"k8s.io/pod-security-admission/admission/api/scheme"
+	admissionv1beta1 "k8s.io/pod-security-admission/admission/api/v1beta1"
+	"k8s.io/pod-security-admission/admission/api/validation"
+	psaapi "k8s.io/pod-security-admission/api"
+	"sigs.k8s.io/yaml"
+)
+
+// PodSecurityConfigurationSuggestion is the PodSecurity admission plugin
+// configuration suggested for the pods of a cluster, see
+// SuggestPodSecurityConfiguration.
+type PodSecurityConfigurationSuggestion struct {
+	Configuration *admissionapi.PodSecurityConfiguration
+	// PrivilegedPods are the pods that need the privileged level, i.e.
+	// pods that rely on a privileged PSP object, in namespace/name form.
+	PrivilegedPods []string
+	// ServiceAccounts are the service accounts of the privileged pods, as
+	// system:serviceaccount:<namespace>:<name> usernames. They aren't
+	// exempted because username exemptions apply to the user creating the
+	// pod, which is usually a controller rather than the pod's service
+	// account.
+	ServiceAccounts []string
+}
+
+// SuggestPodSecurityConfiguration suggests a configuration of the PodSecurity
+// admission plugin that admits all the given pods with minimal exemptions.
+// The pods that need the privileged level are exempted by their runtime
+// class if all pods with that runtime class need it, and by their namespace
+// otherwise. The enforced default is the most restrictive level all other
+// pods are allowed to run under, audit and warn default to restricted. The
+// configuration is validated before it's returned.
+func SuggestPodSecurityConfiguration(pods []v1.Pod) (*PodSecurityConfigurationSuggestion, error) {
+	levels := make([]psaapi.Level, len(pods))
+	// runtime classes that are only used by privileged pods
+	runtimeClasses := make(map[string]bool)
+	for i := range pods {
+		level, err := SuggestedPodSecurityStandard(&pods[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate pod %s/%s: %w", pods[i].Namespace, pods[i].Name, err)
+		}
+		levels[i] = level
+		if rc := pods[i].Spec.RuntimeClassName; rc != nil && *rc != "" {
+			onlyPrivileged, seen := runtimeClasses[*rc]
+			runtimeClasses[*rc] = (onlyPrivileged || !seen) && level == psaapi.LevelPrivileged
+		}
+	}
+
+	suggestion := &PodSecurityConfigurationSuggestion{}
+	exemptRuntimeClasses := sets.NewString()
+	exemptNamespaces := sets.NewString()
+	serviceAccounts := sets.NewString()
+	for i := range pods {
+		pod := &pods[i]
+		if levels[i] != psaapi.LevelPrivileged {
+			continue
+		}
+		suggestion.PrivilegedPods = append(suggestion.PrivilegedPods, pod.Namespace+"/"+pod.Name)
+		serviceAccount := pod.Spec.ServiceAccountName
+		if serviceAccount == "" {
+			serviceAccount = "default"
+		}
+		serviceAccounts.Insert(fmt.Sprintf("system:serviceaccount:%s:%s", pod.Namespace, serviceAccount))
+		if rc := pod.Spec.RuntimeClassName; rc != nil && runtimeClasses[*rc] {
+			exemptRuntimeClasses.Insert(*rc)
+		} else {
+			exemptNamespaces.Insert(pod.Namespace)
+		}
+	}
+	suggestion.ServiceAccounts = serviceAccounts.List()
+
+	enforce := psaapi.LevelRestricted
+	for i := range pods {
+		pod := &pods[i]
+		if exemptNamespaces.Has(pod.Namespace) ||
+			(pod.Spec.RuntimeClassName != nil && exemptRuntimeClasses.Has(*pod.Spec.RuntimeClassName)) {
+			continue
+		}
+		if psaapi.CompareLevels(levels[i], enforce) < 0 {
+			enforce = levels[i]
+		}
+	}
+
+	latest := psaapi.VersionLatest
+	suggestion.Configuration = &admissionapi.PodSecurityConfiguration{
+		Defaults: admissionapi.PodSecurityDefaults{
+			Enforce:        string(enforce),
+			EnforceVersion: latest,
+			Audit:          string(psaapi.LevelRestricted),
+			AuditVersion:   latest,
+			Warn:           string(psaapi.LevelRestricted),
+			WarnVersion:    latest,
+		},
+		Exemptions: admissionapi.PodSecurityExemptions{
+			Namespaces:     exemptNamespaces.List(),
+			RuntimeClasses: exemptRuntimeClasses.List(),
+		},
+	}
+	if errs := validation.ValidatePodSecurityConfiguration(suggestion.Configuration); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid PodSecurityConfiguration: %w", errs.ToAggregate())
+	}
+	return suggestion, nil
+}
+
+// AdmissionConfiguration returns the AdmissionConfiguration of the API server
+// that configures the PodSecurity admission plugin with the configuration.
+// It can be passed to the API server with --admission-control-config-file.
+func AdmissionConfiguration(configuration *admissionapi.PodSecurityConfiguration) (*unstructured.Unstructured, error) {
+	external := &admissionv1beta1.PodSecurityConfiguration{}
+	if err := scheme.Scheme.Convert(configuration, external, nil); err != nil {
+		return nil, fmt.Errorf("failed to convert PodSecurityConfiguration: %w", err)
+	}
+	external.APIVersion = admissionv1beta1.SchemeGroupVersion.String()
+	external.Kind = "PodSecurityConfiguration"
+	// The TypeMeta of the configuration types isn't inlined explicitly, so
+	// only encoding/json gets apiVersion and kind right.
+	data, err := json.Marshal(external)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode PodSecurityConfiguration: %w", err)
+	}
+	plugin := map[string]interface{}{}
+	if err := json.Unmarshal(data, &plugin); err != nil {
+		return nil, fmt.Errorf("failed to encode PodSecurityConfiguration: %w", err)
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiserver.config.k8s.io/v1",
+		"kind":       "AdmissionConfiguration",
+		"plugins": []interface{}{
+			map[string]interface{}{
+				"name":          "PodSecurity",
+				"configuration": plugin,
+			},
+		},
+	}}, nil
+}
+
+// LoadPodSecurityConfiguration decodes a PodSecurityConfiguration, or an
+// AdmissionConfiguration with an inline configuration of the PodSecurity
+// plugin such as the one returned by AdmissionConfiguration. The defaults
+// of the API server are returned if data is empty.
+func LoadPodSecurityConfiguration(data []byte) (*admissionapi.PodSecurityConfiguration, error) {
+	var admissionConfig struct {
+		Kind    string `json:"kind"`
+		Plugins []struct {
+			Name          string          `json:"name"`
+			Configuration json.RawMessage `json:"configuration"`
+		} `json:"plugins"`
+	}
+	if err := yaml.Unmarshal(data, &admissionConfig); err != nil {
+		return nil, err
+	}
+	if admissionConfig.Kind != "AdmissionConfiguration" {
+		return load.LoadFromData(data)
+	}
+	for _, plugin := range admissionConfig.Plugins {
+		if plugin.Name == "PodSecurity" {
+			if len(plugin.Configuration) == 0 {
+				return nil, fmt.Errorf("the PodSecurity plugin is configured by a file, which isn't supported")
+			}
+			return load.LoadFromData(plugin.Configuration)
+		}
+	}
+	return load.LoadFromData(nil)
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/pod-security-admission/admission/api/load"
+	"sigs.k8s.io/yaml"
+)
+
+func TestSuggestPodSecurityConfiguration(t *testing.T) {
+	pod := func(namespace, name, file string, runtimeClass string) v1.Pod {
+		p := fixturePod(t, file)
+		p.Namespace, p.Name = namespace, name
+		if runtimeClass != "" {
+			p.Spec.RuntimeClassName = &runtimeClass
+		}
+		return *p
+	}
+	pods := []v1.Pod{
+		pod("apps", "web", "nginx.yaml", ""),
+		pod("monitoring", "node-exporter", "nginx-privileged.yaml", ""),
+		pod("sandboxed", "build", "nginx-privileged.yaml", "kata"),
+		pod("sandboxed", "other", "nginx-privileged.yaml", "kata"),
+		// gvisor is also used by a baseline pod, so it isn't exempted
+		pod("gvisor", "debug", "nginx-privileged.yaml", "gvisor"),
+		pod("apps", "api", "nginx.yaml", "gvisor"),
+	}
+
+	suggestion, err := SuggestPodSecurityConfiguration(pods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := suggestion.Configuration
+	if config.Defaults.Enforce != "baseline" || config.Defaults.Audit != "restricted" || config.Defaults.Warn != "restricted" {
+		t.Errorf("Unexpected defaults %+v", config.Defaults)
+	}
+	if expected := []string{"gvisor", "monitoring"}; !reflect.DeepEqual(config.Exemptions.Namespaces, expected) {
+		t.Errorf("Expected namespace exemptions %v, got %v", expected, config.Exemptions.Namespaces)
+	}
+	if expected := []string{"kata"}; !reflect.DeepEqual(config.Exemptions.RuntimeClasses, expected) {
+		t.Errorf("Expected runtime class exemptions %v, got %v", expected, config.Exemptions.RuntimeClasses)
+	}
+	if len(config.Exemptions.Usernames) != 0 {
+		t.Errorf("Expected no username exemptions, got %v", config.Exemptions.Usernames)
+	}
+	if len(suggestion.PrivilegedPods) != 4 {
+		t.Errorf("Expected 4 privileged pods, got %v", suggestion.PrivilegedPods)
+	}
+	expectedServiceAccounts := []string{
+		"system:serviceaccount:gvisor:default",
+		"system:serviceaccount:monitoring:default",
+		"system:serviceaccount:sandboxed:default",
+	}
+	if !reflect.DeepEqual(suggestion.ServiceAccounts, expectedServiceAccounts) {
+		t.Errorf("Expected service accounts %v, got %v", expectedServiceAccounts, suggestion.ServiceAccounts)
+	}
+}
+
+func TestSuggestPodSecurityConfigurationNoPrivilegedPods(t *testing.T) {
+	suggestion, err := SuggestPodSecurityConfiguration([]v1.Pod{*fixturePod(t, "nginx.yaml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := suggestion.Configuration
+	if config.Defaults.Enforce != "baseline" {
+		t.Errorf("Expected baseline to be enforced, got %v", config.Defaults.Enforce)
+	}
+	if len(config.Exemptions.Namespaces) != 0 || len(config.Exemptions.RuntimeClasses) != 0 {
+		t.Errorf("Expected no exemptions, got %+v", config.Exemptions)
+	}
+
+	suggestion, err = SuggestPodSecurityConfiguration(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if suggestion.Configuration.Defaults.Enforce != "restricted" {
+		t.Errorf("Expected restricted to be enforced without pods, got %v", suggestion.Configuration.Defaults.Enforce)
+	}
+}
+
+func TestAdmissionConfiguration(t *testing.T) {
+	privileged := fixturePod(t, "nginx-privileged.yaml")
+	privileged.Namespace = "monitoring"
+	suggestion, err := SuggestPodSecurityConfiguration([]v1.Pod{*privileged})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admissionConfig, err := AdmissionConfiguration(suggestion.Configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admissionConfig = deepCopyUnstructured(t, admissionConfig)
+	if admissionConfig.GetKind() != "AdmissionConfiguration" {
+		t.Errorf("Unexpected kind %v", admissionConfig.GetKind())
+	}
+
+	// the plugin configuration must load the same way the API server loads it
+	plugins := admissionConfig.Object["plugins"].([]interface{})
+	plugin := plugins[0].(map[string]interface{})
+	if plugin["name"] != "PodSecurity" {
+		t.Errorf("Unexpected plugin %v", plugin["name"])
+	}
+	data, err := yaml.Marshal(plugin["configuration"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := load.LoadFromData(data)
+	if err != nil {
+		t.Fatalf("Failed to load configuration %s: %v", data, err)
+	}
+	if !reflect.DeepEqual(loaded.Defaults, suggestion.Configuration.Defaults) {
+		t.Errorf("Expected loaded defaults %+v, got %+v", suggestion.Configuration.Defaults, loaded.Defaults)
+	}
+	if !reflect.DeepEqual(loaded.Exemptions.Namespaces, []string{"monitoring"}) {
+		t.Errorf("Expected loaded namespace exemptions [monitoring], got %v", loaded.Exemptions.Namespaces)
+	}
+}
+
+func TestLoadPodSecurityConfiguration(t *testing.T) {
+	privileged := fixturePod(t, "nginx-privileged.yaml")
+	privileged.Namespace = "monitoring"
+	suggestion, err := SuggestPodSecurityConfiguration([]v1.Pod{*privileged})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admissionConfig, err := AdmissionConfiguration(suggestion.Configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := yaml.Marshal(admissionConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadPodSecurityConfiguration(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Exemptions.Namespaces, []string{"monitoring"}) {
+		t.Errorf("Expected namespace exemptions [monitoring], got %v", loaded.Exemptions.Namespaces)
+	}
+
+	defaults, err := LoadPodSecurityConfiguration(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaults.Defaults.Enforce != "privileged" {
+		t.Errorf("Expected the privileged default, got %v", defaults.Defaults.Enforce)
+	}
+
+	if _, err := LoadPodSecurityConfiguration([]byte("kind: Foo\napiVersion: v1\n")); err == nil {
+		t.Error("Expected an error for an unknown kind")
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Audit annotations added by the PodSecurityPolicy and PodSecurity admission
+// plugins to the events of the API server audit log.
+const (
+	PSPAdmitPolicyAuditAnnotation     = "podsecuritypolicy.policy.k8s.io/admit-policy"
+	PSPValidatePolicyAuditAnnotation  = "podsecuritypolicy.policy.k8s.io/validate-policy"
+	PSAEnforcePolicyAuditAnnotation   = "pod-security.kubernetes.io/enforce-policy"
+	PSAAuditViolationsAuditAnnotation = "pod-security.kubernetes.io/audit-violations"
+	PSAExemptAuditAnnotation          = "pod-security.kubernetes.io/exempt"
+)
+
+// AuditEvent is the part of an audit.k8s.io/v1 Event that is relevant for
+// PodSecurityPolicy and PodSecurity admission decisions.
+type AuditEvent struct {
+	Stage string `json:"stage"`
+	Verb  string `json:"verb"`
+	User  struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	ObjectRef *struct {
+		Resource  string `json:"resource"`
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	} `json:"objectRef"`
+	ResponseStatus *struct {
+		Code int `json:"code"`
+	} `json:"responseStatus"`
+	Annotations              map[string]string `json:"annotations"`
+	RequestReceivedTimestamp time.Time         `json:"requestReceivedTimestamp"`
+}
+
+// AuditSummary counts the admission decisions of a namespace or a user.
+type AuditSummary struct {
+	// PSPs counts the requests admitted by each PSP object.
+	PSPs map[string]int
+	// AuditViolations counts the requests with each PodSecurity audit
+	// violation.
+	AuditViolations map[string]int
+	// Exemptions counts the requests exempted from PodSecurity admission
+	// by the reason of the exemption.
+	Exemptions map[string]int
+}
+
+func newAuditSummary() *AuditSummary {
+	return &AuditSummary{
+		PSPs:            make(map[string]int),
+		AuditViolations: make(map[string]int),
+		Exemptions:      make(map[string]int),
+	}
+}
+
+// AuditViolation is a request with a PodSecurity audit violation.
+type AuditViolation struct {
+	Namespace string
+	// Object is the resource and name of the object, e.g. pods/web-1, or
+	// the resource and the user for objects created with a generated name.
+	Object string
+	Time   time.Time
+}
+
+// AuditReport summarizes the PodSecurityPolicy and PodSecurity admission
+// decisions of audit logs, per namespace and per user. Users include the
+// service accounts of controllers, e.g. the ReplicaSet controller creating
+// the pods of a Deployment.
+type AuditReport struct {
+	Namespaces map[string]*AuditSummary
+	Users      map[string]*AuditSummary
+	// Events is the number of events with admission decisions.
+	Events int
+	// From and To are the timestamps of the first and last of these events.
+	From, To time.Time
+	// Violations are the requests with PodSecurity audit violations, in the
+	// order they were read.
+	Violations []AuditViolation
+
+	// Since and Until limit the report to the events of requests received
+	// in this window, e.g. a soak period. A zero time doesn't limit it.
+	Since, Until time.Time
+}
+
+// NewAuditReport returns an empty report.
+func NewAuditReport() *AuditReport {
+	return &AuditReport{
+		Namespaces: make(map[string]*AuditSummary),
+		Users:      make(map[string]*AuditSummary),
+	}
+}
+
+// Add records the admission decisions of the event. Events of other stages
+// than ResponseComplete are ignored so requests aren't counted twice, and so
+// are events outside the window of Since and Until.
+func (r *AuditReport) Add(event *AuditEvent) {
+	if event.Stage != "" && event.Stage != "ResponseComplete" {
+		return
+	}
+	if t := event.RequestReceivedTimestamp; (!r.Since.IsZero() && t.Before(r.Since)) ||
+		(!r.Until.IsZero() && t.After(r.Until)) {
+		return
+	}
+	psp := event.Annotations[PSPValidatePolicyAuditAnnotation]
+	if psp == "" {
+		psp = event.Annotations[PSPAdmitPolicyAuditAnnotation]
+	}
+	// requests denied by the API server weren't admitted by the PSP
+	if event.ResponseStatus != nil && event.ResponseStatus.Code >= 400 {
+		psp = ""
+	}
+	violations := event.Annotations[PSAAuditViolationsAuditAnnotation]
+	exempt := event.Annotations[PSAExemptAuditAnnotation]
+	if psp == "" && violations == "" && exempt == "" {
+		return
+	}
+
+	r.Events++
+	if t := event.RequestReceivedTimestamp; !t.IsZero() {
+		if r.From.IsZero() || t.Before(r.From) {
+			r.From = t
+		}
+		if t.After(r.To) {
+			r.To = t
+		}
+	}
+	namespace := ""
+	if event.ObjectRef != nil {
+		namespace = event.ObjectRef.Namespace
+	}
+	if violations != "" && event.ObjectRef != nil {
+		object := event.ObjectRef.Resource + "/" + event.ObjectRef.Name
+		if event.ObjectRef.Name == "" {
+			object = event.ObjectRef.Resource + " created by " + event.User.Username
+		}
+		r.Violations = append(r.Violations, AuditViolation{Namespace: namespace, Object: object, Time: event.RequestReceivedTimestamp})
+	}
+	for _, summary := range []*AuditSummary{r.summary(r.Namespaces, namespace), r.summary(r.Users, event.User.Username)} {
+		if psp != "" {
+			summary.PSPs[psp]++
+		}
+		if violations != "" {
+			summary.AuditViolations[violations]++
+		}
+		if exempt != "" {
+			summary.Exemptions[exempt]++
+		}
+	}
+}
+
+// ViolationsSince returns the objects of the namespace with PodSecurity audit
+// violations in requests received after since. Unlike the pods that exist
+// when the report is created, this includes short-lived pods, e.g. of Jobs.
+func (r *AuditReport) ViolationsSince(namespace string, since time.Time) []string {
+	objects := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, violation := range r.Violations {
+		if violation.Namespace == namespace && violation.Time.After(since) && !seen[violation.Object] {
+			seen[violation.Object] = true
+			objects = append(objects, violation.Object)
+		}
+	}
+	return objects
+}
+
+func (r *AuditReport) summary(summaries map[string]*AuditSummary, key string) *AuditSummary {
+	summary, ok := summaries[key]
+	if !ok {
+		summary = newAuditSummary()
+		summaries[key] = summary
+	}
+	return summary
+}
+
+// Read adds the events of a JSON lines audit log, as written by the log
+// backend of the API server. Gzipped logs, e.g. rotated files, are
+// decompressed.
+func (r *AuditReport) Read(reader io.Reader) error {
+	buffered := bufio.NewReader(reader)
+	if magic, err := buffered.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	} else {
+		reader = buffered
+	}
+
+	decoder := json.NewDecoder(reader)
+	for i := 1; ; i++ {
+		event := &AuditEvent{}
+		if err := decoder.Decode(event); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to decode audit event %d: %w", i, err)
+		}
+		r.Add(event)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const auditLog = `{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"Metadata","stage":"ResponseStarted","verb":"create","user":{"username":"system:serviceaccount:kube-system:replicaset-controller"},"objectRef":{"resource":"pods","namespace":"apps","name":"web-1"},"annotations":{"podsecuritypolicy.policy.k8s.io/admit-policy":"restricted","podsecuritypolicy.policy.k8s.io/validate-policy":"restricted"},"requestReceivedTimestamp":"2022-10-01T10:00:00.000000Z"}
+{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"Metadata","stage":"ResponseComplete","verb":"create","user":{"username":"system:serviceaccount:kube-system:replicaset-controller"},"objectRef":{"resource":"pods","namespace":"apps","name":"web-1"},"responseStatus":{"code":201},"annotations":{"podsecuritypolicy.policy.k8s.io/admit-policy":"restricted","podsecuritypolicy.policy.k8s.io/validate-policy":"restricted","pod-security.kubernetes.io/enforce-policy":"privileged:latest","pod-security.kubernetes.io/audit-violations":"would violate PodSecurity \"restricted:latest\": runAsNonRoot != true"},"requestReceivedTimestamp":"2022-10-01T10:00:00.000000Z"}
+{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"Metadata","stage":"ResponseComplete","verb":"create","user":{"username":"alice"},"objectRef":{"resource":"pods","namespace":"apps","name":"debug"},"responseStatus":{"code":201},"annotations":{"podsecuritypolicy.policy.k8s.io/validate-policy":"privileged"},"requestReceivedTimestamp":"2022-10-02T10:00:00.000000Z"}
+{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"Metadata","stage":"ResponseComplete","verb":"create","user":{"username":"alice"},"objectRef":{"resource":"pods","namespace":"apps","name":"denied"},"responseStatus":{"code":403},"annotations":{"podsecuritypolicy.policy.k8s.io/validate-policy":"privileged"},"requestReceivedTimestamp":"2022-10-02T11:00:00.000000Z"}
+{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"Metadata","stage":"ResponseComplete","verb":"create","user":{"username":"system:serviceaccount:kube-system:daemon-set-controller"},"objectRef":{"resource":"pods","namespace":"kube-system","name":"proxy"},"responseStatus":{"code":201},"annotations":{"pod-security.kubernetes.io/exempt":"namespace"},"requestReceivedTimestamp":"2022-09-30T10:00:00.000000Z"}
+{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"Metadata","stage":"ResponseComplete","verb":"get","user":{"username":"bob"},"objectRef":{"resource":"pods","namespace":"apps","name":"web-1"},"responseStatus":{"code":200},"requestReceivedTimestamp":"2022-10-03T10:00:00.000000Z"}
+`
+
+func TestAuditReport(t *testing.T) {
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	if _, err := gz.Write([]byte(auditLog)); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+
+	for name, data := range map[string][]byte{"plain": []byte(auditLog), "gzip": gzipped.Bytes()} {
+		t.Run(name, func(t *testing.T) {
+			report := NewAuditReport()
+			if err := report.Read(bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+			if report.Events != 3 {
+				t.Errorf("Expected 3 events with admission decisions, got %d", report.Events)
+			}
+			from, _ := time.Parse(time.RFC3339, "2022-09-30T10:00:00Z")
+			to, _ := time.Parse(time.RFC3339, "2022-10-02T10:00:00Z")
+			if !report.From.Equal(from) || !report.To.Equal(to) {
+				t.Errorf("Unexpected period %v to %v", report.From, report.To)
+			}
+
+			apps := report.Namespaces["apps"]
+			if apps == nil {
+				t.Fatal("Expected a summary of namespace apps")
+			}
+			if expected := map[string]int{"restricted": 1, "privileged": 1}; !reflect.DeepEqual(apps.PSPs, expected) {
+				t.Errorf("Expected PSPs %v, got %v", expected, apps.PSPs)
+			}
+			if len(apps.AuditViolations) != 1 {
+				t.Errorf("Expected a single audit violation, got %v", apps.AuditViolations)
+			}
+			if expected := map[string]int{"namespace": 1}; !reflect.DeepEqual(report.Namespaces["kube-system"].Exemptions, expected) {
+				t.Errorf("Expected exemptions %v, got %v", expected, report.Namespaces["kube-system"].Exemptions)
+			}
+
+			controller := report.Users["system:serviceaccount:kube-system:replicaset-controller"]
+			if controller == nil || controller.PSPs["restricted"] != 1 {
+				t.Errorf("Expected the replicaset controller to have created a pod admitted by restricted, got %+v", controller)
+			}
+			if alice := report.Users["alice"]; alice == nil || !reflect.DeepEqual(alice.PSPs, map[string]int{"privileged": 1}) {
+				t.Errorf("Expected alice to have created a pod admitted by privileged, got %+v", alice)
+			}
+			if _, ok := report.Users["bob"]; ok {
+				t.Error("Expected events without admission decisions to be ignored")
+			}
+		})
+	}
+}
+
+func TestAuditReportInvalid(t *testing.T) {
+	report := NewAuditReport()
+	err := report.Read(strings.NewReader(`{"kind":"Event"}` + "\n" + `{"kind":`))
+	if err == nil || !strings.Contains(err.Error(), "audit event 2") {
+		t.Errorf("Expected an error for the second event, got %v", err)
+	}
+}
+
+func TestAuditReportViolationsSince(t *testing.T) {
+	job := `{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"Metadata","stage":"ResponseComplete","verb":"create","user":{"username":"system:serviceaccount:kube-system:job-controller"},"objectRef":{"resource":"pods","namespace":"apps"},"responseStatus":{"code":201},"annotations":{"pod-security.kubernetes.io/audit-violations":"would violate PodSecurity \"restricted:latest\": runAsNonRoot != true"},"requestReceivedTimestamp":"2022-10-03T10:00:00.000000Z"}`
+	report := NewAuditReport()
+	if err := report.Read(strings.NewReader(auditLog + job + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	since, _ := time.Parse(time.RFC3339, "2022-09-30T00:00:00Z")
+	expected := []string{"pods/web-1", "pods created by system:serviceaccount:kube-system:job-controller"}
+	if violations := report.ViolationsSince("apps", since); !reflect.DeepEqual(violations, expected) {
+		t.Errorf("Expected violations %v, got %v", expected, violations)
+	}
+	since, _ = time.Parse(time.RFC3339, "2022-10-02T00:00:00Z")
+	if violations := report.ViolationsSince("apps", since); !reflect.DeepEqual(violations, expected[1:]) {
+		t.Errorf("Expected only the Job pod after the soak window started, got %v", violations)
+	}
+	if violations := report.ViolationsSince("kube-system", since); len(violations) != 0 {
+		t.Errorf("Expected no violations in kube-system, got %v", violations)
+	}
+}
+
+func TestAuditReportWindow(t *testing.T) {
+	report := NewAuditReport()
+	report.Since, _ = time.Parse(time.RFC3339, "2022-10-01T00:00:00Z")
+	report.Until, _ = time.Parse(time.RFC3339, "2022-10-01T23:59:59Z")
+	if err := report.Read(strings.NewReader(auditLog)); err != nil {
+		t.Fatal(err)
+	}
+	if report.Events != 1 || !reflect.DeepEqual(report.Namespaces["apps"].PSPs, map[string]int{"restricted": 1}) {
+		t.Errorf("Expected only the request of 2022-10-01 to be reported, got %d events %+v", report.Events, report.Namespaces["apps"])
+	}
+	if _, ok := report.Namespaces["kube-system"]; ok {
+		t.Error("Expected the request before the window to be excluded")
+	}
+}
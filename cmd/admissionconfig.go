@@ -0,0 +1,65 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kubernetes-sigs/pspmigrator"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var AdmissionConfigCmd = &cobra.Command{
+	Use:   "admission-config",
+	Short: "Generate a PodSecurity admission configuration with defaults and exemptions for the pods of the cluster",
+	Long: `Generate a PodSecurity admission configuration with defaults and exemptions for the pods of the cluster.
+
+The pods of all namespaces, including kube-system, are checked. Pods that need
+the privileged Pod Security Standard, i.e. pods that rely on privileged PSP
+objects, are exempted by their runtime class if all pods with that runtime
+class need it, and by their namespace otherwise. The enforced default is the
+most restrictive level all other pods run under, audit and warn default to
+restricted. Namespace labels still override the defaults.
+
+The AdmissionConfiguration is written to stdout and can be passed to the API
+server with --admission-control-config-file. The privileged pods and their
+service accounts are written to stderr.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pods, err := listPods(metav1.NamespaceAll, metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to list pods: %w", err)
+		}
+		suggestion, err := pspmigrator.SuggestPodSecurityConfiguration(pods.Items)
+		if err != nil {
+			return err
+		}
+		for _, pod := range suggestion.PrivilegedPods {
+			fmt.Fprintf(os.Stderr, "Pod %v needs the privileged Pod Security Standard\n", pod)
+		}
+		for _, serviceAccount := range suggestion.ServiceAccounts {
+			fmt.Fprintf(os.Stderr, "Service account %v runs privileged pods\n", serviceAccount)
+		}
+		admissionConfig, err := pspmigrator.AdmissionConfiguration(suggestion.Configuration)
+		if err != nil {
+			return err
+		}
+		return PrintManifests(os.Stdout, []runtime.Object{admissionConfig})
+	},
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kubernetes-sigs/pspmigrator"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var (
+	AuditLogSince     time.Duration
+	AuditLogSinceTime string
+	AuditLogUntilTime string
+)
+
+var AuditLogCmd = &cobra.Command{
+	Use:   "audit-log [file...]",
+	Short: "Report the PSP and PodSecurity admission decisions of API server audit logs",
+	Long: `Report the PSP and PodSecurity admission decisions of API server audit logs.
+
+The audit logs are read as JSON lines, as written by the log backend of the API
+server, from the given files or from stdin with -. Gzipped files, e.g. rotated
+logs, are decompressed. For every namespace and every user, including the
+service accounts of controllers, the report lists the PSP objects that
+admitted pods, the PodSecurity audit violations and the exemptions during the
+period covered by the logs. Use --since or --since-time and --until-time to
+limit the report to a soak period, e.g. when rotated logs from before it are
+passed as well.`,
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Args:        cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		report := pspmigrator.NewAuditReport()
+		if err := setAuditLogWindow(report, time.Now()); err != nil {
+			return err
+		}
+		for _, filename := range args {
+			if err := readAuditLog(report, filename); err != nil {
+				return fmt.Errorf("failed to read %v: %w", filename, err)
+			}
+		}
+		if report.Events == 0 {
+			fmt.Println("No PSP or PodSecurity admission decisions found")
+			return nil
+		}
+		fmt.Printf("%d admission decisions from %v to %v\n\n", report.Events,
+			report.From.Format(time.RFC3339), report.To.Format(time.RFC3339))
+		printAuditSummaries(os.Stdout, "Namespace", report.Namespaces)
+		fmt.Println()
+		printAuditSummaries(os.Stdout, "User", report.Users)
+		return nil
+	},
+}
+
+func init() {
+	AuditLogCmd.Flags().DurationVar(&AuditLogSince, "since", 0,
+		"Only report requests received within this duration, e.g. 168h for a soak period of a week")
+	AuditLogCmd.Flags().StringVar(&AuditLogSinceTime, "since-time", "",
+		"Only report requests received at or after this RFC3339 time")
+	AuditLogCmd.Flags().StringVar(&AuditLogUntilTime, "until-time", "",
+		"Only report requests received at or before this RFC3339 time")
+}
+
+// setAuditLogWindow limits the report to the window given by the flags.
+func setAuditLogWindow(report *pspmigrator.AuditReport, now time.Time) error {
+	if AuditLogSince != 0 && AuditLogSinceTime != "" {
+		return fmt.Errorf("only one of --since and --since-time may be given")
+	}
+	if AuditLogSince != 0 {
+		report.Since = now.Add(-AuditLogSince)
+	}
+	var err error
+	if AuditLogSinceTime != "" {
+		if report.Since, err = time.Parse(time.RFC3339, AuditLogSinceTime); err != nil {
+			return fmt.Errorf("invalid --since-time: %w", err)
+		}
+	}
+	if AuditLogUntilTime != "" {
+		if report.Until, err = time.Parse(time.RFC3339, AuditLogUntilTime); err != nil {
+			return fmt.Errorf("invalid --until-time: %w", err)
+		}
+	}
+	return nil
+}
+
+func readAuditLog(report *pspmigrator.AuditReport, filename string) error {
+	if filename == "-" {
+		return report.Read(os.Stdin)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return report.Read(f)
+}
+
+func printAuditSummaries(w io.Writer, keyHeader string, summaries map[string]*pspmigrator.AuditSummary) {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{keyHeader, "Decision", "Policy", "Requests"})
+	table.SetAutoWrapText(false)
+	keys := make([]string, 0, len(summaries))
+	for key := range summaries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		summary := summaries[key]
+		for _, d := range []struct {
+			decision string
+			counts   map[string]int
+		}{
+			{"Admitted by PSP", summary.PSPs},
+			{"PodSecurity audit violation", summary.AuditViolations},
+			{"PodSecurity exemption", summary.Exemptions},
+		} {
+			policies := make([]string, 0, len(d.counts))
+			for policy := range d.counts {
+				policies = append(policies, policy)
+			}
+			sort.Strings(policies)
+			for _, policy := range policies {
+				table.Append([]string{key, d.decision, policy, strconv.Itoa(d.counts[policy])})
+			}
+		}
+	}
+	table.Render()
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kubernetes-sigs/pspmigrator"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var (
+	CleanupSince     time.Duration
+	CleanupForce     bool
+	CleanupOutputDir string
+)
+
+const (
+	cleanupDeleteFile  = "psp-cleanup-delete.yaml"
+	cleanupReplaceFile = "psp-cleanup-replace.yaml"
+)
+
+var CleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Generate manifests to remove PSP objects and the RBAC rules that grant their use",
+	Long: `Generate manifests to remove PSP objects and the RBAC rules that grant their use.
+
+Once all namespaces are enforced by PSA, the PSP objects and the Roles,
+ClusterRoles and bindings that refer to podsecuritypolicies can be removed.
+Nothing is changed in the cluster, two manifests are written to --output-dir
+for review instead:
+
+  psp-cleanup-delete.yaml   the PSP objects, the roles that only have PSP
+                            rules and their bindings, for kubectl delete -f
+  psp-cleanup-replace.yaml  the roles that also grant other permissions, with
+                            only the PSP rules stripped, for kubectl replace -f
+
+The command refuses to generate the manifests while pods in any namespace,
+including kube-system, created in the last --since were admitted by a PSP
+object, unless --force is set. The PSP and RBAC objects are read from the
+cluster, or from a file with --filename. If the cluster no longer serves the
+PSP API, only the RBAC objects are cleaned up.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pods, err := GetAllPods()
+		if err != nil {
+			return fmt.Errorf("failed to get pods: %w", err)
+		}
+		printPSPAdmission(pods.Items)
+		if err := checkRecentlyAdmitted(pods.Items, time.Now()); err != nil {
+			return err
+		}
+
+		m, err := loadCleanupManifests()
+		if err != nil {
+			return err
+		}
+		cleanup := pspmigrator.PlanPSPCleanup(m)
+		if len(cleanup.Delete) == 0 && len(cleanup.Replace) == 0 {
+			fmt.Println("There are no PSP objects or RBAC rules that refer to them to clean up")
+			return nil
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Action", "Kind", "Namespace", "Name"})
+		if err := writeCleanupManifests(cleanupDeleteFile, "delete", cleanup.Delete, table); err != nil {
+			return err
+		}
+		if err := writeCleanupManifests(cleanupReplaceFile, "strip PSP rules", cleanup.Replace, table); err != nil {
+			return err
+		}
+		table.Render()
+		fmt.Println("Review the manifests and apply them by running:")
+		if len(cleanup.Replace) > 0 {
+			fmt.Printf("  kubectl replace -f %v\n", filepath.Join(CleanupOutputDir, cleanupReplaceFile))
+		}
+		if len(cleanup.Delete) > 0 {
+			fmt.Printf("  kubectl delete -f %v\n", filepath.Join(CleanupOutputDir, cleanupDeleteFile))
+		}
+		return nil
+	},
+}
+
+func init() {
+	CleanupCmd.Flags().DurationVar(&CleanupSince, "since", 24*time.Hour,
+		"Refuse to clean up if pods created within this duration were admitted by a PSP object")
+	CleanupCmd.Flags().BoolVar(&CleanupForce, "force", false,
+		"Generate the manifests even if PSP objects recently admitted pods")
+	CleanupCmd.Flags().StringVarP(&CleanupOutputDir, "output-dir", "o", ".",
+		"Directory to write the manifests to")
+	CleanupCmd.Flags().StringVarP(&Filename, "filename", "f", "",
+		"Read the PSP and RBAC objects from this file instead of the cluster, use - for stdin")
+}
+
+// checkRecentlyAdmitted lists the pods created in the last --since that were
+// admitted by a PSP object, and returns an error unless --force is set.
+func checkRecentlyAdmitted(pods []v1.Pod, now time.Time) error {
+	admitted := pspmigrator.RecentlyAdmittedPods(pods, CleanupSince, now)
+	if len(admitted) == 0 {
+		return nil
+	}
+	fmt.Printf("The table below shows the pods created in the last %v that were admitted by a PSP object\n", CleanupSince)
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Pod Name", "Namespace", "PSP", "Created"})
+	for _, pod := range admitted {
+		table.Append([]string{pod.Name, pod.Namespace, pod.Annotations[pspmigrator.PSPAdmissionAnnotation],
+			pod.CreationTimestamp.Format(time.RFC3339)})
+	}
+	table.Render()
+	if !CleanupForce {
+		return &ExitError{Code: ExitCodeFindings,
+			Err: fmt.Errorf("PSP objects are still admitting pods, use --force to clean them up anyway")}
+	}
+	fmt.Println("Cleaning up anyway because of --force")
+	return nil
+}
+
+// loadCleanupManifests returns the PSP and RBAC objects from --filename, or
+// from the cluster. Without the PSP API only the RBAC objects are returned,
+// since roles can still refer to podsecuritypolicies after the upgrade.
+func loadCleanupManifests() (*pspmigrator.Manifests, error) {
+	if Filename != "" {
+		return LoadManifests(Filename, true)
+	}
+	served, err := PSPAPIServed()
+	if err != nil {
+		return nil, err
+	}
+	if served {
+		return LoadManifests("", true)
+	}
+	fmt.Printf("Only cleaning up RBAC rules: %v\n", pspAPINotServed)
+	m := &pspmigrator.Manifests{}
+	if err := listRBAC(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// writeCleanupManifests writes the objects to the file in --output-dir and
+// adds them to the table with the action. Nothing is written if there are no
+// objects.
+func writeCleanupManifests(name, action string, objs []runtime.Object, table *tablewriter.Table) error {
+	if len(objs) == 0 {
+		return nil
+	}
+	for _, obj := range objs {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		table.Append([]string{action, obj.GetObjectKind().GroupVersionKind().Kind, accessor.GetNamespace(), accessor.GetName()})
+	}
+	f, err := os.Create(filepath.Join(CleanupOutputDir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := PrintManifests(f, objs); err != nil {
+		return fmt.Errorf("failed to write %v: %w", f.Name(), err)
+	}
+	return f.Close()
+}
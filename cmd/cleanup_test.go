@@ -0,0 +1,82 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kubernetes-sigs/pspmigrator"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newFakeClientset returns a fake clientset that honors the
+// metadata.namespace field selector when listing pods, like the API server.
+func newFakeClientset(objects ...runtime.Object) *fake.Clientset {
+	client := fake.NewSimpleClientset(objects...)
+	client.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		list := action.(k8stesting.ListAction)
+		obj, err := client.Tracker().List(list.GetResource(), v1.SchemeGroupVersion.WithKind("Pod"), list.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		selector := list.GetListRestrictions().Fields
+		pods := obj.(*v1.PodList)
+		items := make([]v1.Pod, 0, len(pods.Items))
+		for _, pod := range pods.Items {
+			if selector.Matches(fields.Set{"metadata.namespace": pod.Namespace}) {
+				items = append(items, pod)
+			}
+		}
+		pods.Items = items
+		return true, pods, nil
+	})
+	return client
+}
+
+func TestCleanupRefusesPodsAdmittedInKubeSystem(t *testing.T) {
+	now := time.Now()
+	clientset = newFakeClientset(&v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:              "kube-proxy-abcde",
+		Namespace:         "kube-system",
+		Annotations:       map[string]string{pspmigrator.PSPAdmissionAnnotation: "privileged"},
+		CreationTimestamp: metav1.NewTime(now.Add(-time.Hour)),
+	}})
+	CleanupSince, CleanupForce = 24*time.Hour, false
+
+	pods, err := GetAllPods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checkRecentlyAdmitted(pods.Items, now)
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != ExitCodeFindings {
+		t.Errorf("Expected the kube-system pod to block the cleanup, got %v", err)
+	}
+
+	CleanupForce = true
+	defer func() { CleanupForce = false }()
+	if err := checkRecentlyAdmitted(pods.Items, now); err != nil {
+		t.Errorf("Expected --force to clean up anyway, got %v", err)
+	}
+}
@@ -0,0 +1,304 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kubernetes-sigs/pspmigrator"
+	"github.com/spf13/cobra"
+	"k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	Filename                string
+	ConvertNamespaces       []string
+	ValidationFailureAction string
+	KyvernoMutate           bool
+)
+
+var ConvertCmd = &cobra.Command{
+	Use:   "convert",
+	Short: "Convert PSP controls that aren't covered by Pod Security Standards into other policies",
+	// The PSP objects can be read from files, the cluster is only accessed
+	// when they aren't.
+	Annotations: map[string]string{offlineAnnotation: "true"},
+}
+
+var convertKyvernoCmd = &cobra.Command{
+	Use:   "kyverno [name of PSP object...]",
+	Short: "Convert the PSP controls not implied by the Pod Security Standard the PSP maps to into Kyverno ClusterPolicies",
+	Long: `Convert the PSP controls not implied by the Pod Security Standard the PSP maps to into Kyverno ClusterPolicies.
+
+The PSP objects are read from the cluster, or from a file with --filename.
+All PSP objects are converted if no names are given. PSP objects that are fully
+covered by a Pod Security Standard don't result in a policy. Unless
+--namespaces is set, the policy of a PSP object only matches the namespaces
+with pods admitted by it, or with --filename the namespaces whose service
+accounts may use it. PSP objects that aren't used are skipped.
+
+With --mutate, policies with mutate rules that reproduce the defaults PSP
+objects set on pods are generated instead, as a stop-gap until the workloads
+set these fields themselves.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if ValidationFailureAction != "Audit" && ValidationFailureAction != "Enforce" {
+			return fmt.Errorf("invalid --validation-failure-action %q, must be Audit or Enforce", ValidationFailureAction)
+		}
+		if KyvernoMutate {
+			return convertKyvernoMutate(args)
+		}
+		m, err := LoadManifests(Filename, false)
+		if err != nil {
+			return err
+		}
+		psps, err := SelectPSPs(m.PSPs, args)
+		if err != nil {
+			return err
+		}
+		namespacesUsing, err := NamespacesUsingPSPs(Filename, m)
+		if err != nil {
+			return err
+		}
+		objs := make([]runtime.Object, 0, len(psps))
+		// PSP objects used in the same namespace are combined by PSP
+		// admission, while Kyverno enforces all policies.
+		pspsByNamespace := make(map[string][]string)
+		for i := range psps {
+			psp := &psps[i]
+			opts := pspmigrator.KyvernoOptions{
+				Namespaces:              ConvertNamespaces,
+				ValidationFailureAction: ValidationFailureAction,
+			}
+			if len(opts.Namespaces) == 0 {
+				namespaces, all := namespacesUsing(psp.Name)
+				if !all && len(namespaces) == 0 {
+					fmt.Fprintf(os.Stderr, "PSP %v isn't used by any namespace, skipping\n", psp.Name)
+					continue
+				}
+				opts.Namespaces = namespaces
+			}
+			policy := pspmigrator.KyvernoValidatePolicy(psp, opts)
+			if policy == nil {
+				fmt.Fprintf(os.Stderr, "PSP %v is fully covered by the %v Pod Security Standard\n",
+					psp.Name, pspmigrator.PSPPodSecurityStandard(psp))
+				continue
+			}
+			objs = append(objs, policy)
+			addPSPNamespaces(pspsByNamespace, psp.Name, opts.Namespaces)
+		}
+		warnOverlappingPSPs(pspsByNamespace)
+		return PrintManifests(os.Stdout, objs)
+	},
+}
+
+func init() {
+	ConvertCmd.PersistentFlags().StringVarP(&Filename, "filename", "f", "",
+		"Read the PSP objects from this file instead of the cluster, use - for stdin")
+	ConvertCmd.PersistentFlags().StringSliceVar(&ConvertNamespaces, "namespaces", nil,
+		"Limit the generated policies to pods in these namespaces, defaults to all namespaces")
+	convertKyvernoCmd.Flags().StringVar(&ValidationFailureAction, "validation-failure-action", "Audit",
+		"Action of the generated policies on violations, Audit or Enforce")
+	convertKyvernoCmd.Flags().BoolVar(&KyvernoMutate, "mutate", false,
+		"Generate mutate policies that reproduce the defaults of the PSP objects")
+	ConvertCmd.AddCommand(convertKyvernoCmd)
+}
+
+func convertKyvernoMutate(names []string) error {
+	m, err := LoadManifests(Filename, false)
+	if err != nil {
+		return err
+	}
+	psps, err := SelectPSPs(m.PSPs, names)
+	if err != nil {
+		return err
+	}
+	namespacesUsing, err := NamespacesUsingPSPs(Filename, m)
+	if err != nil {
+		return err
+	}
+	objs := make([]runtime.Object, 0, len(psps))
+	for i := range psps {
+		psp := &psps[i]
+		opts := pspmigrator.KyvernoOptions{Namespaces: ConvertNamespaces}
+		if len(opts.Namespaces) == 0 {
+			namespaces, all := namespacesUsing(psp.Name)
+			if !all && len(namespaces) == 0 {
+				fmt.Fprintf(os.Stderr, "PSP %v isn't used by any namespace, skipping\n", psp.Name)
+				continue
+			}
+			opts.Namespaces = namespaces
+		}
+		if policy := pspmigrator.KyvernoMutatePolicy(psp, opts); policy != nil {
+			objs = append(objs, policy)
+		} else {
+			fmt.Fprintf(os.Stderr, "PSP %v doesn't mutate pods\n", psp.Name)
+		}
+	}
+	return PrintManifests(os.Stdout, objs)
+}
+
+// NamespacesUsingPSPs returns a function that returns the namespaces using a
+// PSP object. These are the namespaces with pods admitted by the PSP object,
+// or if filename is set, the namespaces whose service accounts may use it
+// according to the RBAC objects of the manifests.
+func NamespacesUsingPSPs(filename string, m *pspmigrator.Manifests) (func(psp string) (namespaces []string, all bool), error) {
+	if filename != "" {
+		return m.PSPAuthorizations().Namespaces, nil
+	}
+	pods, err := GetPods()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	byPSP := make(map[string]sets.String)
+	for _, pod := range pods.Items {
+		if psp, ok := pod.Annotations["kubernetes.io/psp"]; ok {
+			if byPSP[psp] == nil {
+				byPSP[psp] = sets.NewString()
+			}
+			byPSP[psp].Insert(pod.Namespace)
+		}
+	}
+	return func(psp string) ([]string, bool) {
+		return byPSP[psp].List(), false
+	}, nil
+}
+
+// LoadPSPs returns the PSP objects with the given names from the file, or from
+// the cluster if filename is empty. All PSP objects are returned if no names
+// are given.
+func LoadPSPs(filename string, names []string) ([]v1beta1.PodSecurityPolicy, error) {
+	m, err := LoadManifests(filename, false)
+	if err != nil {
+		return nil, err
+	}
+	return SelectPSPs(m.PSPs, names)
+}
+
+// LoadManifests returns the PSP objects, and the RBAC objects if withRBAC is
+// set, from the file, or from the cluster if filename is empty.
+func LoadManifests(filename string, withRBAC bool) (*pspmigrator.Manifests, error) {
+	if filename != "" {
+		var r io.Reader = os.Stdin
+		if filename != "-" {
+			f, err := os.Open(filename)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			r = f
+		}
+		m, err := pspmigrator.DecodeManifests(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %v: %w", filename, err)
+		}
+		return m, nil
+	}
+
+	if err := initClientset(); err != nil {
+		return nil, err
+	}
+	if served, err := PSPAPIServed(); err != nil {
+		return nil, err
+	} else if !served {
+		return nil, fmt.Errorf("%s, use --filename to read exported PSP objects", pspAPINotServed)
+	}
+	m := &pspmigrator.Manifests{}
+	psps, err := clientset.PolicyV1beta1().PodSecurityPolicies().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PSP objects: %w", err)
+	}
+	m.PSPs = psps.Items
+	if !withRBAC {
+		return m, nil
+	}
+	if err := listRBAC(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// listRBAC adds the Roles, ClusterRoles and their bindings of the cluster to
+// the manifests.
+func listRBAC(m *pspmigrator.Manifests) error {
+	rbac := clientset.RbacV1()
+	roles, err := rbac.Roles(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list Roles: %w", err)
+	}
+	m.Roles = roles.Items
+	clusterRoles, err := rbac.ClusterRoles().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list ClusterRoles: %w", err)
+	}
+	m.ClusterRoles = clusterRoles.Items
+	roleBindings, err := rbac.RoleBindings(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list RoleBindings: %w", err)
+	}
+	m.RoleBindings = roleBindings.Items
+	clusterRoleBindings, err := rbac.ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list ClusterRoleBindings: %w", err)
+	}
+	m.ClusterRoleBindings = clusterRoleBindings.Items
+	return nil
+}
+
+// SelectPSPs returns the PSP objects with the given names, or all PSP objects
+// if no names are given.
+func SelectPSPs(psps []v1beta1.PodSecurityPolicy, names []string) ([]v1beta1.PodSecurityPolicy, error) {
+	if len(names) == 0 {
+		return psps, nil
+	}
+	byName := make(map[string]v1beta1.PodSecurityPolicy, len(psps))
+	for _, psp := range psps {
+		byName[psp.Name] = psp
+	}
+	selected := make([]v1beta1.PodSecurityPolicy, 0, len(names))
+	for _, name := range names {
+		psp, ok := byName[name]
+		if !ok {
+			return nil, &pspmigrator.PSPNotFoundError{Name: name}
+		}
+		selected = append(selected, psp)
+	}
+	return selected, nil
+}
+
+// PrintManifests writes the objects as YAML documents separated by ---.
+func PrintManifests(w io.Writer, objs []runtime.Object) error {
+	for i, obj := range objs {
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(w, "---")
+		}
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
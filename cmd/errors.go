@@ -0,0 +1,127 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/kubernetes-sigs/pspmigrator"
+	"github.com/olekukonko/tablewriter"
+)
+
+// Exit codes of pspmigrator. Commands exit with ExitCodeFindings when they
+// found something that needs to be fixed before migrating, e.g. pods that are
+// mutated by a PSP object, and with ExitCodePartialFailure when some objects
+// couldn't be checked but the others were.
+const (
+	ExitCodeFatal          = 1
+	ExitCodeFindings       = 2
+	ExitCodePartialFailure = 3
+)
+
+// ExitError makes pspmigrator exit with Code. Err is printed unless it's nil,
+// e.g. for findings that the command already reported.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit code %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// Execute runs RootCmd and returns the exit code. Errors are printed to
+// stderr.
+func Execute() int {
+	err := RootCmd.Execute()
+	if err == nil {
+		return 0
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		if exitErr.Err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", exitErr.Err)
+		}
+		return exitErr.Code
+	}
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	return ExitCodeFatal
+}
+
+// ScanErrors collects the errors of single objects, so a command can continue
+// with the other objects and report all errors at the end.
+type ScanErrors struct {
+	rows [][]string
+}
+
+// Add records the error of an object, e.g. "pod default/nginx".
+func (s *ScanErrors) Add(object string, err error) {
+	s.rows = append(s.rows, []string{object, errorKind(err), err.Error()})
+}
+
+// Len returns the number of errors.
+func (s *ScanErrors) Len() int {
+	return len(s.rows)
+}
+
+// Result prints the summary of the errors and returns the ExitError of the
+// command, or nil if there were neither errors nor findings.
+func (s *ScanErrors) Result(findings bool) error {
+	if s.Len() > 0 {
+		fmt.Printf("The table below shows the %d objects that couldn't be checked\n", s.Len())
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Object", "Kind", "Error"})
+		table.AppendBulk(s.rows)
+		table.Render()
+		return &ExitError{Code: ExitCodePartialFailure}
+	}
+	if findings {
+		return &ExitError{Code: ExitCodeFindings}
+	}
+	return nil
+}
+
+// errorKind returns a short description of the typed errors of the
+// pspmigrator package.
+func errorKind(err error) string {
+	var (
+		unsupported *pspmigrator.UnsupportedOwnerError
+		notFound    *pspmigrator.OwnerNotFoundError
+		forbidden   *pspmigrator.ForbiddenError
+		pspNotFound *pspmigrator.PSPNotFoundError
+	)
+	switch {
+	case errors.As(err, &unsupported):
+		return "unsupported owner"
+	case errors.As(err, &notFound):
+		return "owner not found"
+	case errors.As(err, &forbidden):
+		return "forbidden"
+	case errors.As(err, &pspNotFound):
+		return "PSP not found"
+	}
+	return "error"
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/kubernetes-sigs/pspmigrator"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+var (
+	EnforcementAction string
+	WithTemplates     bool
+)
+
+var convertGatekeeperCmd = &cobra.Command{
+	Use:   "gatekeeper [name of PSP object...]",
+	Short: "Convert the PSP controls not implied by the Pod Security Standard the PSP maps to into Gatekeeper constraints",
+	Long: `Convert the PSP controls not implied by the Pod Security Standard the PSP maps to into Gatekeeper constraints.
+
+The constraints of a PSP object only match pods in the namespaces whose service
+accounts are authorized to use the PSP object through RBAC, unless --namespaces
+is set. The ConstraintTemplates of the constraints are included unless
+--templates=false, e.g. because the gatekeeper-library is already installed.
+
+The PSP and RBAC objects are read from the cluster, or from a file with
+--filename. All PSP objects are converted if no names are given.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch EnforcementAction {
+		case "deny", "dryrun", "warn":
+		default:
+			return fmt.Errorf("invalid --enforcement-action %q, must be deny, dryrun or warn", EnforcementAction)
+		}
+		m, err := LoadManifests(Filename, len(ConvertNamespaces) == 0)
+		if err != nil {
+			return err
+		}
+		psps, err := SelectPSPs(m.PSPs, args)
+		if err != nil {
+			return err
+		}
+		authorizations := m.PSPAuthorizations()
+
+		templates := sets.NewString()
+		constraints := make([]runtime.Object, 0)
+		// PSP objects authorized in the same namespace are combined by PSP
+		// admission, while Gatekeeper enforces all constraints.
+		pspsByNamespace := make(map[string][]string)
+		for i := range psps {
+			psp := &psps[i]
+			opts := pspmigrator.GatekeeperOptions{
+				Namespaces:        ConvertNamespaces,
+				EnforcementAction: EnforcementAction,
+			}
+			if len(opts.Namespaces) == 0 {
+				namespaces, all := authorizations.Namespaces(psp.Name)
+				if !all && len(namespaces) == 0 {
+					fmt.Fprintf(os.Stderr, "PSP %v isn't used by the service accounts of any namespace, skipping\n", psp.Name)
+					continue
+				}
+				opts.Namespaces = namespaces
+			}
+
+			objs, unsupported := pspmigrator.GatekeeperConstraints(psp, opts)
+			if len(objs) == 0 && len(unsupported) == 0 {
+				fmt.Fprintf(os.Stderr, "PSP %v is fully covered by the %v Pod Security Standard\n",
+					psp.Name, pspmigrator.PSPPodSecurityStandard(psp))
+				continue
+			}
+			for _, control := range unsupported {
+				fmt.Fprintf(os.Stderr, "PSP %v: the %v control can't be converted into a constraint\n", psp.Name, control)
+			}
+			for _, obj := range objs {
+				templates.Insert(obj.GetKind())
+				constraints = append(constraints, obj)
+			}
+			addPSPNamespaces(pspsByNamespace, psp.Name, opts.Namespaces)
+		}
+		warnOverlappingPSPs(pspsByNamespace)
+
+		objs := make([]runtime.Object, 0, templates.Len()+len(constraints))
+		if WithTemplates {
+			for _, kind := range templates.List() {
+				template, err := pspmigrator.GatekeeperConstraintTemplate(kind)
+				if err != nil {
+					return err
+				}
+				objs = append(objs, template)
+			}
+		}
+		return PrintManifests(os.Stdout, append(objs, constraints...))
+	},
+}
+
+func init() {
+	convertGatekeeperCmd.Flags().StringVar(&EnforcementAction, "enforcement-action", "dryrun",
+		"Enforcement action of the generated constraints, deny, dryrun or warn")
+	convertGatekeeperCmd.Flags().BoolVar(&WithTemplates, "templates", true,
+		"Include the ConstraintTemplates of the generated constraints")
+	ConvertCmd.AddCommand(convertGatekeeperCmd)
+}
+
+// addPSPNamespaces records the namespaces the policies converted from the PSP
+// object match, all namespaces if there are none.
+func addPSPNamespaces(pspsByNamespace map[string][]string, psp string, namespaces []string) {
+	if len(namespaces) == 0 {
+		pspsByNamespace["*"] = append(pspsByNamespace["*"], psp)
+	}
+	for _, ns := range namespaces {
+		pspsByNamespace[ns] = append(pspsByNamespace[ns], psp)
+	}
+}
+
+// warnOverlappingPSPs warns about namespaces whose pods are checked against
+// the policies converted from multiple PSP objects. A pod only has to be
+// allowed by one of the PSP objects, but it has to satisfy the policies of
+// all of them.
+func warnOverlappingPSPs(pspsByNamespace map[string][]string) {
+	namespaces := make([]string, 0, len(pspsByNamespace))
+	for ns := range pspsByNamespace {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	for _, ns := range namespaces {
+		psps := append(append([]string{}, pspsByNamespace[ns]...), pspsByNamespace["*"]...)
+		if ns == "*" {
+			psps = pspsByNamespace[ns]
+			ns = "every namespace"
+		}
+		if len(psps) > 1 {
+			fmt.Fprintf(os.Stderr, "Warning: pods in %v must satisfy the policies converted from all of the PSP objects %v, "+
+				"while PSP admission only required one of them\n", ns, strings.Join(psps, ", "))
+		}
+	}
+}
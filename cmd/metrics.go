@@ -0,0 +1,113 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	podsDesc = prometheus.NewDesc(
+		"pspmigrator_pods",
+		"Number of pods in the namespace.",
+		[]string{"namespace"}, nil)
+	podsMutatedDesc = prometheus.NewDesc(
+		"pspmigrator_pods_mutated_by_psp",
+		"Number of pods in the namespace that are mutated by the PSP object.",
+		[]string{"namespace", "psp"}, nil)
+	suggestedLevelDesc = prometheus.NewDesc(
+		"pspmigrator_namespace_suggested_level",
+		"Pod Security Standard suggested for the namespace based on its pods. The value is always 1.",
+		[]string{"namespace", "level"}, nil)
+	appliedLevelDesc = prometheus.NewDesc(
+		"pspmigrator_namespace_applied_level",
+		"Pod Security Standard applied to the namespace by a PSA label per mode. The value is always 1.",
+		[]string{"namespace", "mode", "level"}, nil)
+	blockingRestrictedDesc = prometheus.NewDesc(
+		"pspmigrator_workloads_blocking_restricted",
+		"Number of workloads in the namespace with pods that violate the restricted Pod Security Standard.",
+		[]string{"namespace"}, nil)
+	namespacesWithoutLabelsDesc = prometheus.NewDesc(
+		"pspmigrator_namespaces_without_psa_labels",
+		"Number of namespaces without any PSA labels.",
+		nil, nil)
+	errorsDesc = prometheus.NewDesc(
+		"pspmigrator_namespace_errors",
+		"Number of errors that occurred the last time the namespace was evaluated.",
+		[]string{"namespace"}, nil)
+)
+
+// StatusCollector exposes the namespace status of a Watcher as Prometheus
+// metrics. The metrics are computed from the current status on every scrape.
+type StatusCollector struct {
+	watcher *Watcher
+}
+
+// NewStatusCollector returns a collector for the status of the watcher.
+func NewStatusCollector(w *Watcher) *StatusCollector {
+	return &StatusCollector{watcher: w}
+}
+
+// Describe implements prometheus.Collector.
+func (c *StatusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- podsDesc
+	ch <- podsMutatedDesc
+	ch <- suggestedLevelDesc
+	ch <- appliedLevelDesc
+	ch <- blockingRestrictedDesc
+	ch <- namespacesWithoutLabelsDesc
+	ch <- errorsDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *StatusCollector) Collect(ch chan<- prometheus.Metric) {
+	withoutLabels := 0
+	for _, status := range c.watcher.Status() {
+		ch <- prometheus.MustNewConstMetric(podsDesc, prometheus.GaugeValue, float64(status.Pods), status.Name)
+
+		mutatedByPSP := make(map[string]int)
+		for _, pod := range status.MutatedPods {
+			mutatedByPSP[pod.PSP]++
+		}
+		for psp, count := range mutatedByPSP {
+			ch <- prometheus.MustNewConstMetric(podsMutatedDesc, prometheus.GaugeValue, float64(count), status.Name, psp)
+		}
+
+		if status.SuggestedLevel != "" {
+			ch <- prometheus.MustNewConstMetric(suggestedLevelDesc, prometheus.GaugeValue, 1,
+				status.Name, string(status.SuggestedLevel))
+		}
+
+		if len(status.PSALabels) == 0 {
+			withoutLabels++
+		}
+		for label, level := range status.PSALabels {
+			mode := strings.TrimPrefix(label, "pod-security.kubernetes.io/")
+			if strings.HasSuffix(mode, "-version") {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(appliedLevelDesc, prometheus.GaugeValue, 1, status.Name, mode, level)
+		}
+
+		ch <- prometheus.MustNewConstMetric(blockingRestrictedDesc, prometheus.GaugeValue,
+			float64(len(status.BlockingRestricted)), status.Name)
+		ch <- prometheus.MustNewConstMetric(errorsDesc, prometheus.GaugeValue, float64(len(status.Errors)), status.Name)
+	}
+	ch <- prometheus.MustNewConstMetric(namespacesWithoutLabelsDesc, prometheus.GaugeValue, float64(withoutLabels))
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	psaapi "k8s.io/pod-security-admission/api"
+)
+
+func TestStatusCollector(t *testing.T) {
+	w := &Watcher{status: map[string]*NamespaceStatus{
+		"apps": {
+			Name:           "apps",
+			Pods:           3,
+			SuggestedLevel: psaapi.LevelBaseline,
+			PSALabels: map[string]string{
+				"pod-security.kubernetes.io/enforce":         "privileged",
+				"pod-security.kubernetes.io/enforce-version": "latest",
+				"pod-security.kubernetes.io/warn":            "baseline",
+			},
+			MutatedPods: []MutatedPod{
+				{Name: "web-1", PSP: "default"},
+				{Name: "web-2", PSP: "default"},
+				{Name: "db-0", PSP: "database"},
+			},
+		},
+		"batch": {
+			Name:           "batch",
+			Pods:           1,
+			SuggestedLevel: psaapi.LevelRestricted,
+		},
+	}}
+
+	expected := `
+# HELP pspmigrator_namespace_applied_level Pod Security Standard applied to the namespace by a PSA label per mode. The value is always 1.
+# TYPE pspmigrator_namespace_applied_level gauge
+pspmigrator_namespace_applied_level{level="baseline",mode="warn",namespace="apps"} 1
+pspmigrator_namespace_applied_level{level="privileged",mode="enforce",namespace="apps"} 1
+# HELP pspmigrator_namespace_suggested_level Pod Security Standard suggested for the namespace based on its pods. The value is always 1.
+# TYPE pspmigrator_namespace_suggested_level gauge
+pspmigrator_namespace_suggested_level{level="baseline",namespace="apps"} 1
+pspmigrator_namespace_suggested_level{level="restricted",namespace="batch"} 1
+# HELP pspmigrator_namespaces_without_psa_labels Number of namespaces without any PSA labels.
+# TYPE pspmigrator_namespaces_without_psa_labels gauge
+pspmigrator_namespaces_without_psa_labels 1
+# HELP pspmigrator_pods_mutated_by_psp Number of pods in the namespace that are mutated by the PSP object.
+# TYPE pspmigrator_pods_mutated_by_psp gauge
+pspmigrator_pods_mutated_by_psp{namespace="apps",psp="database"} 1
+pspmigrator_pods_mutated_by_psp{namespace="apps",psp="default"} 2
+`
+	if err := testutil.CollectAndCompare(NewStatusCollector(w), strings.NewReader(expected),
+		"pspmigrator_namespace_applied_level",
+		"pspmigrator_namespace_suggested_level",
+		"pspmigrator_namespaces_without_psa_labels",
+		"pspmigrator_pods_mutated_by_psp",
+	); err != nil {
+		t.Error(err)
+	}
+}
@@ -20,19 +20,30 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/kubernetes-sigs/pspmigrator"
 	"github.com/manifoldco/promptui"
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 	v1 "k8s.io/api/core/v1"
-	psaapi "k8s.io/pod-security-admission/api"
 )
 
-var DryRun bool
+var (
+	DryRun    bool
+	WarnRule  string
+	AuditRule string
+)
 
 func init() {
 	MigrateCmd.Flags().BoolVarP(&DryRun, "dry-run", "d", true, "Set dry run to true to not apply any changes")
+	MigrateCmd.Flags().StringVar(&WarnRule, "warn", string(pspmigrator.DefaultRecommendationPolicy.Warn),
+		"Suggested warn level, a level such as restricted or +N for N levels above the enforced level")
+	MigrateCmd.Flags().StringVar(&AuditRule, "audit", string(pspmigrator.DefaultRecommendationPolicy.Audit),
+		"Suggested audit level, a level such as restricted or +N for N levels above the enforced level")
+	MigrateCmd.Flags().BoolVar(&SkipPreflight, "skip-preflight", false,
+		"Don't check the permissions of the current user before migrating")
+	addPodFilterFlags(MigrateCmd.Flags())
 }
 
 var MigrateCmd = &cobra.Command{
@@ -40,21 +51,69 @@ var MigrateCmd = &cobra.Command{
 	Short: "Interactive command to migrate from PSP to PSA ",
 	Long: `The interactive command will help with setting a suggested a
 	Suggested Pod Security Standard for each namespace. In addition, it also
-	checks whether a PSP object is mutating pods in every namespace.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	checks whether a PSP object is mutating pods in every namespace.
+
+	The most restrictive level all pods of a namespace pass is suggested for
+	enforce, the warn and audit levels are derived from it with --warn and
+	--audit, by default one level above the enforced level. The pods that
+	fail the levels above the enforced level are listed with the reason.
+
+	Finished pods, pods whose controller no longer exists and, with
+	--max-unmanaged-age, old pods without a controller are excluded from the
+	suggestion. Ephemeral containers are left out of the pods, and with
+	--exclude-injected-sidecars the sidecars injected by Istio and Linkerd as
+	well. The excluded pods and containers are listed at the end.
+
+	The first line reports whether PSP admission is enabled, disabled or
+	unknown, inferred from the kubernetes.io/psp annotation of pods created
+	in the last 24 hours and, with --probe-psp-admission, a probe pod.
+
+	Before migrating, the permissions of the current user are checked like
+	the preflight command does.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if SkipPreflight {
+			return nil
+		}
+		return runPreflight(DryRun)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var recommendationPolicy pspmigrator.RecommendationPolicy
+		var err error
+		if recommendationPolicy.Warn, err = pspmigrator.ParseLevelRule(WarnRule); err != nil {
+			return fmt.Errorf("invalid --warn: %w", err)
+		}
+		if recommendationPolicy.Audit, err = pspmigrator.ParseLevelRule(AuditRule); err != nil {
+			return fmt.Errorf("invalid --audit: %w", err)
+		}
 		pods, err := GetPods()
 		if err != nil {
-			log.Fatalln("Error getting pods", err.Error())
+			return fmt.Errorf("failed to get pods: %w", err)
+		}
+		printPSPAdmission(pods.Items)
+		served, err := PSPAPIServed()
+		if err != nil {
+			return err
 		}
-		fmt.Println("Checking if any pods are being mutated by a PSP object")
+		var scanErrors ScanErrors
 		mutatedPods := make([]v1.Pod, 0)
-		for _, pod := range pods.Items {
-			mutated, _, err := pspmigrator.IsPodBeingMutatedByPSP(&pod, clientset)
-			if err != nil {
-				log.Fatalln(err)
+		checkedPods := pods.Items
+		if served {
+			fmt.Println("Checking if any pods are being mutated by a PSP object")
+		} else {
+			fmt.Printf("Skipping the check for pods mutated by a PSP object: %v\n", pspAPINotServed)
+			checkedPods = nil
+		}
+		for _, result := range CheckPodsMutatedByPSP(checkedPods) {
+			if result.Err != nil {
+				scanErrors.Add(fmt.Sprintf("pod %v/%v", result.Pod.Namespace, result.Pod.Name), result.Err)
+				continue
 			}
-			if mutated {
-				mutatedPods = append(mutatedPods, pod)
+			switch result.Mutation.Status {
+			case pspmigrator.PodMutated:
+				mutatedPods = append(mutatedPods, *result.Pod)
+			case pspmigrator.PodMutationUnknown, pspmigrator.PodMutationNoBaseline:
+				fmt.Printf("Unable to check if pod %v in namespace %v is mutated: %v\n",
+					result.Pod.Name, result.Pod.Namespace, result.Mutation.Reason)
 			}
 		}
 		if len(mutatedPods) > 0 {
@@ -72,13 +131,17 @@ var MigrateCmd = &cobra.Command{
 			fmt.Printf("There were %v pods mutated. Please modify the PodSpec such that PSP no longer needs to mutate your pod.\n", len(mutatedPods))
 			fmt.Printf("You can run `pspmigrator mutating pod %v -n %v` to learn more why and how your pod is being mutated. ", pod.Name, pod.Namespace)
 			fmt.Printf("Please re-run the tool again after you've modified your PodSpecs.\n")
-			os.Exit(1)
+			return scanErrors.Result(true)
 		}
 
 		namespaces, err := GetNamespaces()
 		if err != nil {
-			log.Fatalln("Error getting namespaces:", err.Error())
+			return fmt.Errorf("failed to get namespaces: %w", err)
 		}
+		podFilter := newPodFilter()
+		excludedPods := make([]pspmigrator.ExcludedPod, 0)
+		exclusions := levelExclusions()
+		excludedContainers := make([][]string, 0)
 		for _, namespace := range namespaces.Items {
 			// Check if namespace already has psa labels
 			if NamespaceHasPSALabels(&namespace) {
@@ -87,46 +150,48 @@ var MigrateCmd = &cobra.Command{
 					namespace.Name, namespace.Labels)
 				continue
 			}
-			suggestions := make(map[psaapi.Level]bool)
 			podList, err := GetPodsByNamespace(namespace.Name)
 			if err != nil {
-				log.Printf("Error getting pods for namespace %v. Error: %v\n", namespace.Name, err.Error())
-				log.Println("Continuing with next namespace")
+				scanErrors.Add("namespace "+namespace.Name, err)
 				continue
 			}
-			pods := podList.Items
+			pods, excluded := podFilter.Filter(podList.Items, time.Now())
+			excludedPods = append(excludedPods, excluded...)
 			if len(pods) == 0 {
 				fmt.Printf("There are no pods running in namespace %v. Skipping and going to the next one.\n", namespace.Name)
 				continue
 			}
+			pods, excludedByPod := exclusions.ApplyAll(pods)
 			for _, pod := range pods {
-				level, err := pspmigrator.SuggestedPodSecurityStandard(&pod)
-				if err != nil {
-					fmt.Println("error occured checking the suggested pod security standard", err)
-					fmt.Println("Continuing with the next namespace due to error with ", namespace.Name)
-					continue
+				for _, container := range excludedByPod[pod.Name] {
+					excludedContainers = append(excludedContainers, []string{
+						container.Name, pod.Name, pod.Namespace, container.Reason})
 				}
-				suggestions[level] = true
 			}
-			var suggested psaapi.Level
-			switch {
-			case suggestions["privileged"]:
-				suggested = psaapi.LevelPrivileged
-			case suggestions["baseline"]:
-				suggested = psaapi.LevelBaseline
-			case suggestions["restricted"]:
-				suggested = psaapi.LevelRestricted
+			recommendation, err := pspmigrator.RecommendNamespaceLevels(pods, recommendationPolicy)
+			if err != nil {
+				scanErrors.Add("namespace "+namespace.Name, err)
+				continue
 			}
+			suggested := recommendation.Enforce
 			fmt.Printf("Suggest using %v in namespace %v\n", suggested, namespace.Name)
+			fmt.Printf("Suggested labels: enforce=%v, warn=%v, audit=%v\n",
+				recommendation.Enforce, recommendation.Warn, recommendation.Audit)
+			for _, violation := range recommendation.Violations {
+				fmt.Printf("  Pod %v fails %v: %v\n", violation.Pod, violation.Level, violation.Reason)
+			}
 			if DryRun == true {
 				fmt.Printf("In dry-run mode so not applying any changes. You can run this ")
-				fmt.Printf("command again with --dry-run=false to apply %v on namespace %v\n", suggested, namespace.Name)
+				fmt.Printf("command again with --dry-run=false to apply the labels %v on namespace %v\n",
+					recommendation.Labels(), namespace.Name)
 			} else {
 				skipStr := "skip, continue with next namespace"
+				allStr := fmt.Sprintf("all modes (enforce=%v, warn=%v, audit=%v)",
+					recommendation.Enforce, recommendation.Warn, recommendation.Audit)
 				// TODO add ability to set this as a flag for all namespaces instead of prompting per namespace
 				prompt := promptui.Select{
 					Label: fmt.Sprintf("Select control mode for %v on namespace %v", suggested, namespace.Name),
-					Items: []string{"enforce", "audit", skipStr},
+					Items: []string{allStr, "enforce", "audit", skipStr},
 				}
 				_, control, err := prompt.Run()
 				if err != nil {
@@ -135,14 +200,40 @@ var MigrateCmd = &cobra.Command{
 				if control == skipStr {
 					continue
 				}
+				if control == allStr {
+					if err := ApplyPSSLabels(&namespace, recommendation.Labels()); err != nil {
+						scanErrors.Add("namespace "+namespace.Name, err)
+						continue
+					}
+					fmt.Printf("Applied labels %v on namespace %v\n", recommendation.Labels(), namespace.Name)
+					fmt.Printf("Review the labels by running `kubectl get ns %v -o yaml`\n", namespace.Name)
+					continue
+				}
 				if err := ApplyPSSLevel(&namespace, suggested, control); err != nil {
-					log.Printf("Error applying %v on namespace %v. Error: %v\n", suggested, namespace.Name, err.Error())
+					scanErrors.Add("namespace "+namespace.Name, err)
+					continue
 				}
 				fmt.Printf("Applied pod security level %v on namespace %v in %v control mode\n", suggested, namespace.Name, control)
 				fmt.Printf("Review the labels by running `kubectl get ns %v -o yaml`\n", namespace.Name)
 			}
 		}
+		if len(excludedPods) > 0 {
+			fmt.Println("The table below shows the pods that were excluded from the suggestions")
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"Pod Name", "Namespace", "Reason"})
+			for _, excluded := range excludedPods {
+				table.Append([]string{excluded.Pod.Name, excluded.Pod.Namespace, excluded.Reason})
+			}
+			table.Render()
+		}
+		if len(excludedContainers) > 0 {
+			fmt.Println("The table below shows the containers that were excluded from the suggestions")
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"Container", "Pod Name", "Namespace", "Reason"})
+			table.AppendBulk(excludedContainers)
+			table.Render()
+		}
 		fmt.Println("Done with migrating namespaces with pods to PSA")
-
+		return scanErrors.Result(false)
 	},
 }
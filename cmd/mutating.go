@@ -19,13 +19,13 @@ package cmd
 import (
 	"context"
 	"fmt"
-	"log"
 	"os"
 	"strconv"
 
 	"github.com/kubernetes-sigs/pspmigrator"
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -39,70 +39,94 @@ func initMutating() {
 	podCmd := cobra.Command{
 		Use:   "pod [name of pod]",
 		Short: "Check if a pod is being mutated by a PSP policy",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			pod := args[0]
 			podObj, err := clientset.CoreV1().Pods(Namespace).Get(context.TODO(), pod, metav1.GetOptions{})
 			if errors.IsNotFound(err) {
-				fmt.Printf("Pod %s in namespace %s not found\n", pod, Namespace)
-				os.Exit(1)
-			} else if statusError, isStatus := err.(*errors.StatusError); isStatus {
-				fmt.Printf("Error getting pod %s in namespace %s: %v\n",
-					pod, Namespace, statusError.ErrStatus.Message)
-				os.Exit(1)
+				return fmt.Errorf("pod %s in namespace %s not found", pod, Namespace)
 			} else if err != nil {
-				log.Fatalln(err.Error())
-				os.Exit(1)
-			} else {
-				mutated, diff, err := pspmigrator.IsPodBeingMutatedByPSP(podObj, clientset)
-				if err != nil {
-					log.Println(err)
-					os.Exit(1)
+				return fmt.Errorf("failed to get pod %s in namespace %s: %w", pod, Namespace, err)
+			}
+			printPSPAdmission([]v1.Pod{*podObj})
+			mutation, err := mutationChecker.CheckPod(podObj)
+			if err != nil {
+				return err
+			}
+			switch mutation.Status {
+			case pspmigrator.PodMutationUnknown, pspmigrator.PodMutationNoBaseline:
+				return &ExitError{Code: ExitCodePartialFailure,
+					Err: fmt.Errorf("unable to check if pod %v is mutated: %v", podObj.Name, mutation.Reason)}
+			case pspmigrator.PodNotMutated:
+				if mutation.Reason != "" {
+					fmt.Printf("Pod %v isn't mutated: %v\n", podObj.Name, mutation.Reason)
 				}
-				if pspName, ok := podObj.ObjectMeta.Annotations["kubernetes.io/psp"]; ok {
-					fmt.Printf("Pod %v is mutated by PSP %v: %v, diff: %v\n", podObj.Name, pspName, mutated, diff)
-					pspObj, err := clientset.PolicyV1beta1().PodSecurityPolicies().Get(context.TODO(), pspName, metav1.GetOptions{})
-					if errors.IsNotFound(err) {
-						fmt.Printf("PodSecurityPolicy %s not found\n", pspName)
-					} else if statusError, isStatus := err.(*errors.StatusError); isStatus {
-						fmt.Printf("Error getting PodSecurityPolicy %s: %v\n",
-							pspName, statusError.ErrStatus.Message)
-					} else if err != nil {
-						panic(err.Error())
-					} else {
-						_, fields, annotations := pspmigrator.IsPSPMutating(pspObj)
-						fmt.Printf("PSP profile %v has the following mutating fields: %v and annotations: %v\n", pspName, fields, annotations)
-					}
-
+			}
+			_, excluded := mutationChecker.Exclusions.Apply(podObj)
+			for _, container := range excluded {
+				fmt.Printf("Container %v was excluded from the check: %v\n", container.Name, container.Reason)
+			}
+			var scanErrors ScanErrors
+			if pspName, ok := podObj.ObjectMeta.Annotations["kubernetes.io/psp"]; ok {
+				fmt.Printf("Pod %v is mutated by PSP %v: %v, diff: %v\n", podObj.Name, pspName,
+					mutation.Status == pspmigrator.PodMutated, mutation.Diff)
+				pspObj, err := loadPSP(pspName)
+				if err != nil {
+					scanErrors.Add("PodSecurityPolicy "+pspName, err)
+				} else if pspObj == nil {
+					fmt.Printf("Skipping the check of PSP %v: %v. Use --filename to read exported PSP objects\n",
+						pspName, pspAPINotServed)
+				} else {
+					_, fields, annotations := pspmigrator.IsPSPMutating(pspObj)
+					fmt.Printf("PSP profile %v has the following mutating fields: %v and annotations: %v\n", pspName, fields, annotations)
 				}
 			}
+			return scanErrors.Result(mutation.Status == pspmigrator.PodMutated)
 		},
 		Args: cobra.ExactArgs(1),
 	}
 
-	podCmd.Flags().StringVarP(&Namespace, "namespace", "n", "", "K8s namespace (required)")
-	podCmd.MarkFlagRequired("namespace")
-
 	podsCmd := cobra.Command{
 		Use:   "pods",
 		Short: "Check all pods across all namespaces in a cluster are being mutated by a PSP policy",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			table := tablewriter.NewWriter(os.Stdout)
 			table.SetHeader([]string{"Name", "Namespace", "Mutated", "PSP"})
 			pods, err := GetPods()
 			if err != nil {
-				log.Fatalln("Error getting pods", err.Error())
+				return fmt.Errorf("failed to get pods: %w", err)
 			}
+			printPSPAdmission(pods.Items)
 			fmt.Printf("There are %d pods in the cluster\n", len(pods.Items))
+			pspPods := make([]v1.Pod, 0)
 			for _, pod := range pods.Items {
-				if pspName, ok := pod.ObjectMeta.Annotations["kubernetes.io/psp"]; ok {
-					mutated, _, err := pspmigrator.IsPodBeingMutatedByPSP(&pod, clientset)
-					if err != nil {
-						log.Println("error occured checking if pod is mutated:", err)
-					}
-					table.Append([]string{pod.Name, pod.Namespace, strconv.FormatBool(mutated), pspName})
+				if _, ok := pod.ObjectMeta.Annotations["kubernetes.io/psp"]; ok {
+					pspPods = append(pspPods, pod)
+				}
+			}
+			var scanErrors ScanErrors
+			findings := false
+			for _, result := range CheckPodsMutatedByPSP(pspPods) {
+				mutated := "error"
+				if result.Err != nil {
+					scanErrors.Add(fmt.Sprintf("pod %v/%v", result.Pod.Namespace, result.Pod.Name), result.Err)
+				} else if status := result.Mutation.Status; status == pspmigrator.PodMutated || status == pspmigrator.PodNotMutated {
+					mutated = strconv.FormatBool(status == pspmigrator.PodMutated)
+					findings = findings || status == pspmigrator.PodMutated
+				} else {
+					mutated = fmt.Sprintf("unknown, %v", result.Mutation.Reason)
 				}
+				pod := result.Pod
+				table.Append([]string{pod.Name, pod.Namespace, mutated, pod.ObjectMeta.Annotations["kubernetes.io/psp"]})
 			}
 			table.Render() // Send output
+			for _, pod := range pspPods {
+				_, excluded := mutationChecker.Exclusions.Apply(&pod)
+				for _, container := range excluded {
+					fmt.Printf("Container %v of pod %v in namespace %v was excluded from the check: %v\n",
+						container.Name, pod.Name, pod.Namespace, container.Reason)
+				}
+			}
+			return scanErrors.Result(findings)
 		},
 		Args: cobra.NoArgs,
 	}
@@ -110,31 +134,34 @@ func initMutating() {
 	pspCmd := cobra.Command{
 		Use:   "psp [name of PSP object]",
 		Short: "Check if a PSP object is potentially mutating pods",
-		Run: func(cmd *cobra.Command, args []string) {
-			// Examples for error handling:
-			// - Use helper functions like e.g. errors.IsNotFound()
-			// - And/or cast to StatusError and use its properties like e.g. ErrStatus.Message
+		// The PSP object can be read from a file, the cluster is only
+		// accessed when it isn't.
+		Annotations: map[string]string{offlineAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if Filename == "" {
+				if err := initClientset(); err != nil {
+					return err
+				}
+			}
 			pspName := args[0]
-			pspObj, err := clientset.PolicyV1beta1().PodSecurityPolicies().Get(context.TODO(), pspName, metav1.GetOptions{})
-			if errors.IsNotFound(err) {
-				fmt.Printf("PodSecurityPolicy %s not found\n", pspName)
-				os.Exit(1)
-			} else if statusError, isStatus := err.(*errors.StatusError); isStatus {
-				fmt.Printf("Error getting PodSecurityPolicy %s: %v\n",
-					pspName, statusError.ErrStatus.Message)
-				os.Exit(1)
-			} else if err != nil {
-				log.Fatalln(err.Error())
-				os.Exit(1)
-			} else {
-				_, fields, annotations := pspmigrator.IsPSPMutating(pspObj)
-				fmt.Printf("PSP profile %v has the following mutating fields: %v and annotations: %v\n", pspName, fields, annotations)
+			pspObj, err := loadPSP(pspName)
+			if err != nil {
+				return err
+			} else if pspObj == nil {
+				return fmt.Errorf("%s, use --filename to read exported PSP objects", pspAPINotServed)
 			}
-
+			mutating, fields, annotations := pspmigrator.IsPSPMutating(pspObj)
+			fmt.Printf("PSP profile %v has the following mutating fields: %v and annotations: %v\n", pspName, fields, annotations)
+			if mutating {
+				return &ExitError{Code: ExitCodeFindings}
+			}
+			return nil
 		},
 		Args: cobra.ExactArgs(1),
 	}
 
+	MutatingCmd.PersistentFlags().StringVarP(&Filename, "filename", "f", "",
+		"Read the PSP objects from this file instead of the cluster, e.g. when the PSP API is no longer served, use - for stdin")
 	MutatingCmd.AddCommand(&podCmd)
 	MutatingCmd.AddCommand(&podsCmd)
 	MutatingCmd.AddCommand(&pspCmd)
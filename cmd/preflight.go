@@ -0,0 +1,95 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/kubernetes-sigs/pspmigrator"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var SkipPreflight bool
+
+var PreflightCmd = &cobra.Command{
+	Use:   "preflight",
+	Short: "Check that the current user has the permissions needed to migrate",
+	Long: `Check that the current user has the permissions needed to migrate.
+
+Every permission is reviewed with a SelfSubjectAccessReview: get and list on
+pods, namespaces, PSP objects and workloads in all namespaces, and with
+--dry-run=false update and patch on namespaces. With --probe-psp-admission
+create and delete on namespaces, get on service accounts and create on pods
+are checked as well. The missing permissions are listed, followed by a
+ClusterRole that grants them. The check also runs before
+migrate, unless --skip-preflight is set.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := runPreflight(DryRun); err != nil {
+			return err
+		}
+		fmt.Println("The current user has all permissions needed to migrate")
+		return nil
+	},
+}
+
+func init() {
+	PreflightCmd.Flags().BoolVarP(&DryRun, "dry-run", "d", true,
+		"Set dry run to false to also check the permissions needed to apply changes")
+}
+
+// runPreflight returns an error if the current user is missing permissions
+// needed to migrate, after printing the missing permissions and a
+// ClusterRole that grants them.
+func runPreflight(dryRun bool) error {
+	served, err := PSPAPIServed()
+	if err != nil {
+		return err
+	}
+	permissions := pspmigrator.RequiredPermissions(dryRun, served)
+	if ProbePSPAdmission {
+		permissions = append(permissions, pspmigrator.ProbePermissions()...)
+	}
+	checks, err := pspmigrator.CheckPermissions(context.TODO(), clientset, permissions)
+	if err != nil {
+		return err
+	}
+	missing := make([]pspmigrator.Permission, 0)
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Verb", "API Group", "Resource", "Reason"})
+	for _, check := range checks {
+		if !check.Allowed {
+			missing = append(missing, check.Permission)
+			table.Append([]string{check.Verb, check.Group, check.Resource, check.Reason})
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	fmt.Println("The table below shows the permissions the current user is missing")
+	table.Render()
+	fmt.Println("The following ClusterRole grants them:")
+	clusterRole := pspmigrator.ClusterRoleForPermissions("pspmigrator", missing)
+	if err := PrintManifests(os.Stdout, []runtime.Object{clusterRole}); err != nil {
+		return err
+	}
+	return fmt.Errorf("missing %d permissions", len(missing))
+}
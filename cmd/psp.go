@@ -0,0 +1,79 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kubernetes-sigs/pspmigrator"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var PSPCmd = &cobra.Command{
+	Use:   "psp",
+	Short: "Inspect the PSP objects of the cluster",
+}
+
+var pspListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List every PSP object with its Pod Security Standard, mutating fields and usage",
+	Long: `List every PSP object with its Pod Security Standard, mutating fields and usage.
+
+For every PSP object the table shows the most restrictive Pod Security Standard
+it maps to, the fields and annotations that may mutate pods, the number of
+running pods in any namespace, including kube-system, admitted by it according
+to their kubernetes.io/psp annotation, the namespaces of these pods and the
+subjects RBAC authorizes to use it. PSP objects that didn't admit any running
+pod are marked as unused.
+
+The PSP and RBAC objects are read from the cluster, or from a file with
+--filename. The pods are always read from the cluster.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		m, err := LoadManifests(Filename, true)
+		if err != nil {
+			return err
+		}
+		pods, err := GetAllPods()
+		if err != nil {
+			return fmt.Errorf("failed to get pods: %w", err)
+		}
+		printPSPAdmission(pods.Items)
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Name", "Level", "Mutating Fields", "Pods", "Namespaces", "Subjects", "Unused"})
+		table.SetAutoWrapText(false)
+		for _, usage := range pspmigrator.PSPInventory(m.PSPs, pods.Items, m.PSPAuthorizations()) {
+			mutating := append(append([]string{}, usage.MutatingFields...), usage.MutatingAnnotations...)
+			table.Append([]string{usage.Name, string(usage.Level), strings.Join(mutating, "\n"),
+				strconv.Itoa(usage.Pods), strings.Join(usage.Namespaces, "\n"), strings.Join(usage.Subjects, "\n"),
+				strconv.FormatBool(usage.Unused())})
+		}
+		table.Render()
+		return nil
+	},
+}
+
+func init() {
+	PSPCmd.PersistentFlags().StringVarP(&Filename, "filename", "f", "",
+		"Read the PSP and RBAC objects from this file instead of the cluster, use - for stdin")
+	PSPCmd.AddCommand(pspListCmd)
+}
@@ -17,9 +17,11 @@ limitations under the License.
 package main
 
 import (
+	"os"
+
 	"github.com/kubernetes-sigs/pspmigrator/cmd"
 )
 
 func main() {
-	cmd.RootCmd.Execute()
+	os.Exit(cmd.Execute())
 }
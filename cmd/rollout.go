@@ -0,0 +1,293 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubernetes-sigs/pspmigrator"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	psaapi "k8s.io/pod-security-admission/api"
+)
+
+var (
+	SoakPeriod       time.Duration
+	Waves            []string
+	StateFile        string
+	RolloutLevel     string
+	RolloutAuditLogs []string
+)
+
+var RolloutCmd = &cobra.Command{
+	Use:   "rollout",
+	Short: "Roll out Pod Security Standards through audit, warn and enforce in stages",
+	Long: `Roll out Pod Security Standards through audit, warn and enforce in stages.
+
+Every run advances the namespaces by at most one stage and persists their
+state, so the command is meant to be run repeatedly, e.g. by a CronJob, and can
+be interrupted at any time. A namespace starts with the audit label of the
+most restrictive level its pods pass, or --level. It only moves on to warn and
+then enforce once --soak has passed without pods that violate the level, any
+violating pod restarts the soak window. Pods are excluded the same way as by
+the migrate command.
+
+Without --audit-log only the pods that exist when the command runs are
+checked, so short-lived violating pods created between two runs, e.g. of Jobs,
+go unnoticed. With --audit-log the PodSecurity audit violations the API server
+recorded in the namespace since the soak window started restart it as well.
+
+Namespaces are rolled out in waves, one per --wave namespace label selector in
+the given order. A wave is only started once all namespaces of the previous
+waves enforce their level. Without --wave all namespaces form a single wave.
+Namespaces that already have PSA labels that weren't set by the rollout are
+skipped.
+
+The state is persisted in annotations of the namespaces, or in --state-file.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var level psaapi.Level
+		if RolloutLevel != "" {
+			var err error
+			if level, err = psaapi.ParseLevel(RolloutLevel); err != nil {
+				return fmt.Errorf("invalid --level: %w", err)
+			}
+		}
+		selectors := make([]labels.Selector, 0, len(Waves))
+		for _, wave := range Waves {
+			selector, err := labels.Parse(wave)
+			if err != nil {
+				return fmt.Errorf("invalid --wave %q: %w", wave, err)
+			}
+			selectors = append(selectors, selector)
+		}
+		if len(selectors) == 0 {
+			selectors = append(selectors, labels.Everything())
+		}
+		state, err := readRolloutState(StateFile)
+		if err != nil {
+			return err
+		}
+		var auditReport *pspmigrator.AuditReport
+		if len(RolloutAuditLogs) > 0 {
+			auditReport = pspmigrator.NewAuditReport()
+			for _, filename := range RolloutAuditLogs {
+				if err := readAuditLog(auditReport, filename); err != nil {
+					return fmt.Errorf("failed to read %v: %w", filename, err)
+				}
+			}
+		}
+		namespaces, err := GetNamespaces()
+		if err != nil {
+			return fmt.Errorf("failed to list namespaces: %w", err)
+		}
+		pods, err := GetPods()
+		if err != nil {
+			return fmt.Errorf("failed to get pods: %w", err)
+		}
+		printPSPAdmission(pods.Items)
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Namespace", "Wave", "Level", "Stage", "Since", "Violating Pods"})
+		var scanErrors ScanErrors
+		incompleteWave := ""
+		assigned := make(map[string]bool)
+		for i, selector := range selectors {
+			wave := strconv.Itoa(i + 1)
+			complete := true
+			for j := range namespaces.Items {
+				namespace := &namespaces.Items[j]
+				if assigned[namespace.Name] || !selector.Matches(labels.Set(namespace.Labels)) {
+					continue
+				}
+				assigned[namespace.Name] = true
+				status, err := advanceNamespaceRollout(namespace, state, level, auditReport)
+				if err != nil {
+					scanErrors.Add("namespace "+namespace.Name, err)
+					complete = false
+					continue
+				}
+				if status == nil {
+					continue
+				}
+				if status.Stage != pspmigrator.RolloutStageEnforce {
+					complete = false
+				}
+				table.Append([]string{namespace.Name, wave, string(status.Level), string(status.Stage),
+					status.Since.Format(time.RFC3339), strings.Join(status.ViolatingPods, ", ")})
+			}
+			if !complete {
+				incompleteWave = wave
+				break
+			}
+		}
+		table.Render()
+		if incompleteWave != "" {
+			fmt.Printf("Wave %v doesn't enforce its levels yet, the next waves are started once it does\n", incompleteWave)
+		}
+		if DryRun {
+			fmt.Println("In dry-run mode so not applying any changes. Run this command again with --dry-run=false to apply them")
+		}
+		return scanErrors.Result(false)
+	},
+}
+
+func init() {
+	RolloutCmd.Flags().DurationVar(&SoakPeriod, "soak", 7*24*time.Hour,
+		"Time without violating pods before a namespace moves on to the next stage. "+
+			"Pods are only checked when the command runs, unless --audit-log is given")
+	RolloutCmd.Flags().StringArrayVar(&RolloutAuditLogs, "audit-log", nil,
+		"API server audit log whose PodSecurity audit violations since the soak window started restart it, repeat for rotated files")
+	RolloutCmd.Flags().StringArrayVar(&Waves, "wave", nil,
+		"Namespace label selector of a wave, repeat for every wave in rollout order")
+	RolloutCmd.Flags().StringVar(&StateFile, "state-file", "",
+		"Persist the rollout state in this file instead of namespace annotations")
+	RolloutCmd.Flags().StringVar(&RolloutLevel, "level", "",
+		"Level to roll out, defaults to the most restrictive level the pods of a namespace pass")
+	RolloutCmd.Flags().BoolVarP(&DryRun, "dry-run", "d", true, "Set dry run to true to not apply any changes")
+	addPodFilterFlags(RolloutCmd.Flags())
+}
+
+// advanceNamespaceRollout moves the namespace to the next stage of the
+// rollout if it's due, and persists the new status. The audit violations of
+// the audit report, if any, count as violating pods. It returns nil if the
+// namespace isn't rolled out.
+func advanceNamespaceRollout(namespace *v1.Namespace, state map[string]pspmigrator.RolloutStatus,
+	level psaapi.Level, auditReport *pspmigrator.AuditReport) (*pspmigrator.RolloutStatus, error) {
+	status, ok := state[namespace.Name]
+	if StateFile == "" {
+		var err error
+		status, ok, err = pspmigrator.RolloutStatusFromAnnotations(namespace.Annotations)
+		if err != nil {
+			return nil, fmt.Errorf("namespace %v: %w", namespace.Name, err)
+		}
+	}
+	if !ok && NamespaceHasPSALabels(namespace) {
+		fmt.Printf("The namespace %v already has PSA labels set. So skipping....\n", namespace.Name)
+		return nil, nil
+	}
+
+	podList, err := GetPodsByNamespace(namespace.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods of namespace %v: %w", namespace.Name, err)
+	}
+	pods, excluded := newPodFilter().Filter(podList.Items, time.Now())
+	for _, e := range excluded {
+		fmt.Printf("Excluding pod %v in namespace %v: %v\n", e.Pod.Name, namespace.Name, e.Reason)
+	}
+	pods, excludedContainers := levelExclusions().ApplyAll(pods)
+	for _, pod := range pods {
+		for _, c := range excludedContainers[pod.Name] {
+			fmt.Printf("Excluding container %v of pod %v in namespace %v: %v\n", c.Name, pod.Name, namespace.Name, c.Reason)
+		}
+	}
+	if !ok {
+		status.Level = level
+		if status.Level == "" {
+			if status.Level, err = pspmigrator.SuggestedNamespacePodSecurityStandard(pods); err != nil {
+				return nil, err
+			}
+		}
+		if status.Level == psaapi.LevelPrivileged {
+			fmt.Printf("The pods of namespace %v need the privileged level, skipping\n", namespace.Name)
+			return nil, nil
+		}
+	}
+	violating, err := pspmigrator.ViolatingPods(pods, status.Level)
+	if err != nil {
+		return nil, err
+	}
+	if ok && auditReport != nil {
+		if auditReport.From.After(status.Since) {
+			fmt.Printf("The audit logs of namespace %v start at %v, after its soak window started at %v\n",
+				namespace.Name, auditReport.From.Format(time.RFC3339), status.Since.Format(time.RFC3339))
+		}
+		violating = append(violating, auditReport.ViolationsSince(namespace.Name, status.Since)...)
+	}
+	next := pspmigrator.AdvanceRollout(status, violating, time.Now(), SoakPeriod)
+	if DryRun {
+		return &next, nil
+	}
+
+	if StateFile == "" {
+		if namespace.Annotations == nil {
+			namespace.Annotations = make(map[string]string)
+		}
+		for k, v := range next.Annotations() {
+			namespace.Annotations[k] = v
+		}
+	}
+	if err := ApplyPSSLabels(namespace, next.Labels()); err != nil {
+		return nil, fmt.Errorf("failed to update namespace %v: %w", namespace.Name, err)
+	}
+	if StateFile != "" {
+		state[namespace.Name] = next
+		if err := writeRolloutState(StateFile, state); err != nil {
+			return nil, err
+		}
+	}
+	return &next, nil
+}
+
+func readRolloutState(filename string) (map[string]pspmigrator.RolloutStatus, error) {
+	state := make(map[string]pspmigrator.RolloutStatus)
+	if filename == "" {
+		return state, nil
+	}
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to read %v: %w", filename, err)
+	}
+	return state, nil
+}
+
+// writeRolloutState replaces the state file atomically, so an interrupted
+// rollout can always be resumed.
+func writeRolloutState(filename string, state map[string]pspmigrator.RolloutStatus) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
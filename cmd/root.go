@@ -17,13 +17,14 @@ limitations under the License.
 package cmd
 
 import (
-	"path/filepath"
+	"fmt"
 
+	"github.com/kubernetes-sigs/pspmigrator"
 	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 )
@@ -31,38 +32,146 @@ import (
 var RootCmd = &cobra.Command{
 	Use:   "pspmigrator",
 	Short: "pspmigrator is a tool to help migrate from PSP to PSA",
+	// Errors are already printed by cobra, the usage only adds noise to
+	// errors that aren't caused by wrong usage such as a bad kubeconfig.
+	SilenceUsage: true,
+	// Errors are printed by Execute, which also picks the exit code.
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if RunsOffline(cmd) {
+			return nil
+		}
+		return initClientset()
+	},
+}
+
+// offlineAnnotation marks commands that don't need access to a cluster. The
+// clientset isn't created for these commands, so they work without a
+// kubeconfig.
+const offlineAnnotation = "pspmigrator/offline"
+
+// RunsOffline returns whether the command, or one of its parents, doesn't
+// need access to a cluster. This includes the help and completion commands
+// added by cobra.
+func RunsOffline(cmd *cobra.Command) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		switch c.Name() {
+		case "help", "completion", cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+			return true
+		}
+		if c.Annotations[offlineAnnotation] == "true" {
+			return true
+		}
+	}
+	return false
 }
 
 var (
-	clientset *kubernetes.Clientset
-	err       error
-	Namespace string
+	clientset       kubernetes.Interface
+	mutationChecker *pspmigrator.MutationChecker
+	// ownerGetter looks up the controllers of pods of any kind to detect
+	// orphaned pods.
+	ownerGetter pspmigrator.OwnerGetter
+	err         error
+	Namespace   string
+	kubeconfig  string
+	Concurrency int
+	ChunkSize   int64
+	QPS         float32
+	Burst       int
+	// ProbePSPAdmission dry-runs a probe pod to detect whether PSP
+	// admission is enabled.
+	ProbePSPAdmission bool
+	// configFlags are the standard kubectl flags such as --context, --as
+	// and --request-timeout, so pspmigrator behaves the same when it's
+	// used as a kubectl plugin.
+	configFlags = genericclioptions.NewConfigFlags(true)
 )
 
 func init() {
 	initMutating()
 	RootCmd.AddCommand(MutatingCmd)
 	RootCmd.AddCommand(MigrateCmd)
-	var kubeconfig string
+	RootCmd.AddCommand(WatchCmd)
+	RootCmd.AddCommand(ConvertCmd)
+	RootCmd.AddCommand(AdmissionConfigCmd)
+	RootCmd.AddCommand(SimulateCmd)
+	RootCmd.AddCommand(AuditLogCmd)
+	RootCmd.AddCommand(RolloutCmd)
+	RootCmd.AddCommand(PreflightCmd)
+	RootCmd.AddCommand(PSPCmd)
+	RootCmd.AddCommand(CleanupCmd)
 
-	if home := homedir.HomeDir(); home != "" {
-		RootCmd.PersistentFlags().StringVarP(&kubeconfig, "kubeconfig", "k",
-			filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-	} else {
-		RootCmd.PersistentFlags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "absolute path to the kubeconfig file")
+	// --kubeconfig is registered separately to keep its -k shorthand
+	configFlags.KubeConfig = nil
+	configFlags.AddFlags(RootCmd.PersistentFlags())
+	RootCmd.PersistentFlags().StringVarP(&kubeconfig, "kubeconfig", "k", "",
+		"Path to the kubeconfig file to use, defaults to $KUBECONFIG or ~/.kube/config and in-cluster config")
+	configFlags.KubeConfig = &kubeconfig
+	RootCmd.PersistentFlags().IntVar(&Concurrency, "concurrency", 10,
+		"Number of pods that are checked in parallel")
+	RootCmd.PersistentFlags().Int64Var(&ChunkSize, "chunk-size", 500,
+		"Return large lists in chunks rather than all at once. Pass 0 to disable")
+	RootCmd.PersistentFlags().Float32Var(&QPS, "qps", 50, "Maximum queries per second to the API server")
+	RootCmd.PersistentFlags().IntVar(&Burst, "burst", 100, "Maximum burst of queries to the API server")
+	RootCmd.PersistentFlags().BoolVar(&ProbePSPAdmission, "probe-psp-admission", false,
+		"Create a probe pod with a server-side dry-run in a temporary namespace to detect whether PSP admission is enabled. The namespace is created and deleted again")
+	RootCmd.PersistentFlags().BoolVar(&IncludeEphemeralContainers, "include-ephemeral-containers", false,
+		"Evaluate ephemeral containers, e.g. added by kubectl debug, when suggesting levels and checking for mutations")
+	RootCmd.PersistentFlags().BoolVar(&IncludeInjectedSidecars, "include-injected-sidecars", false,
+		"Compare the sidecars injected by Istio and Linkerd when checking for mutations")
+	RootCmd.PersistentFlags().BoolVar(&ExcludeInjectedSidecars, "exclude-injected-sidecars", false,
+		"Leave out the sidecars injected by Istio and Linkerd when suggesting levels. PodSecurity admission does evaluate them")
+	RootCmd.PersistentFlags().StringSliceVar(&ExcludeContainers, "exclude-containers", nil,
+		"Names of containers that are left out when suggesting levels and checking for mutations")
+}
+
+// initClientset creates the clientset from the kubeconfig flags. It's called
+// after the flags are parsed for every command that needs a cluster.
+func initClientset() error {
+	// Without a kubeconfig client-go falls back to http://localhost:8080,
+	// which results in confusing connection errors later on.
+	rawConfig, err := configFlags.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	if len(rawConfig.Contexts) == 0 && *configFlags.APIServer == "" {
+		if _, err := rest.InClusterConfig(); err != nil {
+			return fmt.Errorf("no kubeconfig found, use --kubeconfig or set KUBECONFIG to access a cluster")
+		}
 	}
 
-	// use the current context in kubeconfig
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	config, err := configFlags.ToRESTConfig()
 	if err != nil {
-		panic(err.Error())
+		return fmt.Errorf("failed to load kubeconfig: %w", err)
 	}
 	config.UserAgent = "pspmigrator"
 	config.WarningHandler = rest.NoWarnings{}
+	config.QPS = QPS
+	config.Burst = Burst
 
 	// create the clientset
 	clientset, err = kubernetes.NewForConfig(config)
 	if err != nil {
-		panic(err.Error())
+		return fmt.Errorf("failed to create clientset: %w", err)
+	}
+	mutationChecker = pspmigrator.NewMutationChecker(clientset)
+	mutationChecker.Exclusions = mutationExclusions()
+
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create metadata client: %w", err)
+	}
+	mapper, err := configFlags.ToRESTMapper()
+	if err != nil {
+		return fmt.Errorf("failed to create REST mapper: %w", err)
+	}
+	ownerGetter = pspmigrator.MetadataOwnerGetter(metadataClient, mapper)
+
+	// --namespace defaults to the namespace of the current context
+	Namespace, _, err = configFlags.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return fmt.Errorf("failed to get namespace from kubeconfig: %w", err)
 	}
+	return nil
 }
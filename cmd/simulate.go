@@ -0,0 +1,211 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kubernetes-sigs/pspmigrator"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	admissionapi "k8s.io/pod-security-admission/admission/api"
+)
+
+var (
+	SimulateLabels      map[string]string
+	AdmissionConfigFile string
+)
+
+var SimulateCmd = &cobra.Command{
+	Use:   "simulate [namespace]",
+	Short: "Simulate the PodSecurity admission plugin for a namespace with the given labels",
+	Long: `Simulate the PodSecurity admission plugin for a namespace with the given labels.
+
+The pod-security.kubernetes.io labels of the namespace are replaced by the ones
+given with --labels, and the existing pods and workloads of the namespace are
+replayed through the admission logic of the API server. The decisions and
+warnings are the same the API server returns, including exemptions and the
+defaults of the admission configuration given with --admission-config.
+Pods are replayed as the service account of their controller, e.g.
+system:serviceaccount:kube-system:replicaset-controller, so username
+exemptions apply to them. Workloads whose pods are created by another
+workload, e.g. the ReplicaSets of a Deployment, are skipped.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var configuration *admissionapi.PodSecurityConfiguration
+		if AdmissionConfigFile != "" {
+			data, err := os.ReadFile(AdmissionConfigFile)
+			if err != nil {
+				return err
+			}
+			configuration, err = pspmigrator.LoadPodSecurityConfiguration(data)
+			if err != nil {
+				return fmt.Errorf("failed to read %v: %w", AdmissionConfigFile, err)
+			}
+		}
+		namespace, err := clientset.CoreV1().Namespaces().Get(context.TODO(), args[0], metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		pods, err := GetPodsByNamespace(namespace.Name)
+		if err != nil {
+			return fmt.Errorf("failed to list pods: %w", err)
+		}
+		workloads, err := listWorkloads(namespace.Name)
+		if err != nil {
+			return err
+		}
+		simulator, err := pspmigrator.NewSimulator(configuration, []v1.Namespace{*namespace}, pods.Items)
+		if err != nil {
+			return err
+		}
+
+		labels := make(map[string]string)
+		for k, v := range namespace.Labels {
+			if !strings.HasPrefix(k, "pod-security.kubernetes.io/") {
+				labels[k] = v
+			}
+		}
+		for k, v := range SimulateLabels {
+			labels[k] = v
+		}
+		result, err := simulator.SetNamespaceLabels(namespace.Name, labels)
+		if err != nil {
+			return err
+		}
+		printSimulationResult(os.Stdout, result)
+		if !result.Allowed {
+			return nil
+		}
+		for _, result := range simulator.ReplayPods(namespace.Name) {
+			printSimulationResult(os.Stdout, result)
+		}
+		for _, workload := range workloads {
+			result, err := simulator.ValidateWorkload(workload, "")
+			if err != nil {
+				return err
+			}
+			printSimulationResult(os.Stdout, result)
+		}
+		return nil
+	},
+}
+
+func init() {
+	SimulateCmd.Flags().StringToStringVar(&SimulateLabels, "labels", nil,
+		"pod-security.kubernetes.io labels to simulate, e.g. pod-security.kubernetes.io/enforce=baseline")
+	SimulateCmd.Flags().StringVar(&AdmissionConfigFile, "admission-config", "",
+		"AdmissionConfiguration or PodSecurityConfiguration of the API server, defaults to the built-in defaults")
+}
+
+func printSimulationResult(w io.Writer, result *pspmigrator.SimulationResult) {
+	name := result.Name
+	if result.Namespace != "" {
+		name = result.Namespace + "/" + result.Name
+	}
+	if result.Allowed {
+		fmt.Fprintf(w, "%v %v: allowed\n", result.Kind, name)
+	} else {
+		fmt.Fprintf(w, "%v %v: denied: %v\n", result.Kind, name, result.Message)
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(w, "  Warning: %v\n", warning)
+	}
+	if exempt, ok := result.AuditAnnotations["exempt"]; ok {
+		fmt.Fprintf(w, "  Exempt by %v\n", exempt)
+	}
+	if violations, ok := result.AuditAnnotations["audit-violations"]; ok {
+		fmt.Fprintf(w, "  Audit: %v\n", violations)
+	}
+}
+
+// listWorkloads returns the workloads of the namespace that aren't managed
+// by another workload.
+func listWorkloads(namespace string) ([]runtime.Object, error) {
+	ctx := context.TODO()
+	listOptions := metav1.ListOptions{}
+	workloads := make([]runtime.Object, 0)
+	add := func(obj interface {
+		runtime.Object
+		metav1.Object
+	}) {
+		if metav1.GetControllerOf(obj) == nil {
+			workloads = append(workloads, obj)
+		}
+	}
+
+	apps := clientset.AppsV1()
+	deployments, err := apps.Deployments(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Deployments: %w", err)
+	}
+	for i := range deployments.Items {
+		add(&deployments.Items[i])
+	}
+	replicaSets, err := apps.ReplicaSets(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ReplicaSets: %w", err)
+	}
+	for i := range replicaSets.Items {
+		add(&replicaSets.Items[i])
+	}
+	statefulSets, err := apps.StatefulSets(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list StatefulSets: %w", err)
+	}
+	for i := range statefulSets.Items {
+		add(&statefulSets.Items[i])
+	}
+	daemonSets, err := apps.DaemonSets(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DaemonSets: %w", err)
+	}
+	for i := range daemonSets.Items {
+		add(&daemonSets.Items[i])
+	}
+
+	batch := clientset.BatchV1()
+	cronJobs, err := batch.CronJobs(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list CronJobs: %w", err)
+	}
+	for i := range cronJobs.Items {
+		add(&cronJobs.Items[i])
+	}
+	jobs, err := batch.Jobs(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Jobs: %w", err)
+	}
+	for i := range jobs.Items {
+		add(&jobs.Items[i])
+	}
+
+	replicationControllers, err := clientset.CoreV1().ReplicationControllers(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ReplicationControllers: %w", err)
+	}
+	for i := range replicationControllers.Items {
+		add(&replicationControllers.Items[i])
+	}
+	return workloads, nil
+}
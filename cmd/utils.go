@@ -18,16 +18,65 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/kubernetes-sigs/pspmigrator"
+	"github.com/spf13/pflag"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/api/policy/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	psaapi "k8s.io/pod-security-admission/api"
 )
 
+// pspAPINotServed explains why checks of PSP objects are skipped.
+const pspAPINotServed = "the PodSecurityPolicy API isn't served by this cluster, it was removed in Kubernetes 1.25"
+
+// PSPAPIServed returns whether the cluster still serves PodSecurityPolicy
+// objects.
+func PSPAPIServed() (bool, error) {
+	return pspmigrator.PSPAPIServed(clientset.Discovery())
+}
+
+// recentPods is how long ago pods may have been created to be evidence of
+// whether PSP admission is enabled.
+const recentPods = 24 * time.Hour
+
+// printPSPAdmission prints whether PSP admission is enabled, inferred from
+// the pods and, with --probe-psp-admission, a probe pod. It's the first line
+// of every report.
+func printPSPAdmission(pods []v1.Pod) {
+	fmt.Println(pspmigrator.DetectPSPAdmission(context.TODO(), clientset, pods, pspmigrator.PSPAdmissionOptions{
+		RecentPods: recentPods,
+		Probe:      ProbePSPAdmission,
+		Now:        time.Now(),
+	}))
+}
+
+// loadPSP returns the PSP object from --filename, or from the cluster. It
+// returns nil if no file is given and the cluster no longer serves PSP
+// objects.
+func loadPSP(name string) (*v1beta1.PodSecurityPolicy, error) {
+	if Filename != "" {
+		psps, err := LoadPSPs(Filename, []string{name})
+		if err != nil {
+			return nil, err
+		}
+		return &psps[0], nil
+	}
+	served, err := PSPAPIServed()
+	if err != nil || !served {
+		return nil, err
+	}
+	return pspmigrator.FetchPSP(name, clientset)
+}
+
+var ignoredNamespaces = []string{"kube-system", "kube-public", "kube-node-lease"}
+
 func IgnoreNamespaceSelector(field string) string {
-	ignoredNamespaces := []string{"kube-system", "kube-public", "kube-node-lease"}
 	selectors := make([]fields.Selector, 0)
 	for _, n := range ignoredNamespaces {
 		selectors = append(selectors, fields.OneTermNotEqualSelector(field, n))
@@ -35,16 +84,154 @@ func IgnoreNamespaceSelector(field string) string {
 	return fields.AndSelectors(selectors...).String()
 }
 
+// IsIgnoredNamespace returns whether the namespace is skipped by the
+// pspmigrator commands, e.g. kube-system.
+func IsIgnoredNamespace(namespace string) bool {
+	for _, n := range ignoredNamespaces {
+		if n == namespace {
+			return true
+		}
+	}
+	return false
+}
+
 func GetPods() (*v1.PodList, error) {
 	listOptions := metav1.ListOptions{FieldSelector: IgnoreNamespaceSelector("metadata.namespace")}
-	pods, err := clientset.CoreV1().Pods("").List(context.TODO(), listOptions)
-	return pods, err
+	return listPods("", listOptions)
+}
+
+// GetAllPods lists the pods of every namespace, including the namespaces
+// that are otherwise skipped. Pods in kube-system are the most likely to
+// depend on a privileged PSP object.
+func GetAllPods() (*v1.PodList, error) {
+	return listPods("", metav1.ListOptions{})
 }
 
 func GetPodsByNamespace(namespace string) (*v1.PodList, error) {
 	listOptions := metav1.ListOptions{}
-	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), listOptions)
-	return pods, err
+	return listPods(namespace, listOptions)
+}
+
+// listPods lists the pods in chunks of ChunkSize, so large clusters don't
+// have to be returned by the API server in a single response.
+func listPods(namespace string, listOptions metav1.ListOptions) (*v1.PodList, error) {
+	listOptions.Limit = ChunkSize
+	pods := &v1.PodList{}
+	for {
+		page, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), listOptions)
+		if err != nil {
+			return nil, err
+		}
+		pods.Items = append(pods.Items, page.Items...)
+		if page.Continue == "" {
+			pods.ListMeta = page.ListMeta
+			return pods, nil
+		}
+		listOptions.Continue = page.Continue
+	}
+}
+
+var (
+	IncludeFinished bool
+	MaxUnmanagedAge time.Duration
+	IncludeOrphans  bool
+)
+
+// addPodFilterFlags adds the flags that control which pods are taken into
+// account when suggesting the level of a namespace.
+func addPodFilterFlags(flags *pflag.FlagSet) {
+	flags.BoolVar(&IncludeFinished, "include-finished", false,
+		"Include Succeeded and Failed pods, e.g. completed Jobs and evicted pods, when suggesting a level")
+	flags.DurationVar(&MaxUnmanagedAge, "max-unmanaged-age", 0,
+		"Exclude pods without a controller that are older than this when suggesting a level, e.g. old debug pods. 0 includes them all")
+	flags.BoolVar(&IncludeOrphans, "include-orphans", false,
+		"Include pods whose controller no longer exists when suggesting a level")
+}
+
+// newPodFilter returns the PodFilter configured by the flags added with
+// addPodFilterFlags.
+func newPodFilter() *pspmigrator.PodFilter {
+	return &pspmigrator.PodFilter{
+		IncludeFinished: IncludeFinished,
+		MaxUnmanagedAge: MaxUnmanagedAge,
+		IncludeOrphans:  IncludeOrphans,
+		GetOwner:        ownerGetter,
+	}
+}
+
+var (
+	IncludeEphemeralContainers bool
+	IncludeInjectedSidecars    bool
+	ExcludeInjectedSidecars    bool
+	ExcludeContainers          []string
+)
+
+// mutationExclusions returns the ContainerExclusions used when checking
+// whether pods are mutated by a PSP object. The injected sidecars aren't part
+// of the controller's template, so they're left out unless
+// --include-injected-sidecars is set.
+func mutationExclusions() *pspmigrator.ContainerExclusions {
+	exclusions := &pspmigrator.ContainerExclusions{
+		EphemeralContainers: !IncludeEphemeralContainers,
+		Names:               ExcludeContainers,
+	}
+	if !IncludeInjectedSidecars {
+		exclusions.AnnotatedContainers = pspmigrator.InjectedSidecars
+	}
+	return exclusions
+}
+
+// levelExclusions returns the ContainerExclusions used when suggesting
+// levels. The PodSecurity admission plugin evaluates pods after the mutating
+// webhooks injected their sidecars, so those are only left out with
+// --exclude-injected-sidecars.
+func levelExclusions() *pspmigrator.ContainerExclusions {
+	exclusions := &pspmigrator.ContainerExclusions{
+		EphemeralContainers: !IncludeEphemeralContainers,
+		Names:               ExcludeContainers,
+	}
+	if ExcludeInjectedSidecars {
+		exclusions.AnnotatedContainers = pspmigrator.InjectedSidecars
+	}
+	return exclusions
+}
+
+// PodMutationCheck is the result of checking whether a pod is being mutated
+// by a PSP object.
+type PodMutationCheck struct {
+	Pod      *v1.Pod
+	Mutation *pspmigrator.PodMutation
+	Err      error
+}
+
+// CheckPodsMutatedByPSP checks the pods using Concurrency workers. Pods that
+// share a controller only cause a single lookup of that controller. The
+// results are returned in the same order as the pods.
+func CheckPodsMutatedByPSP(pods []v1.Pod) []PodMutationCheck {
+	results := make([]PodMutationCheck, len(pods))
+	workers := Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				pod := &pods[i]
+				mutation, err := mutationChecker.CheckPod(pod)
+				results[i] = PodMutationCheck{Pod: pod, Mutation: mutation, Err: err}
+			}
+		}()
+	}
+	for i := range pods {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
 }
 
 func GetNamespaces() (*v1.NamespaceList, error) {
@@ -59,6 +246,19 @@ func ApplyPSSLevel(namespace *v1.Namespace, level psaapi.Level, control string)
 	return err
 }
 
+// ApplyPSSLabels sets the pod-security.kubernetes.io labels of all modes on
+// the namespace in a single update.
+func ApplyPSSLabels(namespace *v1.Namespace, labels map[string]string) error {
+	if namespace.Labels == nil {
+		namespace.Labels = make(map[string]string)
+	}
+	for k, v := range labels {
+		namespace.Labels[k] = v
+	}
+	_, err := clientset.CoreV1().Namespaces().Update(context.TODO(), namespace, metav1.UpdateOptions{})
+	return err
+}
+
 func NamespaceHasPSALabels(namespace *v1.Namespace) bool {
 	for k, _ := range namespace.Labels {
 		if strings.HasPrefix(k, "pod-security.kubernetes.io") {
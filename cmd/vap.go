@@ -0,0 +1,118 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kubernetes-sigs/pspmigrator"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var (
+	VAPAPIVersion     string
+	ValidationActions []string
+)
+
+var convertVAPCmd = &cobra.Command{
+	Use:     "validating-admission-policy [name of PSP object...]",
+	Aliases: []string{"vap"},
+	Short:   "Convert the PSP controls not implied by the Pod Security Standard the PSP maps to into ValidatingAdmissionPolicies",
+	Long: `Convert the PSP controls not implied by the Pod Security Standard the PSP maps to into ValidatingAdmissionPolicies.
+
+Every PSP object results in a ValidatingAdmissionPolicy with CEL validations and
+a ValidatingAdmissionPolicyBinding. ValidatingAdmissionPolicies are available
+as of Kubernetes 1.26 (v1alpha1), 1.28 (v1beta1) and 1.30 (v1), so no
+third-party admission controller is needed.
+
+Unless --namespaces is set, the namespaceSelector of the binding of a PSP
+object only selects the namespaces with pods admitted by it, or with
+--filename the namespaces whose service accounts may use it according to the
+RBAC objects of the file. PSP admission only requires a pod to be allowed by
+one PSP object, while a pod has to pass every bound policy, so PSP objects
+that aren't used are skipped and namespaces that use several PSP objects are
+reported.
+
+The PSP objects are read from the cluster, or from a file with --filename.
+All PSP objects are converted if no names are given.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch VAPAPIVersion {
+		case "v1alpha1", "v1beta1", "v1":
+		default:
+			return fmt.Errorf("invalid --api-version %q, must be v1alpha1, v1beta1 or v1", VAPAPIVersion)
+		}
+		for _, action := range ValidationActions {
+			switch action {
+			case "Deny", "Warn", "Audit":
+			default:
+				return fmt.Errorf("invalid --validation-actions %q, must be Deny, Warn or Audit", action)
+			}
+		}
+		m, err := LoadManifests(Filename, false)
+		if err != nil {
+			return err
+		}
+		psps, err := SelectPSPs(m.PSPs, args)
+		if err != nil {
+			return err
+		}
+		namespacesUsing, err := NamespacesUsingPSPs(Filename, m)
+		if err != nil {
+			return err
+		}
+		objs := make([]runtime.Object, 0, 2*len(psps))
+		// PSP objects used in the same namespace are combined by PSP
+		// admission, while every bound ValidatingAdmissionPolicy applies.
+		pspsByNamespace := make(map[string][]string)
+		for i := range psps {
+			psp := &psps[i]
+			opts := pspmigrator.ValidatingAdmissionPolicyOptions{
+				Namespaces:        ConvertNamespaces,
+				ValidationActions: ValidationActions,
+				APIVersion:        VAPAPIVersion,
+			}
+			if len(opts.Namespaces) == 0 {
+				namespaces, all := namespacesUsing(psp.Name)
+				if !all && len(namespaces) == 0 {
+					fmt.Fprintf(os.Stderr, "PSP %v isn't used by any namespace, skipping\n", psp.Name)
+					continue
+				}
+				opts.Namespaces = namespaces
+			}
+			policy, binding := pspmigrator.ValidatingAdmissionPolicy(psp, opts)
+			if policy == nil {
+				fmt.Fprintf(os.Stderr, "PSP %v is fully covered by the %v Pod Security Standard\n",
+					psp.Name, pspmigrator.PSPPodSecurityStandard(psp))
+				continue
+			}
+			objs = append(objs, policy, binding)
+			addPSPNamespaces(pspsByNamespace, psp.Name, opts.Namespaces)
+		}
+		warnOverlappingPSPs(pspsByNamespace)
+		return PrintManifests(os.Stdout, objs)
+	},
+}
+
+func init() {
+	convertVAPCmd.Flags().StringVar(&VAPAPIVersion, "api-version", "v1",
+		"Version of the admissionregistration.k8s.io API, v1alpha1, v1beta1 or v1")
+	convertVAPCmd.Flags().StringSliceVar(&ValidationActions, "validation-actions", []string{"Warn", "Audit"},
+		"Validation actions of the bindings, Deny, Warn and/or Audit. Not supported by v1alpha1")
+	ConvertCmd.AddCommand(convertVAPCmd)
+}
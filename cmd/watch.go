@@ -0,0 +1,453 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kubernetes-sigs/pspmigrator"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	psaapi "k8s.io/pod-security-admission/api"
+)
+
+var (
+	ListenAddress string
+	ResyncPeriod  time.Duration
+)
+
+func init() {
+	WatchCmd.Flags().StringVar(&ListenAddress, "listen-address", "127.0.0.1:8080",
+		"Address on which the migration status is served")
+	WatchCmd.Flags().DurationVar(&ResyncPeriod, "resync-period", 10*time.Minute,
+		"Interval at which all namespaces are re-evaluated")
+	addPodFilterFlags(WatchCmd.Flags())
+}
+
+var WatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Continuously track the migration readiness of every namespace",
+	Long: `The watch command keeps running and re-evaluates the suggested Pod
+	Security Standard and the pods mutated by a PSP object of a namespace
+	every time one of its pods, workloads or a PSP object changes. Finished,
+	orphaned and old unmanaged pods are left out the same way as by the
+	migrate command, see --include-finished, --include-orphans and
+	--max-unmanaged-age. Changes are logged and the current status is served as JSON on /status and as
+	Prometheus metrics on /metrics.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		w := NewWatcher(clientset, ResyncPeriod)
+		mux := http.NewServeMux()
+		mux.Handle("/status", w)
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(NewStatusCollector(w))
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		mux.HandleFunc("/healthz", func(rw http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(rw, "ok")
+		})
+		server := &http.Server{Addr: ListenAddress, Handler: mux}
+		serveErr := make(chan error, 1)
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				serveErr <- fmt.Errorf("failed to serve status: %w", err)
+				stop()
+			}
+		}()
+		log.Printf("Serving migration status on http://%v/status\n", ListenAddress)
+
+		w.Run(ctx, Concurrency)
+		server.Shutdown(context.Background())
+		select {
+		case err := <-serveErr:
+			return err
+		default:
+			return nil
+		}
+	},
+	Args: cobra.NoArgs,
+}
+
+// MutatedPod is a pod that is being mutated by a PSP object.
+type MutatedPod struct {
+	Name string   `json:"name"`
+	PSP  string   `json:"psp,omitempty"`
+	Diff []string `json:"diff"`
+}
+
+// UnknownPod is a pod that can't be checked for mutations by a PSP object.
+type UnknownPod struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// NamespaceStatus is the migration readiness of a single namespace.
+type NamespaceStatus struct {
+	Name           string            `json:"name"`
+	Pods           int               `json:"pods"`
+	SuggestedLevel psaapi.Level      `json:"suggestedLevel,omitempty"`
+	PSALabels      map[string]string `json:"psaLabels,omitempty"`
+	MutatedPods    []MutatedPod      `json:"mutatedPods,omitempty"`
+	// UnknownPods are the pods that can't be checked for mutations, e.g.
+	// because their controller no longer exists.
+	UnknownPods []UnknownPod `json:"unknownPods,omitempty"`
+	// ExcludedPods are the pods left out by the pod filter, e.g. finished
+	// or orphaned pods. They aren't counted in Pods.
+	ExcludedPods []UnknownPod `json:"excludedPods,omitempty"`
+	// BlockingRestricted are the workloads with pods that aren't allowed
+	// to run under the restricted Pod Security Standard.
+	BlockingRestricted []string  `json:"blockingRestricted,omitempty"`
+	Errors             []string  `json:"errors,omitempty"`
+	UpdatedAt          time.Time `json:"updatedAt"`
+}
+
+// Watcher keeps the NamespaceStatus of every namespace up to date using
+// shared informers.
+type Watcher struct {
+	factory     informers.SharedInformerFactory
+	pods        corelisters.PodLister
+	namespaces  corelisters.NamespaceLister
+	replicaSets appslisters.ReplicaSetLister
+	checker     *pspmigrator.MutationChecker
+	// podFilter leaves out the pods that no longer matter.
+	podFilter *pspmigrator.PodFilter
+	// exclusions are the containers that are left out when suggesting
+	// the level of a namespace.
+	exclusions *pspmigrator.ContainerExclusions
+	queue      workqueue.Interface
+
+	mu     sync.RWMutex
+	status map[string]*NamespaceStatus
+}
+
+// NewWatcher sets up the informers for pods, namespaces, PSP objects and the
+// workloads owning pods. Call Run to start them.
+func NewWatcher(clientset kubernetes.Interface, resync time.Duration) *Watcher {
+	factory := informers.NewSharedInformerFactory(clientset, resync)
+	w := &Watcher{
+		factory:     factory,
+		pods:        factory.Core().V1().Pods().Lister(),
+		namespaces:  factory.Core().V1().Namespaces().Lister(),
+		replicaSets: factory.Apps().V1().ReplicaSets().Lister(),
+		exclusions:  levelExclusions(),
+		queue:       workqueue.New(),
+		status:      make(map[string]*NamespaceStatus),
+	}
+
+	daemonSets := factory.Apps().V1().DaemonSets().Lister()
+	w.checker = &pspmigrator.MutationChecker{
+		FetchController: func(kind, name, namespace string) (runtime.Object, error) {
+			switch kind {
+			case "ReplicaSet":
+				return w.replicaSets.ReplicaSets(namespace).Get(name)
+			case "DaemonSet":
+				return daemonSets.DaemonSets(namespace).Get(name)
+			default:
+				return nil, &pspmigrator.UnsupportedOwnerError{Kind: kind}
+			}
+		},
+		Exclusions: mutationExclusions(),
+	}
+	// The controllers that are watched anyway are looked up in the cache,
+	// any other kind with the API server.
+	w.podFilter = newPodFilter()
+	w.podFilter.GetOwner = func(owner *metav1.OwnerReference, namespace string) error {
+		switch owner.Kind {
+		case "ReplicaSet", "DaemonSet":
+			_, err := w.checker.FetchController(owner.Kind, owner.Name, namespace)
+			return err
+		}
+		if ownerGetter == nil {
+			return nil
+		}
+		return ownerGetter(owner, namespace)
+	}
+
+	namespaceOf := cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.enqueueNamespaceOf,
+		UpdateFunc: func(old, new interface{}) { w.enqueueNamespaceOf(new) },
+		DeleteFunc: w.enqueueNamespaceOf,
+	}
+	factory.Core().V1().Pods().Informer().AddEventHandler(namespaceOf)
+	factory.Apps().V1().ReplicaSets().Informer().AddEventHandler(namespaceOf)
+	factory.Apps().V1().DaemonSets().Informer().AddEventHandler(namespaceOf)
+	factory.Core().V1().Namespaces().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.enqueueNamespace,
+		UpdateFunc: func(old, new interface{}) { w.enqueueNamespace(new) },
+		DeleteFunc: w.enqueueNamespace,
+	})
+	// Which PSP admitted a pod is recorded on the pod, so a changed PSP
+	// object can affect any namespace. The PSP objects aren't watched if
+	// the cluster no longer serves them, since their cache never syncs.
+	if served, err := pspmigrator.PSPAPIServed(clientset.Discovery()); err == nil && !served {
+		log.Printf("Not watching PSP objects: %v\n", pspAPINotServed)
+		return w
+	} else if err != nil {
+		log.Println("Error checking if the PodSecurityPolicy API is served:", err)
+	}
+	factory.Policy().V1beta1().PodSecurityPolicies().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { w.enqueueAll() },
+		UpdateFunc: func(old, new interface{}) { w.enqueueAll() },
+		DeleteFunc: func(obj interface{}) { w.enqueueAll() },
+	})
+	return w
+}
+
+func (w *Watcher) enqueueNamespaceOf(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Println("Error getting key of object:", err)
+		return
+	}
+	namespace, _, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		log.Println("Error getting namespace of object:", err)
+		return
+	}
+	if !IsIgnoredNamespace(namespace) {
+		w.queue.Add(namespace)
+	}
+}
+
+func (w *Watcher) enqueueNamespace(obj interface{}) {
+	name, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Println("Error getting key of namespace:", err)
+		return
+	}
+	if !IsIgnoredNamespace(name) {
+		w.queue.Add(name)
+	}
+}
+
+func (w *Watcher) enqueueAll() {
+	namespaces, err := w.namespaces.List(labels.Everything())
+	if err != nil {
+		log.Println("Error listing namespaces:", err)
+		return
+	}
+	for _, namespace := range namespaces {
+		w.enqueueNamespace(namespace)
+	}
+}
+
+// Run starts the informers and evaluates changed namespaces using the given
+// number of workers until the context is cancelled.
+func (w *Watcher) Run(ctx context.Context, workers int) {
+	defer w.queue.ShutDown()
+	w.factory.Start(ctx.Done())
+	for informerType, synced := range w.factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			log.Printf("Timed out waiting for the %v cache to sync\n", informerType)
+			return
+		}
+	}
+	log.Println("Caches are synced, watching for changes")
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, w.runWorker, time.Second)
+	}
+	<-ctx.Done()
+}
+
+func (w *Watcher) runWorker(ctx context.Context) {
+	for {
+		item, shutdown := w.queue.Get()
+		if shutdown {
+			return
+		}
+		w.sync(item.(string))
+		w.queue.Done(item)
+	}
+}
+
+func (w *Watcher) sync(name string) {
+	namespace, err := w.namespaces.Get(name)
+	if errors.IsNotFound(err) {
+		w.mu.Lock()
+		delete(w.status, name)
+		w.mu.Unlock()
+		log.Printf("Namespace %v was deleted\n", name)
+		return
+	} else if err != nil {
+		log.Printf("Error getting namespace %v: %v\n", name, err)
+		return
+	}
+
+	status := w.evaluate(namespace)
+	w.mu.Lock()
+	old := w.status[name]
+	w.status[name] = status
+	w.mu.Unlock()
+	logTransitions(old, status)
+}
+
+func (w *Watcher) evaluate(namespace *v1.Namespace) *NamespaceStatus {
+	status := &NamespaceStatus{
+		Name:      namespace.Name,
+		PSALabels: make(map[string]string),
+		UpdatedAt: time.Now(),
+	}
+	for k, v := range namespace.Labels {
+		if strings.HasPrefix(k, "pod-security.kubernetes.io") {
+			status.PSALabels[k] = v
+		}
+	}
+
+	podList, err := w.pods.Pods(namespace.Name).List(labels.Everything())
+	if err != nil {
+		status.Errors = append(status.Errors, err.Error())
+		return status
+	}
+	pods := make([]v1.Pod, 0, len(podList))
+	for _, pod := range podList {
+		pods = append(pods, *pod)
+	}
+	pods, excluded := w.podFilter.Filter(pods, time.Now())
+	for _, e := range excluded {
+		status.ExcludedPods = append(status.ExcludedPods, UnknownPod{Name: e.Pod.Name, Reason: e.Reason})
+	}
+	status.Pods = len(pods)
+	if len(pods) == 0 {
+		return status
+	}
+
+	evaluated, _ := w.exclusions.ApplyAll(pods)
+	if status.SuggestedLevel, err = pspmigrator.SuggestedNamespacePodSecurityStandard(evaluated); err != nil {
+		status.Errors = append(status.Errors, err.Error())
+	}
+	violating, err := pspmigrator.ViolatingPods(evaluated, psaapi.LevelRestricted)
+	if err != nil {
+		status.Errors = append(status.Errors, err.Error())
+	}
+	violatingPods := sets.NewString(violating...)
+	blocking := sets.NewString()
+	for i := range pods {
+		pod := &pods[i]
+		if violatingPods.Has(pod.Name) {
+			blocking.Insert(w.workloadOf(pod))
+		}
+		mutation, err := w.checker.CheckPod(pod)
+		if err != nil {
+			status.Errors = append(status.Errors, fmt.Sprintf("pod %v: %v", pod.Name, err))
+			continue
+		}
+		switch mutation.Status {
+		case pspmigrator.PodMutated:
+			status.MutatedPods = append(status.MutatedPods, MutatedPod{
+				Name: pod.Name,
+				PSP:  pod.Annotations["kubernetes.io/psp"],
+				Diff: mutation.Diff,
+			})
+		case pspmigrator.PodMutationUnknown, pspmigrator.PodMutationNoBaseline:
+			status.UnknownPods = append(status.UnknownPods, UnknownPod{Name: pod.Name, Reason: mutation.Reason})
+		}
+	}
+	status.BlockingRestricted = blocking.List()
+	return status
+}
+
+// workloadOf returns the kind and name of the workload a pod belongs to. Pods
+// of a ReplicaSet managed by a Deployment belong to the Deployment. Pods
+// without a controller are a workload of their own.
+func (w *Watcher) workloadOf(pod *v1.Pod) string {
+	owner := metav1.GetControllerOfNoCopy(pod)
+	if owner == nil {
+		return "Pod/" + pod.Name
+	}
+	if owner.Kind == "ReplicaSet" {
+		if rs, err := w.replicaSets.ReplicaSets(pod.Namespace).Get(owner.Name); err == nil {
+			if deployment := metav1.GetControllerOfNoCopy(rs); deployment != nil {
+				return deployment.Kind + "/" + deployment.Name
+			}
+		}
+	}
+	return owner.Kind + "/" + owner.Name
+}
+
+func logTransitions(old, new *NamespaceStatus) {
+	if old == nil {
+		if new.SuggestedLevel != "" {
+			log.Printf("Namespace %v: suggested level %v, %d pods mutated by a PSP object\n",
+				new.Name, new.SuggestedLevel, len(new.MutatedPods))
+		}
+		return
+	}
+	if old.SuggestedLevel != "" && new.SuggestedLevel != "" && old.SuggestedLevel != new.SuggestedLevel {
+		if psaapi.CompareLevels(new.SuggestedLevel, old.SuggestedLevel) > 0 {
+			log.Printf("Namespace %v can now move to %v\n", new.Name, new.SuggestedLevel)
+		} else {
+			log.Printf("Namespace %v now requires %v instead of %v\n", new.Name, new.SuggestedLevel, old.SuggestedLevel)
+		}
+	}
+	if len(old.MutatedPods) > 0 && len(new.MutatedPods) == 0 {
+		log.Printf("Namespace %v no longer has pods mutated by a PSP object\n", new.Name)
+	} else if len(old.MutatedPods) == 0 && len(new.MutatedPods) > 0 {
+		log.Printf("Namespace %v now has %d pods mutated by a PSP object\n", new.Name, len(new.MutatedPods))
+	}
+}
+
+// Status returns a copy of the current status of all namespaces sorted by
+// name.
+func (w *Watcher) Status() []NamespaceStatus {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	statuses := make([]NamespaceStatus, 0, len(w.status))
+	for _, status := range w.status {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// ServeHTTP serves the status of all namespaces as JSON.
+func (w *Watcher) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(rw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(w.Status()); err != nil {
+		log.Println("Error writing status:", err)
+	}
+}
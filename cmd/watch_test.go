@@ -0,0 +1,141 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	psaapi "k8s.io/pod-security-admission/api"
+)
+
+// syncBuffer is a bytes.Buffer that can be written by the watcher's workers
+// while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWatcherTracksPodChanges(t *testing.T) {
+	logs := &syncBuffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	privileged := true
+	client := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}})
+	w := NewWatcher(client, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx, 1)
+
+	status := func(condition func(*NamespaceStatus) bool) *NamespaceStatus {
+		t.Helper()
+		var current *NamespaceStatus
+		err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+			for _, s := range w.Status() {
+				if s.Name == "apps" {
+					current = &s
+					return condition(current), nil
+				}
+			}
+			return false, nil
+		})
+		if err != nil {
+			t.Fatalf("Namespace status didn't reach the expected state, got %+v", current)
+		}
+		return current
+	}
+
+	pods := client.CoreV1().Pods("apps")
+	if _, err := pods.Create(ctx, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "debug", Namespace: "apps"},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name:            "shell",
+			SecurityContext: &v1.SecurityContext{Privileged: &privileged},
+		}}},
+	}, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	s := status(func(s *NamespaceStatus) bool { return s.Pods == 1 })
+	if s.SuggestedLevel != psaapi.LevelPrivileged {
+		t.Errorf("Expected suggested level privileged, got %v", s.SuggestedLevel)
+	}
+	if len(s.BlockingRestricted) != 1 || s.BlockingRestricted[0] != "Pod/debug" {
+		t.Errorf("Expected Pod/debug to block restricted, got %v", s.BlockingRestricted)
+	}
+	if !strings.Contains(logs.String(), "Namespace apps: suggested level privileged") {
+		t.Errorf("Expected the suggested level to be logged, got %q", logs.String())
+	}
+
+	nonRoot := true
+	allowEscalation := false
+	if _, err := pods.Create(ctx, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps"},
+		Spec: v1.PodSpec{
+			SecurityContext: &v1.PodSecurityContext{
+				RunAsNonRoot:   &nonRoot,
+				SeccompProfile: &v1.SeccompProfile{Type: v1.SeccompProfileTypeRuntimeDefault},
+			},
+			Containers: []v1.Container{{
+				Name: "web",
+				SecurityContext: &v1.SecurityContext{
+					AllowPrivilegeEscalation: &allowEscalation,
+					Capabilities:             &v1.Capabilities{Drop: []v1.Capability{"ALL"}},
+				},
+			}},
+		},
+	}, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	s = status(func(s *NamespaceStatus) bool { return s.Pods == 2 })
+	if s.SuggestedLevel != psaapi.LevelPrivileged {
+		t.Errorf("Expected suggested level privileged, got %v", s.SuggestedLevel)
+	}
+
+	if err := pods.Delete(ctx, "debug", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	s = status(func(s *NamespaceStatus) bool { return s.Pods == 1 })
+	if s.SuggestedLevel != psaapi.LevelRestricted || len(s.BlockingRestricted) != 0 {
+		t.Errorf("Expected suggested level restricted without blocking workloads, got %+v", s)
+	}
+	if !strings.Contains(logs.String(), "Namespace apps can now move to restricted") {
+		t.Errorf("Expected the transition to restricted to be logged, got %q", logs.String())
+	}
+}
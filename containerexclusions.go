@@ -0,0 +1,167 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// InjectedSidecars are the containers that service meshes inject into pods
+// by a mutating webhook, by the pod annotation the webhook adds.
+var InjectedSidecars = map[string][]string{
+	"sidecar.istio.io/status":  {"istio-init", "istio-proxy", "istio-validation"},
+	"linkerd.io/proxy-version": {"linkerd-init", "linkerd-proxy", "linkerd-network-validator"},
+}
+
+// ContainerExclusions configures the containers that are left out when
+// suggesting a level or checking whether a pod is mutated by a PSP object,
+// because they weren't part of the pod as it was created by its owner.
+type ContainerExclusions struct {
+	// EphemeralContainers excludes ephemeral containers, e.g. the
+	// containers added by kubectl debug.
+	EphemeralContainers bool
+	// Names are excluded in all pods.
+	Names []string
+	// AnnotatedContainers maps a pod annotation to the containers that
+	// are excluded in the pods with that annotation, see InjectedSidecars.
+	AnnotatedContainers map[string][]string
+}
+
+// DefaultContainerExclusions excludes ephemeral containers and the sidecars
+// injected by Istio and Linkerd. It's meant for checking mutations: the
+// PodSecurity admission plugin evaluates pods after the sidecars were
+// injected, so they must be kept when suggesting levels.
+var DefaultContainerExclusions = ContainerExclusions{
+	EphemeralContainers: true,
+	AnnotatedContainers: InjectedSidecars,
+}
+
+// ExcludedContainer is a container that was left out of a pod.
+type ExcludedContainer struct {
+	Name   string
+	Reason string
+}
+
+// Apply returns a copy of the pod without the excluded containers, and the
+// excluded containers with the reason. The pod itself is returned if no
+// containers are excluded.
+func (e *ContainerExclusions) Apply(pod *v1.Pod) (*v1.Pod, []ExcludedContainer) {
+	if e == nil {
+		return pod, nil
+	}
+	reasons := make(map[string]string)
+	for _, name := range e.Names {
+		reasons[name] = "excluded by name"
+	}
+	for annotation, names := range e.AnnotatedContainers {
+		if _, ok := pod.Annotations[annotation]; ok {
+			for _, name := range names {
+				reasons[name] = fmt.Sprintf("injected, pod has annotation %s", annotation)
+			}
+		}
+	}
+
+	excluded := make([]ExcludedContainer, 0)
+	keep := func(name string) bool {
+		if reason, ok := reasons[name]; ok {
+			excluded = append(excluded, ExcludedContainer{Name: name, Reason: reason})
+			return false
+		}
+		return true
+	}
+	containers := make([]v1.Container, 0, len(pod.Spec.Containers))
+	for _, c := range pod.Spec.Containers {
+		if keep(c.Name) {
+			containers = append(containers, c)
+		}
+	}
+	initContainers := make([]v1.Container, 0, len(pod.Spec.InitContainers))
+	for _, c := range pod.Spec.InitContainers {
+		if keep(c.Name) {
+			initContainers = append(initContainers, c)
+		}
+	}
+	ephemeralContainers := make([]v1.EphemeralContainer, 0, len(pod.Spec.EphemeralContainers))
+	for _, c := range pod.Spec.EphemeralContainers {
+		if e.EphemeralContainers {
+			excluded = append(excluded, ExcludedContainer{Name: c.Name, Reason: "ephemeral container"})
+		} else if keep(c.Name) {
+			ephemeralContainers = append(ephemeralContainers, c)
+		}
+	}
+	if len(excluded) == 0 {
+		return pod, excluded
+	}
+
+	result := pod.DeepCopy()
+	result.Spec.Containers = containers
+	result.Spec.InitContainers = nilIfEmpty(initContainers)
+	result.Spec.EphemeralContainers = nil
+	if len(ephemeralContainers) > 0 {
+		result.Spec.EphemeralContainers = ephemeralContainers
+	}
+	return result, excluded
+}
+
+// ApplyAll applies the exclusions to all pods. The excluded containers are
+// returned by pod name.
+func (e *ContainerExclusions) ApplyAll(pods []v1.Pod) ([]v1.Pod, map[string][]ExcludedContainer) {
+	result := make([]v1.Pod, 0, len(pods))
+	excludedByPod := make(map[string][]ExcludedContainer)
+	for i := range pods {
+		pod, excluded := e.Apply(&pods[i])
+		result = append(result, *pod)
+		if len(excluded) > 0 {
+			excludedByPod[pods[i].Name] = excluded
+		}
+	}
+	return result, excludedByPod
+}
+
+// excludeNames returns a copy of the pod spec without the containers that
+// have the names of the excluded containers.
+func excludeNames(spec *v1.PodSpec, excluded []ExcludedContainer) *v1.PodSpec {
+	names := sets.NewString()
+	for _, c := range excluded {
+		names.Insert(c.Name)
+	}
+	result := spec.DeepCopy()
+	result.Containers = make([]v1.Container, 0, len(spec.Containers))
+	for _, c := range spec.Containers {
+		if !names.Has(c.Name) {
+			result.Containers = append(result.Containers, c)
+		}
+	}
+	initContainers := make([]v1.Container, 0, len(spec.InitContainers))
+	for _, c := range spec.InitContainers {
+		if !names.Has(c.Name) {
+			initContainers = append(initContainers, c)
+		}
+	}
+	result.InitContainers = nilIfEmpty(initContainers)
+	return result
+}
+
+func nilIfEmpty(containers []v1.Container) []v1.Container {
+	if len(containers) == 0 {
+		return nil
+	}
+	return containers
+}
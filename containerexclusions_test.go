@@ -0,0 +1,129 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	psaapi "k8s.io/pod-security-admission/api"
+)
+
+// newMeshPod returns a pod of the nginx ReplicaSet with an injected Istio
+// sidecar that runs privileged.
+func newMeshPod() *v1.Pod {
+	pod := newOwnedPod("nginx-1", "default", "ReplicaSet", "nginx")
+	pod.Annotations = map[string]string{"sidecar.istio.io/status": `{"containers":["istio-proxy"]}`}
+	pod.Spec.InitContainers = []v1.Container{{
+		Name:            "istio-init",
+		SecurityContext: &v1.SecurityContext{Capabilities: &v1.Capabilities{Add: []v1.Capability{"NET_ADMIN"}}},
+	}}
+	pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{
+		Name:            "istio-proxy",
+		SecurityContext: &v1.SecurityContext{RunAsUser: newInt64(1337)},
+	})
+	return pod
+}
+
+func newInt64(i int64) *int64 {
+	return &i
+}
+
+func TestContainerExclusionsApply(t *testing.T) {
+	pod := newMeshPod()
+	pod.Spec.EphemeralContainers = []v1.EphemeralContainer{{
+		EphemeralContainerCommon: v1.EphemeralContainerCommon{
+			Name:            "debugger",
+			SecurityContext: &v1.SecurityContext{Privileged: newTrue()},
+		},
+	}}
+	if level, _ := SuggestedPodSecurityStandard(pod); level != psaapi.LevelPrivileged {
+		t.Fatalf("Expected the pod with the debug container to be privileged, got %v", level)
+	}
+
+	exclusions := DefaultContainerExclusions
+	result, excluded := exclusions.Apply(pod)
+	expected := []ExcludedContainer{
+		{Name: "istio-proxy", Reason: "injected, pod has annotation sidecar.istio.io/status"},
+		{Name: "istio-init", Reason: "injected, pod has annotation sidecar.istio.io/status"},
+		{Name: "debugger", Reason: "ephemeral container"},
+	}
+	if !reflect.DeepEqual(excluded, expected) {
+		t.Errorf("Expected excluded containers %v, got %v", expected, excluded)
+	}
+	if len(result.Spec.Containers) != 1 || result.Spec.InitContainers != nil || result.Spec.EphemeralContainers != nil {
+		t.Errorf("Unexpected containers left %+v", result.Spec)
+	}
+	if len(pod.Spec.Containers) != 2 {
+		t.Error("Expected the pod itself to be unchanged")
+	}
+	if level, _ := SuggestedPodSecurityStandard(result); level != psaapi.LevelBaseline {
+		t.Errorf("Expected baseline without the excluded containers, got %v", level)
+	}
+
+	exclusions = ContainerExclusions{Names: []string{"nginx"}}
+	result, excluded = exclusions.Apply(pod)
+	if len(excluded) != 1 || excluded[0].Reason != "excluded by name" || len(result.Spec.EphemeralContainers) != 1 {
+		t.Errorf("Expected only nginx to be excluded, got %v", excluded)
+	}
+
+	var none *ContainerExclusions
+	if result, excluded := none.Apply(pod); result != pod || len(excluded) != 0 {
+		t.Error("Expected no exclusions to return the pod")
+	}
+}
+
+func TestMutationCheckerExcludesInjectedSidecars(t *testing.T) {
+	clientset := fake.NewSimpleClientset(newReplicaSet("nginx", "default"))
+	checker := NewMutationChecker(clientset)
+	mutated, diff, err := checker.IsPodBeingMutatedByPSP(newMeshPod())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mutated {
+		t.Errorf("Expected the injected sidecar to be ignored, got diff %v", diff)
+	}
+
+	checker.Exclusions = nil
+	mutated, diff, err = checker.IsPodBeingMutatedByPSP(newMeshPod())
+	if err != nil || mutated {
+		t.Errorf("Expected added sidecars to not make the pod mutated, got %v, %v", mutated, err)
+	}
+	expected := []string{"containers[istio-proxy]: only in pod", "initContainers[istio-init]: only in pod"}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected diff %v without exclusions, got %v", expected, diff)
+	}
+}
+
+func TestInjectedSidecarsViolateBaseline(t *testing.T) {
+	pod := newMeshPod()
+	violating, err := ViolatingPods([]v1.Pod{*pod}, psaapi.LevelBaseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violating) != 1 {
+		t.Errorf("Expected istio-init to violate baseline, got %v", violating)
+	}
+
+	exclusions := DefaultContainerExclusions
+	pods, _ := exclusions.ApplyAll([]v1.Pod{*pod})
+	if violating, _ := ViolatingPods(pods, psaapi.LevelBaseline); len(violating) != 0 {
+		t.Errorf("Expected no violations without the injected sidecars, got %v", violating)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ControllerFetcher returns the controller object of the given kind, name and
+// namespace.
+type ControllerFetcher func(kind, name, namespace string) (runtime.Object, error)
+
+// ClientsetControllerFetcher returns a ControllerFetcher that gets
+// controllers from the API server using the given clientset.
+func ClientsetControllerFetcher(clientset kubernetes.Interface) ControllerFetcher {
+	return func(kind, name, namespace string) (runtime.Object, error) {
+		return FetchControllerObj(kind, name, namespace, clientset)
+	}
+}
+
+type controllerKey struct {
+	kind, name, namespace string
+}
+
+type controllerEntry struct {
+	done chan struct{}
+	obj  runtime.Object
+	err  error
+}
+
+// ControllerCache wraps a ControllerFetcher so that every controller is only
+// fetched once, no matter how many pods it owns. Concurrent lookups of the
+// same controller wait for the first one to finish. Errors are cached as
+// well. It is safe for concurrent use.
+type ControllerCache struct {
+	fetch   ControllerFetcher
+	mu      sync.Mutex
+	entries map[controllerKey]*controllerEntry
+}
+
+// NewControllerCache returns an empty ControllerCache that uses fetch to look
+// up controllers that aren't cached yet.
+func NewControllerCache(fetch ControllerFetcher) *ControllerCache {
+	return &ControllerCache{
+		fetch:   fetch,
+		entries: make(map[controllerKey]*controllerEntry),
+	}
+}
+
+// Fetch returns the cached controller object, fetching it on first use. It
+// has the signature of a ControllerFetcher.
+func (c *ControllerCache) Fetch(kind, name, namespace string) (runtime.Object, error) {
+	key := controllerKey{kind: kind, name: name, namespace: namespace}
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &controllerEntry{done: make(chan struct{})}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	if ok {
+		<-entry.done
+		return entry.obj, entry.err
+	}
+	entry.obj, entry.err = c.fetch(kind, name, namespace)
+	close(entry.done)
+	return entry.obj, entry.err
+}
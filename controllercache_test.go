@@ -0,0 +1,109 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newReplicaSet(name, namespace string) *appsv1.ReplicaSet {
+	return &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: appsv1.ReplicaSetSpec{
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{Name: "nginx", Image: "nginx"}},
+				},
+			},
+		},
+	}
+}
+
+func newOwnedPod(name, namespace, ownerKind, ownerName string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: ownerKind, Name: ownerName, Controller: newTrue()},
+			},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "nginx", Image: "nginx"}},
+		},
+	}
+}
+
+func TestControllerCacheFetchesOnce(t *testing.T) {
+	var calls int32
+	cache := NewControllerCache(func(kind, name, namespace string) (runtime.Object, error) {
+		atomic.AddInt32(&calls, 1)
+		return newReplicaSet(name, namespace), nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.Fetch("ReplicaSet", "nginx", "default"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := cache.Fetch("ReplicaSet", "other", "default"); err != nil {
+		t.Error(err)
+	}
+
+	if calls != 2 {
+		t.Errorf("Expected 2 fetches, but got %v", calls)
+	}
+}
+
+func TestMutationCheckerSharesOwnerLookups(t *testing.T) {
+	clientset := fake.NewSimpleClientset(newReplicaSet("nginx", "default"))
+	checker := NewMutationChecker(clientset)
+
+	for _, name := range []string{"nginx-1", "nginx-2", "nginx-3"} {
+		mutated, diff, err := checker.IsPodBeingMutatedByPSP(newOwnedPod(name, "default", "ReplicaSet", "nginx"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mutated {
+			t.Errorf("Expected pod %v to not be mutated, but got diff %v", name, diff)
+		}
+	}
+
+	gets := 0
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "get" && action.GetResource().Resource == "replicasets" {
+			gets++
+		}
+	}
+	if gets != 1 {
+		t.Errorf("Expected the ReplicaSet to be fetched once, but got %v gets", gets)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// UnsupportedOwnerError is returned when the pod template of a controller
+// can't be looked up, because its kind isn't supported.
+type UnsupportedOwnerError struct {
+	Kind string
+}
+
+func (e *UnsupportedOwnerError) Error() string {
+	return fmt.Sprintf("unsupported controller kind %s", e.Kind)
+}
+
+// OwnerNotFoundError is returned when the controller of a pod no longer
+// exists. It wraps the NotFound error of the API server, so
+// apierrors.IsNotFound works as well.
+type OwnerNotFoundError struct {
+	Kind, Name, Namespace string
+	Err                   error
+}
+
+func (e *OwnerNotFoundError) Error() string {
+	return fmt.Sprintf("%s %s in namespace %s not found", e.Kind, e.Name, e.Namespace)
+}
+
+func (e *OwnerNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// ForbiddenError is returned when the user isn't allowed to get an object
+// that is needed for a check, e.g. the controller of a pod.
+type ForbiddenError struct {
+	// Resource is the kind or resource of the object, e.g. "ReplicaSet".
+	Resource, Name, Namespace string
+	Err                       error
+}
+
+func (e *ForbiddenError) Error() string {
+	if e.Namespace == "" {
+		return fmt.Sprintf("not allowed to get %s %s: %v", e.Resource, e.Name, e.Err)
+	}
+	return fmt.Sprintf("not allowed to get %s %s in namespace %s: %v", e.Resource, e.Name, e.Namespace, e.Err)
+}
+
+func (e *ForbiddenError) Unwrap() error {
+	return e.Err
+}
+
+// PSPNotFoundError is returned when a PodSecurityPolicy object doesn't exist,
+// e.g. the one a pod was admitted by was deleted since.
+type PSPNotFoundError struct {
+	Name string
+	Err  error
+}
+
+func (e *PSPNotFoundError) Error() string {
+	return fmt.Sprintf("PodSecurityPolicy %s not found", e.Name)
+}
+
+func (e *PSPNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// typedAPIError returns the typed error for NotFound and Forbidden errors of
+// the API server, and other errors as they are.
+func typedAPIError(err error, kind, name, namespace string) error {
+	switch {
+	case apierrors.IsForbidden(err):
+		return &ForbiddenError{Resource: kind, Name: name, Namespace: namespace, Err: err}
+	case apierrors.IsNotFound(err) && kind == "PodSecurityPolicy":
+		return &PSPNotFoundError{Name: name, Err: err}
+	case apierrors.IsNotFound(err):
+		return &OwnerNotFoundError{Kind: kind, Name: name, Namespace: namespace, Err: err}
+	}
+	return err
+}
+
+// FetchPSP gets a PodSecurityPolicy object. A PSPNotFoundError or a
+// ForbiddenError is returned if it doesn't exist or can't be read.
+func FetchPSP(name string, clientset kubernetes.Interface) (*v1beta1.PodSecurityPolicy, error) {
+	psp, err := clientset.PolicyV1beta1().PodSecurityPolicies().Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, typedAPIError(err, "PodSecurityPolicy", name, "")
+	}
+	return psp, nil
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"errors"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestFetchControllerObjTypedErrors(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("get", "daemonsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "apps", Resource: "daemonsets"}, "agent", errors.New("no access"))
+	})
+
+	var unsupported *UnsupportedOwnerError
+	if _, err := FetchControllerObj("StatefulSet", "web", "default", clientset); !errors.As(err, &unsupported) || unsupported.Kind != "StatefulSet" {
+		t.Errorf("Expected an UnsupportedOwnerError, got %v", err)
+	}
+	var notFound *OwnerNotFoundError
+	_, err := FetchControllerObj("ReplicaSet", "nginx", "default", clientset)
+	if !errors.As(err, &notFound) || notFound.Name != "nginx" || !apierrors.IsNotFound(err) {
+		t.Errorf("Expected an OwnerNotFoundError, got %v", err)
+	}
+	var forbidden *ForbiddenError
+	if _, err := FetchControllerObj("DaemonSet", "agent", "default", clientset); !errors.As(err, &forbidden) || !apierrors.IsForbidden(err) {
+		t.Errorf("Expected a ForbiddenError, got %v", err)
+	}
+	var pspNotFound *PSPNotFoundError
+	if _, err := FetchPSP("restricted", clientset); !errors.As(err, &pspNotFound) || pspNotFound.Name != "restricted" {
+		t.Errorf("Expected a PSPNotFoundError, got %v", err)
+	}
+}
+
+func TestCheckPodUnsupportedOwner(t *testing.T) {
+	checker := &MutationChecker{FetchController: ClientsetControllerFetcher(fake.NewSimpleClientset())}
+	var unsupported *UnsupportedOwnerError
+	if _, err := checker.CheckPod(newOwnedPod("web-0", "default", "StatefulSet", "web")); !errors.As(err, &unsupported) {
+		t.Errorf("Expected an UnsupportedOwnerError, got %v", err)
+	}
+}
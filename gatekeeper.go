@@ -0,0 +1,248 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"embed"
+	"fmt"
+	"strings"
+
+	"k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/yaml"
+)
+
+// gatekeeperTemplates are the ConstraintTemplates of the generated
+// constraints. Their kinds and parameters match the templates of the
+// gatekeeper-library, so constraints work with either.
+//
+//go:embed gatekeeper/*.yaml
+var gatekeeperTemplates embed.FS
+
+// GatekeeperOptions configures the generated Gatekeeper constraints.
+type GatekeeperOptions struct {
+	// Namespaces limits the constraints to pods in these namespaces. The
+	// constraints apply to all namespaces if it's empty.
+	Namespaces []string
+	// EnforcementAction is deny, dryrun or warn.
+	EnforcementAction string
+}
+
+// GatekeeperConstraints converts the controls of the PodSecurityPolicy that
+// aren't covered by the Pod Security Standard it maps to into Gatekeeper
+// constraints, see ResidualPSPControls. Controls that can't be expressed by
+// the templates are returned as unsupported, e.g. when the PodSecurityPolicy
+// allows the host PID namespace but not the host IPC namespace.
+func GatekeeperConstraints(psp *v1beta1.PodSecurityPolicy, opts GatekeeperOptions) (
+	constraints []*unstructured.Unstructured, unsupported []PSPControl) {
+	level, controls := ResidualPSPControls(psp)
+	spec := &psp.Spec
+	residual := sets.NewString()
+	for _, control := range controls {
+		residual.Insert(string(control))
+	}
+	has := func(control PSPControl) bool { return residual.Has(string(control)) }
+
+	action := opts.EnforcementAction
+	if action == "" {
+		action = "dryrun"
+	}
+	constraints = make([]*unstructured.Unstructured, 0)
+	unsupported = make([]PSPControl, 0)
+	add := func(kind string, parameters map[string]interface{}) {
+		constraintSpec := map[string]interface{}{
+			"enforcementAction": action,
+			"match":             gatekeeperMatch(opts.Namespaces),
+		}
+		if parameters != nil {
+			constraintSpec["parameters"] = parameters
+		}
+		constraints = append(constraints, &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "constraints.gatekeeper.sh/v1beta1",
+			"kind":       kind,
+			"metadata": map[string]interface{}{
+				"name": fmt.Sprintf("psp-%s-%s", psp.Name, kebabCase(strings.TrimPrefix(kind, "K8sPSP"))),
+				"annotations": map[string]interface{}{
+					PSPAnnotation:                 psp.Name,
+					PodSecurityStandardAnnotation: string(level),
+				},
+			},
+			"spec": constraintSpec,
+		}})
+	}
+
+	if has(ControlPrivileged) {
+		add("K8sPSPPrivilegedContainer", nil)
+	}
+	if has(ControlHostNamespaces) {
+		switch {
+		case !spec.HostPID && !spec.HostIPC:
+			add("K8sPSPHostNamespace", nil)
+		case spec.HostPID != spec.HostIPC:
+			unsupported = append(unsupported, ControlHostNamespaces)
+		}
+	}
+	restrictsHostNetwork := has(ControlHostNamespaces) && !spec.HostNetwork
+	restrictsHostPorts := has(ControlHostPorts)
+	if restrictsHostPorts && len(spec.HostPorts) > 1 {
+		// the template only supports a single range of ports
+		unsupported = append(unsupported, ControlHostPorts)
+		restrictsHostPorts = false
+	}
+	if restrictsHostNetwork || restrictsHostPorts {
+		min, max := int64(0), int64(65535)
+		if restrictsHostPorts {
+			min, max = 0, 0
+			if len(spec.HostPorts) == 1 {
+				min, max = int64(spec.HostPorts[0].Min), int64(spec.HostPorts[0].Max)
+			}
+		}
+		add("K8sPSPHostNetworkingPorts", map[string]interface{}{
+			"hostNetwork": spec.HostNetwork,
+			"min":         min,
+			"max":         max,
+		})
+	}
+	if has(ControlCapabilities) || has(ControlRequiredDropCapabilities) {
+		parameters := map[string]interface{}{"allowedCapabilities": []interface{}{"*"}}
+		if has(ControlCapabilities) {
+			parameters["allowedCapabilities"] = toInterfaces(pspAllowedCapabilities(psp).List())
+		}
+		if has(ControlRequiredDropCapabilities) {
+			drop := make([]string, 0, len(spec.RequiredDropCapabilities))
+			for _, c := range spec.RequiredDropCapabilities {
+				drop = append(drop, string(c))
+			}
+			parameters["requiredDropCapabilities"] = toInterfaces(drop)
+		}
+		add("K8sPSPCapabilities", parameters)
+	}
+	if has(ControlVolumes) {
+		add("K8sPSPVolumeTypes", map[string]interface{}{"volumes": toInterfaces(pspVolumes(psp).List())})
+	}
+	if has(ControlHostPaths) {
+		paths := make([]interface{}, 0, len(spec.AllowedHostPaths))
+		for _, p := range spec.AllowedHostPaths {
+			paths = append(paths, map[string]interface{}{"pathPrefix": p.PathPrefix, "readOnly": p.ReadOnly})
+		}
+		add("K8sPSPHostFilesystem", map[string]interface{}{"allowedHostPaths": paths})
+	}
+	if has(ControlFlexVolumes) {
+		drivers := make([]interface{}, 0, len(spec.AllowedFlexVolumes))
+		for _, d := range spec.AllowedFlexVolumes {
+			drivers = append(drivers, map[string]interface{}{"driver": d.Driver})
+		}
+		add("K8sPSPFlexVolumes", map[string]interface{}{"allowedFlexVolumes": drivers})
+	}
+	if has(ControlCSIDrivers) {
+		drivers := make([]interface{}, 0, len(spec.AllowedCSIDrivers))
+		for _, d := range spec.AllowedCSIDrivers {
+			drivers = append(drivers, map[string]interface{}{"name": d.Name})
+		}
+		add("K8sPSPCSIDrivers", map[string]interface{}{"allowedCSIDrivers": drivers})
+	}
+	if has(ControlReadOnlyRootFilesystem) {
+		add("K8sPSPReadOnlyRootFilesystem", nil)
+	}
+
+	users := map[string]interface{}{}
+	if has(ControlRunAsUser) {
+		users["runAsUser"] = gatekeeperIDRule(string(spec.RunAsUser.Rule), spec.RunAsUser.Ranges)
+	}
+	if has(ControlRunAsGroup) {
+		users["runAsGroup"] = gatekeeperIDRule(string(spec.RunAsGroup.Rule), spec.RunAsGroup.Ranges)
+	}
+	if has(ControlSupplementalGroups) {
+		users["supplementalGroups"] = gatekeeperIDRule(string(spec.SupplementalGroups.Rule), spec.SupplementalGroups.Ranges)
+	}
+	if has(ControlFSGroup) {
+		users["fsGroup"] = gatekeeperIDRule(string(spec.FSGroup.Rule), spec.FSGroup.Ranges)
+	}
+	if len(users) > 0 {
+		add("K8sPSPAllowedUsers", users)
+	}
+
+	if has(ControlAllowPrivilegeEscalation) {
+		add("K8sPSPAllowPrivilegeEscalationContainer", nil)
+	}
+	if has(ControlSELinux) {
+		add("K8sPSPSELinuxV2", map[string]interface{}{
+			"allowedSELinuxOptions": []interface{}{seLinuxOptionsMap(spec.SELinux.SELinuxOptions)},
+		})
+	}
+	if has(ControlProcMount) {
+		add("K8sPSPProcMount", map[string]interface{}{"procMount": "Default"})
+	}
+	if has(ControlSysctls) {
+		parameters := map[string]interface{}{}
+		if len(spec.ForbiddenSysctls) > 0 {
+			parameters["forbiddenSysctls"] = toInterfaces(spec.ForbiddenSysctls)
+		}
+		if !sets.NewString(spec.AllowedUnsafeSysctls...).Has("*") {
+			parameters["allowedSysctls"] = toInterfaces(append(append([]string{}, SafeSysctls...), spec.AllowedUnsafeSysctls...))
+		}
+		add("K8sPSPForbiddenSysctls", parameters)
+	}
+	if has(ControlSeccomp) {
+		profiles, _ := pspProfiles(psp, SeccompAllowedProfilesAnnotation)
+		add("K8sPSPSeccomp", map[string]interface{}{"allowedProfiles": toInterfaces(profiles)})
+	}
+	if has(ControlAppArmor) {
+		profiles, _ := pspProfiles(psp, AppArmorAllowedProfilesAnnotation)
+		add("K8sPSPAppArmor", map[string]interface{}{"allowedProfiles": toInterfaces(profiles)})
+	}
+	return constraints, unsupported
+}
+
+// GatekeeperConstraintTemplate returns the ConstraintTemplate of the
+// constraint kind.
+func GatekeeperConstraintTemplate(kind string) (*unstructured.Unstructured, error) {
+	data, err := gatekeeperTemplates.ReadFile("gatekeeper/" + strings.ToLower(kind) + ".yaml")
+	if err != nil {
+		return nil, fmt.Errorf("no ConstraintTemplate for kind %s: %w", kind, err)
+	}
+	template := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal(data, &template.Object); err != nil {
+		return nil, fmt.Errorf("failed to decode ConstraintTemplate for kind %s: %w", kind, err)
+	}
+	return template, nil
+}
+
+func gatekeeperMatch(namespaces []string) map[string]interface{} {
+	match := map[string]interface{}{
+		"kinds": []interface{}{
+			map[string]interface{}{"apiGroups": []interface{}{""}, "kinds": []interface{}{"Pod"}},
+		},
+	}
+	if len(namespaces) > 0 {
+		match["namespaces"] = toInterfaces(namespaces)
+	}
+	return match
+}
+
+func gatekeeperIDRule(rule string, ranges []v1beta1.IDRange) map[string]interface{} {
+	result := map[string]interface{}{"rule": rule}
+	if len(ranges) > 0 {
+		r := make([]interface{}, 0, len(ranges))
+		for _, idRange := range ranges {
+			r = append(r, map[string]interface{}{"min": idRange.Min, "max": idRange.Max})
+		}
+		result["ranges"] = r
+	}
+	return result
+}
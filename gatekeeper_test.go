@@ -0,0 +1,114 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+func TestGatekeeperConstraints(t *testing.T) {
+	psp := newBaselinePSP()
+	psp.Spec.ReadOnlyRootFilesystem = true
+	psp.Spec.RunAsUser = v1beta1.RunAsUserStrategyOptions{
+		Rule:   v1beta1.RunAsUserStrategyMustRunAs,
+		Ranges: []v1beta1.IDRange{{Min: 1000, Max: 2000}},
+	}
+
+	constraints, unsupported := GatekeeperConstraints(psp, GatekeeperOptions{Namespaces: []string{"team-a"}})
+	if len(unsupported) != 0 {
+		t.Errorf("Expected all controls to be supported, but got %v", unsupported)
+	}
+	kinds := make([]string, 0)
+	for _, constraint := range constraints {
+		constraint = deepCopyUnstructured(t, constraint)
+		kinds = append(kinds, constraint.GetKind())
+		if _, err := GatekeeperConstraintTemplate(constraint.GetKind()); err != nil {
+			t.Error(err)
+		}
+		namespaces, _, _ := unstructured.NestedStringSlice(constraint.Object, "spec", "match", "namespaces")
+		if !reflect.DeepEqual(namespaces, []string{"team-a"}) {
+			t.Errorf("Expected %v to match team-a, but got %v", constraint.GetName(), namespaces)
+		}
+	}
+	expected := []string{"K8sPSPReadOnlyRootFilesystem", "K8sPSPAllowedUsers", "K8sPSPSELinuxV2"}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("Expected %v, but got %v", expected, kinds)
+	}
+}
+
+func TestGatekeeperConstraintsHostPorts(t *testing.T) {
+	psp := newPrivilegedPSP()
+	psp.Name = "host-ports"
+	psp.Spec.HostNetwork = false
+	psp.Spec.HostPorts = []v1beta1.HostPortRange{{Min: 8000, Max: 8080}}
+	constraints, _ := GatekeeperConstraints(psp, GatekeeperOptions{})
+	if len(constraints) != 1 {
+		t.Fatalf("Expected a single constraint, but got %v", constraints)
+	}
+	ports := constraints[0]
+	if ports.GetName() != "psp-host-ports-host-networking-ports" {
+		t.Errorf("Unexpected name %v", ports.GetName())
+	}
+	min, _, _ := unstructured.NestedInt64(ports.Object, "spec", "parameters", "min")
+	max, _, _ := unstructured.NestedInt64(ports.Object, "spec", "parameters", "max")
+	if min != 8000 || max != 8080 {
+		t.Errorf("Expected host ports 8000-8080, but got %v-%v", min, max)
+	}
+}
+
+func TestGatekeeperConstraintsUnsupported(t *testing.T) {
+	psp := newPrivilegedPSP()
+	psp.Spec.HostIPC = false
+	psp.Spec.HostNetwork = false
+	psp.Spec.HostPorts = []v1beta1.HostPortRange{{Min: 80, Max: 80}, {Min: 443, Max: 443}}
+	constraints, unsupported := GatekeeperConstraints(psp, GatekeeperOptions{})
+	if !reflect.DeepEqual(unsupported, []PSPControl{ControlHostNamespaces, ControlHostPorts}) {
+		t.Errorf("Expected host namespaces and ports to be unsupported, but got %v", unsupported)
+	}
+	// the host network is still restricted by the constraint
+	if len(constraints) != 1 || constraints[0].GetKind() != "K8sPSPHostNetworkingPorts" {
+		t.Errorf("Expected a K8sPSPHostNetworkingPorts constraint, but got %v", constraints)
+	}
+}
+
+func TestGatekeeperConstraintTemplates(t *testing.T) {
+	entries, err := gatekeeperTemplates.ReadDir("gatekeeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".yaml")
+		data, err := gatekeeperTemplates.ReadFile("gatekeeper/" + entry.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(data, &template.Object); err != nil {
+			t.Fatal(err)
+		}
+		kind, _, _ := unstructured.NestedString(template.Object, "spec", "crd", "spec", "names", "kind")
+		if template.GetName() != name || strings.ToLower(kind) != name {
+			t.Errorf("Template %v has name %v and kind %v", entry.Name(), template.GetName(), kind)
+		}
+	}
+}
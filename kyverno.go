@@ -0,0 +1,627 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// PSPAnnotation is set on generated policies to the name of the
+	// PodSecurityPolicy they were converted from.
+	PSPAnnotation = "pspmigrator.kubernetes.io/psp"
+	// PodSecurityStandardAnnotation is set on generated policies to the Pod
+	// Security Standard the PodSecurityPolicy maps to.
+	PodSecurityStandardAnnotation = "pspmigrator.kubernetes.io/pod-security-standard"
+
+	kyvernoContainers = "request.object.spec.[ephemeralContainers, initContainers, containers][]"
+)
+
+// KyvernoOptions configures the generated Kyverno policies.
+type KyvernoOptions struct {
+	// Namespaces limits the policy to pods in these namespaces. The policy
+	// applies to all namespaces if it's empty.
+	Namespaces []string
+	// ValidationFailureAction is Audit or Enforce.
+	ValidationFailureAction string
+}
+
+// KyvernoValidatePolicy converts the controls of the PodSecurityPolicy that
+// aren't covered by the Pod Security Standard it maps to into a Kyverno
+// ClusterPolicy, see ResidualPSPControls. It returns nil if there are no such
+// controls.
+func KyvernoValidatePolicy(psp *v1beta1.PodSecurityPolicy, opts KyvernoOptions) *unstructured.Unstructured {
+	level, controls := ResidualPSPControls(psp)
+	if len(controls) == 0 {
+		return nil
+	}
+	rules := make([]interface{}, 0, len(controls))
+	for _, control := range controls {
+		for _, rule := range kyvernoRules(psp, control) {
+			rule["match"] = kyvernoMatch(opts.Namespaces)
+			rules = append(rules, rule)
+		}
+	}
+	action := opts.ValidationFailureAction
+	if action == "" {
+		action = "Audit"
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "kyverno.io/v1",
+		"kind":       "ClusterPolicy",
+		"metadata": map[string]interface{}{
+			"name": "psp-" + psp.Name,
+			"annotations": map[string]interface{}{
+				"policies.kyverno.io/title": fmt.Sprintf("PodSecurityPolicy %s", psp.Name),
+				"policies.kyverno.io/description": fmt.Sprintf(
+					"Controls of PodSecurityPolicy %s that aren't enforced by the %s Pod Security Standard.", psp.Name, level),
+				PSPAnnotation:                 psp.Name,
+				PodSecurityStandardAnnotation: string(level),
+			},
+		},
+		"spec": map[string]interface{}{
+			"validationFailureAction": action,
+			"background":              true,
+			"rules":                   rules,
+		},
+	}}
+}
+
+func kyvernoMatch(namespaces []string) map[string]interface{} {
+	resources := map[string]interface{}{"kinds": []interface{}{"Pod"}}
+	if len(namespaces) > 0 {
+		resources["namespaces"] = toInterfaces(namespaces)
+	}
+	return map[string]interface{}{
+		"any": []interface{}{map[string]interface{}{"resources": resources}},
+	}
+}
+
+func kyvernoRules(psp *v1beta1.PodSecurityPolicy, control PSPControl) []map[string]interface{} {
+	spec := &psp.Spec
+	message := func(format string, a ...interface{}) string {
+		return fmt.Sprintf(format, a...) + fmt.Sprintf(" by PodSecurityPolicy %s.", psp.Name)
+	}
+	rule := func(validate map[string]interface{}) []map[string]interface{} {
+		return []map[string]interface{}{{"name": kebabCase(string(control)), "validate": validate}}
+	}
+
+	switch control {
+	case ControlPrivileged:
+		return rule(map[string]interface{}{
+			"message": message("Privileged containers are not allowed"),
+			"pattern": kyvernoContainersPattern(map[string]interface{}{
+				"=(securityContext)": map[string]interface{}{"=(privileged)": "false"},
+			}),
+		})
+	case ControlHostNamespaces:
+		pattern := map[string]interface{}{}
+		if !spec.HostNetwork {
+			pattern["=(hostNetwork)"] = "false"
+		}
+		if !spec.HostPID {
+			pattern["=(hostPID)"] = "false"
+		}
+		if !spec.HostIPC {
+			pattern["=(hostIPC)"] = "false"
+		}
+		return rule(map[string]interface{}{
+			"message": message("Sharing the host namespaces is not allowed"),
+			"pattern": map[string]interface{}{"spec": pattern},
+		})
+	case ControlHostPorts:
+		ranges := []string{"0-0"}
+		for _, r := range spec.HostPorts {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", r.Min, r.Max))
+		}
+		return rule(kyvernoForeach(kyvernoContainers, message("Host ports outside of %s are not allowed", ranges[1:]),
+			map[string]interface{}{"any": []interface{}{
+				kyvernoCondition("{{ element.ports[].hostPort || `[]` }}", "AnyNotIn", ranges),
+			}}))
+	case ControlCapabilities:
+		allowed := pspAllowedCapabilities(psp).List()
+		return rule(kyvernoForeach(kyvernoContainers, message("Adding capabilities other than %v is not allowed", allowed),
+			map[string]interface{}{"any": []interface{}{
+				kyvernoCondition("{{ element.securityContext.capabilities.add[] || `[]` }}", "AnyNotIn", allowed),
+			}}))
+	case ControlRequiredDropCapabilities:
+		required := make([]string, 0)
+		for _, c := range spec.RequiredDropCapabilities {
+			required = append(required, string(c))
+		}
+		return rule(kyvernoForeach(kyvernoContainers, message("Containers must drop the capabilities %v", required),
+			map[string]interface{}{"any": []interface{}{
+				kyvernoCondition(required, "AnyNotIn", "{{ element.securityContext.capabilities.drop[] || `[]` }}"),
+			}}))
+	case ControlVolumes:
+		allowed := pspVolumes(psp).List()
+		return rule(kyvernoForeach("request.object.spec.volumes[]", message("Only volumes of type %v are allowed", allowed),
+			map[string]interface{}{"any": []interface{}{
+				kyvernoCondition("{{ element.keys(@) }}", "AnyNotIn", append([]string{"name"}, allowed...)),
+			}}))
+	case ControlHostPaths:
+		allowed, readOnly := make([]string, 0), make([]string, 0)
+		for _, p := range spec.AllowedHostPaths {
+			allowed = append(allowed, hostPathPatterns(p.PathPrefix)...)
+			if !p.ReadOnly {
+				continue
+			}
+			readOnly = append(readOnly, hostPathPatterns(p.PathPrefix)...)
+		}
+		rules := rule(kyvernoForeach("request.object.spec.volumes[?hostPath]",
+			message("Only host paths with the prefixes %v are allowed", allowed),
+			map[string]interface{}{"any": []interface{}{
+				kyvernoCondition("{{ element.hostPath.path }}", "AnyNotIn", allowed),
+			}}))
+		writable := sets.NewString(allowed...).Difference(sets.NewString(readOnly...)).List()
+		if len(readOnly) > 0 {
+			readOnlyRule := kyvernoForeach("request.object.spec.volumes[?hostPath]",
+				message("Host paths with the prefixes %v must be mounted read-only", readOnly),
+				map[string]interface{}{"any": []interface{}{
+					kyvernoCondition("{{ request.object.spec.[ephemeralContainers, initContainers, containers][].volumeMounts[?name == '{{ element.name }}' && readOnly != `true`][] | length(@) }}",
+						"GreaterThan", 0),
+				}})
+			preconditions := []interface{}{kyvernoCondition("{{ element.hostPath.path }}", "AnyIn", readOnly)}
+			if len(writable) > 0 {
+				preconditions = append(preconditions, kyvernoCondition("{{ element.hostPath.path }}", "AnyNotIn", writable))
+			}
+			readOnlyRule["foreach"].([]interface{})[0].(map[string]interface{})["preconditions"] =
+				map[string]interface{}{"all": preconditions}
+			rules = append(rules, map[string]interface{}{"name": "allowed-host-paths-read-only", "validate": readOnlyRule})
+		}
+		return rules
+	case ControlFlexVolumes:
+		drivers := make([]string, 0)
+		for _, d := range spec.AllowedFlexVolumes {
+			drivers = append(drivers, d.Driver)
+		}
+		return rule(kyvernoForeach("request.object.spec.volumes[?flexVolume]",
+			message("Only the flexVolume drivers %v are allowed", drivers),
+			map[string]interface{}{"any": []interface{}{
+				kyvernoCondition("{{ element.flexVolume.driver }}", "AnyNotIn", drivers),
+			}}))
+	case ControlCSIDrivers:
+		drivers := make([]string, 0)
+		for _, d := range spec.AllowedCSIDrivers {
+			drivers = append(drivers, d.Name)
+		}
+		return rule(kyvernoForeach("request.object.spec.volumes[?csi]",
+			message("Only the CSI drivers %v are allowed", drivers),
+			map[string]interface{}{"any": []interface{}{
+				kyvernoCondition("{{ element.csi.driver }}", "AnyNotIn", drivers),
+			}}))
+	case ControlReadOnlyRootFilesystem:
+		return rule(map[string]interface{}{
+			"message": message("Containers must use a read-only root filesystem"),
+			"pattern": kyvernoContainersPattern(map[string]interface{}{
+				"securityContext": map[string]interface{}{"readOnlyRootFilesystem": "true"},
+			}),
+		})
+	case ControlRunAsUser:
+		if spec.RunAsUser.Rule == v1beta1.RunAsUserStrategyMustRunAsNonRoot {
+			return rule(kyvernoForeach(kyvernoContainers, message("Containers must run as non-root"),
+				map[string]interface{}{
+					"all": []interface{}{
+						kyvernoCondition("{{ element.securityContext.runAsUser || request.object.spec.securityContext.runAsUser || `0` }}", "Equals", 0),
+					},
+					"any": []interface{}{
+						kyvernoCondition("{{ to_string(element.securityContext.runAsNonRoot) }}", "Equals", "false"),
+						kyvernoCondition("{{ to_string(element.securityContext.runAsNonRoot) }}-{{ to_string(request.object.spec.securityContext.runAsNonRoot) }}",
+							"AnyIn", []string{"null-null", "null-false"}),
+					},
+				}))
+		}
+		return rule(kyvernoIDRule(kyvernoContainers, "runAsUser", true, spec.RunAsUser.Ranges, message))
+	case ControlRunAsGroup:
+		return rule(kyvernoIDRule(kyvernoContainers, "runAsGroup",
+			spec.RunAsGroup.Rule == v1beta1.RunAsGroupStrategyMustRunAs, spec.RunAsGroup.Ranges, message))
+	case ControlSupplementalGroups:
+		conditions := []interface{}{
+			kyvernoCondition("{{ request.object.spec.securityContext.supplementalGroups || `[]` }}", "AnyNotIn", idRanges(spec.SupplementalGroups.Ranges)),
+		}
+		if spec.SupplementalGroups.Rule == v1beta1.SupplementalGroupsStrategyMustRunAs {
+			conditions = append(conditions,
+				kyvernoCondition("{{ request.object.spec.securityContext.supplementalGroups || `[]` | length(@) }}", "Equals", 0))
+		}
+		return rule(map[string]interface{}{
+			"message": message("supplementalGroups must be in the ranges %v", idRanges(spec.SupplementalGroups.Ranges)),
+			"deny":    map[string]interface{}{"conditions": map[string]interface{}{"any": conditions}},
+		})
+	case ControlFSGroup:
+		conditions := []interface{}{
+			kyvernoCondition("{{ [request.object.spec.securityContext.fsGroup][] }}", "AnyNotIn", idRanges(spec.FSGroup.Ranges)),
+		}
+		if spec.FSGroup.Rule == v1beta1.FSGroupStrategyMustRunAs {
+			conditions = append(conditions,
+				kyvernoCondition("{{ [request.object.spec.securityContext.fsGroup][] | length(@) }}", "Equals", 0))
+		}
+		return rule(map[string]interface{}{
+			"message": message("fsGroup must be in the ranges %v", idRanges(spec.FSGroup.Ranges)),
+			"deny":    map[string]interface{}{"conditions": map[string]interface{}{"any": conditions}},
+		})
+	case ControlAllowPrivilegeEscalation:
+		return rule(map[string]interface{}{
+			"message": message("Privilege escalation is not allowed"),
+			"pattern": kyvernoContainersPattern(map[string]interface{}{
+				"securityContext": map[string]interface{}{"allowPrivilegeEscalation": "false"},
+			}),
+		})
+	case ControlSELinux:
+		options := seLinuxOptionsMap(spec.SELinux.SELinuxOptions)
+		return rule(kyvernoForeach(kyvernoContainers, message("SELinux options must be %v", options),
+			map[string]interface{}{"any": []interface{}{
+				kyvernoCondition("{{ element.securityContext.seLinuxOptions || request.object.spec.securityContext.seLinuxOptions || `{}` }}",
+					"NotEquals", options),
+			}}))
+	case ControlProcMount:
+		return rule(map[string]interface{}{
+			"message": message("Only the Default procMount is allowed"),
+			"pattern": kyvernoContainersPattern(map[string]interface{}{
+				"=(securityContext)": map[string]interface{}{"=(procMount)": string(v1.DefaultProcMount)},
+			}),
+		})
+	case ControlSysctls:
+		conditions := []interface{}{}
+		if !sets.NewString(spec.AllowedUnsafeSysctls...).Has("*") {
+			conditions = append(conditions, kyvernoCondition("{{ request.object.spec.securityContext.sysctls[].name || `[]` }}",
+				"AnyNotIn", append(append([]string{}, SafeSysctls...), spec.AllowedUnsafeSysctls...)))
+		}
+		if len(spec.ForbiddenSysctls) > 0 {
+			conditions = append(conditions, kyvernoCondition("{{ request.object.spec.securityContext.sysctls[].name || `[]` }}",
+				"AnyIn", spec.ForbiddenSysctls))
+		}
+		return rule(map[string]interface{}{
+			"message": message("Sysctls must be safe or in %v and not in %v", spec.AllowedUnsafeSysctls, spec.ForbiddenSysctls),
+			"deny":    map[string]interface{}{"conditions": map[string]interface{}{"any": conditions}},
+		})
+	case ControlSeccomp:
+		profiles, _ := pspProfiles(psp, SeccompAllowedProfilesAnnotation)
+		return rule(kyvernoForeach(kyvernoContainers, message("Only the seccomp profiles %v are allowed", profiles),
+			map[string]interface{}{"any": []interface{}{
+				kyvernoCondition("{{ element.securityContext.seccompProfile.type || request.object.spec.securityContext.seccompProfile.type || '' }}",
+					"AnyNotIn", SeccompProfileTypes(profiles)),
+			}}))
+	case ControlAppArmor:
+		profiles, _ := pspProfiles(psp, AppArmorAllowedProfilesAnnotation)
+		return rule(kyvernoForeach(kyvernoContainers, message("Only the AppArmor profiles %v are allowed", profiles),
+			map[string]interface{}{"any": []interface{}{
+				kyvernoCondition("{{ request.object.metadata.annotations.\""+v1.AppArmorBetaContainerAnnotationKeyPrefix+"{{ element.name }}\" || '' }}",
+					"AnyNotIn", profiles),
+			}}))
+	}
+	return nil
+}
+
+// kyvernoIDRule returns a rule that checks the effective user or group ID of
+// containers, which is the one of the container or else of the pod. Unlike
+// null, 0 is truthy in JMESPath, so a container running as root isn't
+// replaced by the pod's ID.
+func kyvernoIDRule(list, field string, required bool, ranges []v1beta1.IDRange,
+	message func(string, ...interface{}) string) map[string]interface{} {
+	ids := fmt.Sprintf("[element.securityContext.%s || request.object.spec.securityContext.%s][]", field, field)
+	conditions := []interface{}{
+		kyvernoCondition("{{ "+ids+" }}", "AnyNotIn", idRanges(ranges)),
+	}
+	if required {
+		conditions = append(conditions, kyvernoCondition("{{ "+ids+" | length(@) }}", "Equals", 0))
+	}
+	return kyvernoForeach(list, message("%s must be set to a value in the ranges %v", field, idRanges(ranges)),
+		map[string]interface{}{"any": conditions})
+}
+
+func kyvernoForeach(list, message string, conditions map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"message": message,
+		"foreach": []interface{}{
+			map[string]interface{}{
+				"list": list,
+				"deny": map[string]interface{}{"conditions": conditions},
+			},
+		},
+	}
+}
+
+func kyvernoCondition(key interface{}, operator string, value interface{}) map[string]interface{} {
+	if ss, ok := key.([]string); ok {
+		key = toInterfaces(ss)
+	}
+	if ss, ok := value.([]string); ok {
+		value = toInterfaces(ss)
+	}
+	if i, ok := value.(int); ok {
+		value = int64(i)
+	}
+	return map[string]interface{}{"key": key, "operator": operator, "value": value}
+}
+
+// kyvernoContainersPattern returns a pattern that applies the container
+// pattern to all containers, init containers and ephemeral containers.
+func kyvernoContainersPattern(container map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"spec": map[string]interface{}{
+			"=(ephemeralContainers)": []interface{}{container},
+			"=(initContainers)":      []interface{}{container},
+			"containers":             []interface{}{container},
+		},
+	}
+}
+
+// SeccompProfileTypes returns the seccompProfile types that correspond to
+// the profile names of the seccomp annotations. An empty type means the
+// profile may be unset.
+func SeccompProfileTypes(profiles []string) []string {
+	types := sets.NewString()
+	for _, profile := range profiles {
+		switch {
+		case profile == "":
+			types.Insert("")
+		case profile == "runtime/default" || profile == "docker/default":
+			types.Insert(string(v1.SeccompProfileTypeRuntimeDefault))
+		case profile == "unconfined":
+			types.Insert(string(v1.SeccompProfileTypeUnconfined))
+		case strings.HasPrefix(profile, "localhost/"):
+			types.Insert(string(v1.SeccompProfileTypeLocalhost))
+		}
+	}
+	return types.List()
+}
+
+func idRanges(ranges []v1beta1.IDRange) []string {
+	result := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		result = append(result, fmt.Sprintf("%d-%d", r.Min, r.Max))
+	}
+	return result
+}
+
+// hostPathPatterns returns the wildcard patterns matching the paths a
+// PodSecurityPolicy pathPrefix allows: the path itself and everything below
+// it, but not paths that merely start with the same characters.
+func hostPathPatterns(prefix string) []string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return []string{"/", "/*"}
+	}
+	return []string{prefix, prefix + "/*"}
+}
+
+func seLinuxOptionsMap(options *v1.SELinuxOptions) map[string]interface{} {
+	result := map[string]interface{}{}
+	if options == nil {
+		return result
+	}
+	for k, v := range map[string]string{"user": options.User, "role": options.Role, "type": options.Type, "level": options.Level} {
+		if v != "" {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// kebabCase converts a camel case field name such as allowedCSIDrivers to
+// allowed-csi-drivers.
+func kebabCase(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prevLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				b.WriteRune('-')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+func toInterfaces(ss []string) []interface{} {
+	result := make([]interface{}, 0, len(ss))
+	for _, s := range ss {
+		result = append(result, s)
+	}
+	return result
+}
+
+// KyvernoMutatePolicy returns a Kyverno ClusterPolicy with mutate rules that
+// reproduce the defaults the PodSecurityPolicy sets on pods, see
+// IsPSPMutating. Like the PodSecurityPolicy, the rules only set fields that
+// aren't set yet. It returns nil if the PodSecurityPolicy isn't mutating.
+func KyvernoMutatePolicy(psp *v1beta1.PodSecurityPolicy, opts KyvernoOptions) *unstructured.Unstructured {
+	spec := &psp.Spec
+	rules := make([]interface{}, 0)
+	add := func(name string, mutate map[string]interface{}) {
+		rules = append(rules, map[string]interface{}{
+			"name":   name,
+			"match":  kyvernoMatch(opts.Namespaces),
+			"mutate": mutate,
+		})
+	}
+
+	for _, c := range spec.DefaultAddCapabilities {
+		add("default-add-capability-"+strings.ToLower(string(c)), kyvernoAddCapability("add", string(c)))
+	}
+	for _, c := range spec.RequiredDropCapabilities {
+		add("required-drop-capability-"+strings.ToLower(string(c)), kyvernoAddCapability("drop", string(c)))
+	}
+
+	// pod security context defaults
+	podSecurityContext := map[string]interface{}{}
+	if spec.SELinux.Rule == v1beta1.SELinuxStrategyMustRunAs && spec.SELinux.SELinuxOptions != nil {
+		podSecurityContext["+(seLinuxOptions)"] = seLinuxOptionsMap(spec.SELinux.SELinuxOptions)
+	}
+	if spec.SupplementalGroups.Rule == v1beta1.SupplementalGroupsStrategyMustRunAs && len(spec.SupplementalGroups.Ranges) > 0 {
+		podSecurityContext["+(supplementalGroups)"] = []interface{}{spec.SupplementalGroups.Ranges[0].Min}
+	}
+	if spec.FSGroup.Rule == v1beta1.FSGroupStrategyMustRunAs && len(spec.FSGroup.Ranges) > 0 {
+		podSecurityContext["+(fsGroup)"] = spec.FSGroup.Ranges[0].Min
+	}
+	if profile, ok := psp.Annotations[SeccompDefaultProfileAnnotation]; ok {
+		if seccompProfile := seccompProfileMap(profile); seccompProfile != nil {
+			podSecurityContext["+(seccompProfile)"] = seccompProfile
+		}
+	}
+	if len(podSecurityContext) > 0 {
+		add("default-pod-security-context", map[string]interface{}{
+			"patchStrategicMerge": map[string]interface{}{
+				"spec": map[string]interface{}{"+(securityContext)": podSecurityContext},
+			},
+		})
+	}
+
+	// container security context defaults that depend on the pod
+	unset := func(field string) map[string]interface{} {
+		return kyvernoCondition(fmt.Sprintf("{{ element.securityContext.%[1]s == null && request.object.spec.securityContext.%[1]s == null }}", field),
+			"Equals", true)
+	}
+	if spec.RunAsUser.Rule == v1beta1.RunAsUserStrategyMustRunAs && len(spec.RunAsUser.Ranges) > 0 {
+		add("default-run-as-user", kyvernoContainersMutation([]interface{}{unset("runAsUser")},
+			map[string]interface{}{"runAsUser": spec.RunAsUser.Ranges[0].Min}))
+	}
+	if spec.RunAsUser.Rule == v1beta1.RunAsUserStrategyMustRunAsNonRoot {
+		add("default-run-as-non-root", kyvernoContainersMutation([]interface{}{unset("runAsUser"), unset("runAsNonRoot")},
+			map[string]interface{}{"runAsNonRoot": true}))
+	}
+	if spec.RunAsGroup != nil && spec.RunAsGroup.Rule == v1beta1.RunAsGroupStrategyMustRunAs && len(spec.RunAsGroup.Ranges) > 0 {
+		add("default-run-as-group", kyvernoContainersMutation([]interface{}{unset("runAsGroup")},
+			map[string]interface{}{"runAsGroup": spec.RunAsGroup.Ranges[0].Min}))
+	}
+
+	// container security context defaults
+	containerSecurityContext := map[string]interface{}{}
+	if spec.ReadOnlyRootFilesystem {
+		containerSecurityContext["+(readOnlyRootFilesystem)"] = true
+	}
+	if spec.AllowPrivilegeEscalation != nil && !*spec.AllowPrivilegeEscalation {
+		containerSecurityContext["+(allowPrivilegeEscalation)"] = false
+	} else if spec.DefaultAllowPrivilegeEscalation != nil {
+		containerSecurityContext["+(allowPrivilegeEscalation)"] = *spec.DefaultAllowPrivilegeEscalation
+	}
+	if len(containerSecurityContext) > 0 {
+		add("default-container-security-context", kyvernoContainersMutation(nil, containerSecurityContext))
+	}
+
+	if profile, ok := psp.Annotations[AppArmorDefaultProfileAnnotation]; ok {
+		patch := func(string) map[string]interface{} {
+			return map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]interface{}{
+						"+(" + v1.AppArmorBetaContainerAnnotationKeyPrefix + "{{ element.name }})": profile,
+					},
+				},
+			}
+		}
+		add("default-apparmor-profile", kyvernoForeachMutation(nil, patch))
+	}
+
+	if len(rules) == 0 {
+		return nil
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "kyverno.io/v1",
+		"kind":       "ClusterPolicy",
+		"metadata": map[string]interface{}{
+			"name": "psp-" + psp.Name + "-defaults",
+			"annotations": map[string]interface{}{
+				"policies.kyverno.io/title": fmt.Sprintf("PodSecurityPolicy %s defaults", psp.Name),
+				"policies.kyverno.io/description": fmt.Sprintf(
+					"Sets the defaults of PodSecurityPolicy %s on pods that don't set these fields.", psp.Name),
+				// PodSecurityPolicies only mutate pods, not their controllers
+				"pod-policies.kyverno.io/autogen-controllers": "none",
+				PSPAnnotation: psp.Name,
+			},
+		},
+		"spec": map[string]interface{}{
+			"background": false,
+			"rules":      rules,
+		},
+	}}
+}
+
+// kyvernoAddCapability returns a mutation that adds the capability to the add
+// or drop list of every container that doesn't have it yet.
+func kyvernoAddCapability(list, capability string) map[string]interface{} {
+	capabilities := fmt.Sprintf("element.securityContext.capabilities.%s", list)
+	return kyvernoContainersMutation(
+		[]interface{}{kyvernoCondition(capability, "AnyNotIn", fmt.Sprintf("{{ %s || `[]` }}", capabilities))},
+		map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				list: fmt.Sprintf("{{ [%s || `[]`, `[\"%s\"]`][] }}", capabilities, capability),
+			},
+		})
+}
+
+// kyvernoContainersMutation returns a mutation that merges the security
+// context into every container and init container that meets the
+// preconditions.
+func kyvernoContainersMutation(preconditions []interface{}, securityContext map[string]interface{}) map[string]interface{} {
+	return kyvernoForeachMutation(preconditions, func(field string) map[string]interface{} {
+		return map[string]interface{}{
+			"spec": map[string]interface{}{
+				field: []interface{}{
+					map[string]interface{}{
+						"name":            "{{ element.name }}",
+						"securityContext": securityContext,
+					},
+				},
+			},
+		}
+	})
+}
+
+// kyvernoForeachMutation returns a mutation that applies the patch returned
+// for the containers and initContainers fields to every element of these
+// lists that meets the preconditions.
+func kyvernoForeachMutation(preconditions []interface{}, patch func(field string) map[string]interface{}) map[string]interface{} {
+	foreach := make([]interface{}, 0, 2)
+	for _, field := range []string{"containers", "initContainers"} {
+		entry := map[string]interface{}{
+			"list":                fmt.Sprintf("request.object.spec.%s || `[]`", field),
+			"patchStrategicMerge": patch(field),
+		}
+		if len(preconditions) > 0 {
+			entry["preconditions"] = map[string]interface{}{"all": preconditions}
+		}
+		foreach = append(foreach, entry)
+	}
+	return map[string]interface{}{"foreach": foreach}
+}
+
+// seccompProfileMap converts the name of a seccomp profile of the seccomp
+// annotations into a seccompProfile.
+func seccompProfileMap(profile string) map[string]interface{} {
+	switch {
+	case profile == "runtime/default" || profile == "docker/default":
+		return map[string]interface{}{"type": string(v1.SeccompProfileTypeRuntimeDefault)}
+	case profile == "unconfined":
+		return map[string]interface{}{"type": string(v1.SeccompProfileTypeUnconfined)}
+	case strings.HasPrefix(profile, "localhost/"):
+		return map[string]interface{}{
+			"type":             string(v1.SeccompProfileTypeLocalhost),
+			"localhostProfile": strings.TrimPrefix(profile, "localhost/"),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func kyvernoRuleNames(t *testing.T, policy *unstructured.Unstructured) []string {
+	rules, _, err := unstructured.NestedSlice(policy.Object, "spec", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, rule := range rules {
+		names = append(names, rule.(map[string]interface{})["name"].(string))
+	}
+	return names
+}
+
+// deepCopyUnstructured returns a deep copy of the generated object. It fails
+// the test if the object holds values that aren't JSON compatible, e.g. int
+// or []string, since the API machinery panics when copying or encoding them.
+func deepCopyUnstructured(t *testing.T, obj *unstructured.Unstructured) (copied *unstructured.Unstructured) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("Expected %v %v to only hold JSON compatible values: %v", obj.GetKind(), obj.GetName(), r)
+		}
+	}()
+	copied = obj.DeepCopy()
+	if !reflect.DeepEqual(copied.Object, obj.Object) {
+		t.Fatalf("Expected the copy of %v %v to equal it", obj.GetKind(), obj.GetName())
+	}
+	return copied
+}
+
+func TestKyvernoValidatePolicy(t *testing.T) {
+	psp := newBaselinePSP()
+	psp.Spec.ReadOnlyRootFilesystem = true
+	psp.Spec.AllowedCSIDrivers = []v1beta1.AllowedCSIDriver{{Name: "csi.example.com"}}
+	psp.Spec.Volumes = append(psp.Spec.Volumes, "hostPath")
+	psp.Spec.AllowedHostPaths = []v1beta1.AllowedHostPath{
+		{PathPrefix: "/var/log", ReadOnly: true},
+		{PathPrefix: "/data"},
+	}
+
+	policy := KyvernoValidatePolicy(psp, KyvernoOptions{Namespaces: []string{"team-a"}})
+	if policy == nil {
+		t.Fatal("Expected a policy, but got nil")
+	}
+	policy = deepCopyUnstructured(t, policy)
+	if policy.GetName() != "psp-baseline" {
+		t.Errorf("Expected psp-baseline, but got %v", policy.GetName())
+	}
+	if level := policy.GetAnnotations()[PodSecurityStandardAnnotation]; level != "privileged" {
+		t.Errorf("Expected the PodSecurityPolicy to map to privileged because of hostPath, but got %v", level)
+	}
+
+	names := kyvernoRuleNames(t, policy)
+	for _, expected := range []string{"allowed-host-paths", "allowed-host-paths-read-only", "allowed-csi-drivers", "read-only-root-filesystem"} {
+		found := false
+		for _, name := range names {
+			found = found || name == expected
+		}
+		if !found {
+			t.Errorf("Expected rule %v, but got %v", expected, names)
+		}
+	}
+
+	namespaces, _, _ := unstructured.NestedSlice(policy.Object["spec"].(map[string]interface{})["rules"].([]interface{})[0].(map[string]interface{}),
+		"match", "any")
+	if len(namespaces) != 1 {
+		t.Errorf("Expected the rules to match pods in team-a, but got %v", namespaces)
+	}
+}
+
+func TestKyvernoValidatePolicyNoResidualControls(t *testing.T) {
+	if policy := KyvernoValidatePolicy(newPrivilegedPSP(), KyvernoOptions{}); policy != nil {
+		t.Errorf("Expected no policy, but got %v", policy)
+	}
+}
+
+func TestKebabCase(t *testing.T) {
+	for in, expected := range map[string]string{
+		"allowedCSIDrivers": "allowed-csi-drivers",
+		"seLinux":           "se-linux",
+		"privileged":        "privileged",
+	} {
+		if out := kebabCase(in); out != expected {
+			t.Errorf("Expected %v, but got %v", expected, out)
+		}
+	}
+}
+
+func TestKyvernoMutatePolicy(t *testing.T) {
+	psp := newRestrictedPSP()
+	psp.Annotations[SeccompDefaultProfileAnnotation] = "runtime/default"
+	psp.Annotations[AppArmorDefaultProfileAnnotation] = "runtime/default"
+	psp.Spec.DefaultAddCapabilities = []v1.Capability{"NET_BIND_SERVICE"}
+	psp.Spec.ReadOnlyRootFilesystem = true
+	psp.Spec.RunAsUser = v1beta1.RunAsUserStrategyOptions{
+		Rule:   v1beta1.RunAsUserStrategyMustRunAs,
+		Ranges: []v1beta1.IDRange{{Min: 1000, Max: 2000}},
+	}
+	psp.Spec.FSGroup = v1beta1.FSGroupStrategyOptions{
+		Rule:   v1beta1.FSGroupStrategyMustRunAs,
+		Ranges: []v1beta1.IDRange{{Min: 3000, Max: 4000}},
+	}
+
+	policy := KyvernoMutatePolicy(psp, KyvernoOptions{Namespaces: []string{"team-a"}})
+	if policy == nil {
+		t.Fatal("Expected a policy, but got nil")
+	}
+	policy = deepCopyUnstructured(t, policy)
+	if policy.GetName() != "psp-restricted-defaults" {
+		t.Errorf("Expected psp-restricted-defaults, but got %v", policy.GetName())
+	}
+	expected := []string{
+		"default-add-capability-net_bind_service", "required-drop-capability-all", "default-pod-security-context",
+		"default-run-as-user", "default-container-security-context", "default-apparmor-profile",
+	}
+	if names := kyvernoRuleNames(t, policy); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, but got %v", expected, names)
+	}
+
+	rules, _, _ := unstructured.NestedSlice(policy.Object, "spec", "rules")
+	podSecurityContext, _, _ := unstructured.NestedMap(rules[2].(map[string]interface{}),
+		"mutate", "patchStrategicMerge", "spec", "+(securityContext)")
+	if podSecurityContext["+(fsGroup)"] != int64(3000) {
+		t.Errorf("Expected fsGroup 3000, but got %v", podSecurityContext)
+	}
+	if !reflect.DeepEqual(podSecurityContext["+(seccompProfile)"], map[string]interface{}{"type": "RuntimeDefault"}) {
+		t.Errorf("Expected the RuntimeDefault seccomp profile, but got %v", podSecurityContext)
+	}
+}
+
+func TestKyvernoMutatePolicyNotMutating(t *testing.T) {
+	if policy := KyvernoMutatePolicy(newPrivilegedPSP(), KyvernoOptions{}); policy != nil {
+		t.Errorf("Expected no policy, but got %v", policy)
+	}
+}
+
+func TestKyvernoValidatePolicyEffectiveRunAsUser(t *testing.T) {
+	psp := newBaselinePSP()
+	psp.Spec.RunAsUser = v1beta1.RunAsUserStrategyOptions{
+		Rule:   v1beta1.RunAsUserStrategyMustRunAs,
+		Ranges: []v1beta1.IDRange{{Min: 1000, Max: 2000}},
+	}
+	policy := KyvernoValidatePolicy(psp, KyvernoOptions{})
+	if policy == nil {
+		t.Fatal("Expected a policy, but got nil")
+	}
+	rules, _, _ := unstructured.NestedSlice(policy.Object, "spec", "rules")
+	key := ""
+	for _, rule := range rules {
+		if rule.(map[string]interface{})["name"] == "run-as-user" {
+			foreach, _, _ := unstructured.NestedSlice(rule.(map[string]interface{}), "validate", "foreach")
+			conditions, _, _ := unstructured.NestedSlice(foreach[0].(map[string]interface{}), "deny", "conditions", "any")
+			key = conditions[0].(map[string]interface{})["key"].(string)
+		}
+	}
+	if key == "" {
+		t.Fatalf("Expected a run-as-user rule, but got %v", kyvernoRuleNames(t, policy))
+	}
+	// The container's runAsUser overrides the pod's, and JMESPath's || only
+	// falls back to the pod's if the container doesn't set one.
+	expected := "{{ [element.securityContext.runAsUser || request.object.spec.securityContext.runAsUser][] }}"
+	if key != expected {
+		t.Errorf("Expected key %q, but got %q", expected, key)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"k8s.io/api/policy/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Manifests are the objects relevant to the migration that were decoded from
+// YAML or JSON manifests.
+type Manifests struct {
+	PSPs                []v1beta1.PodSecurityPolicy
+	Roles               []rbacv1.Role
+	ClusterRoles        []rbacv1.ClusterRole
+	RoleBindings        []rbacv1.RoleBinding
+	ClusterRoleBindings []rbacv1.ClusterRoleBinding
+}
+
+// PSPAuthorizations returns the PSP authorizations granted by the RBAC
+// objects of the manifests.
+func (m *Manifests) PSPAuthorizations() *PSPAuthorizations {
+	return NewPSPAuthorizations(m.Roles, m.ClusterRoles, m.RoleBindings, m.ClusterRoleBindings)
+}
+
+// DecodePSPs decodes the PodSecurityPolicy objects of YAML or JSON
+// manifests, e.g. the output of `kubectl get psp -o yaml`. Lists and multiple
+// YAML documents are supported. Objects of other kinds are ignored.
+func DecodePSPs(r io.Reader) ([]v1beta1.PodSecurityPolicy, error) {
+	m, err := DecodeManifests(r)
+	if err != nil {
+		return nil, err
+	}
+	return m.PSPs, nil
+}
+
+// DecodeManifests decodes the PodSecurityPolicy and RBAC objects of YAML or
+// JSON manifests. Lists and multiple YAML documents are supported. Objects of
+// other kinds are ignored.
+func DecodeManifests(r io.Reader) (*Manifests, error) {
+	m := &Manifests{
+		PSPs:                make([]v1beta1.PodSecurityPolicy, 0),
+		Roles:               make([]rbacv1.Role, 0),
+		ClusterRoles:        make([]rbacv1.ClusterRole, 0),
+		RoleBindings:        make([]rbacv1.RoleBinding, 0),
+		ClusterRoleBindings: make([]rbacv1.ClusterRoleBinding, 0),
+	}
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); errors.Is(err, io.EOF) {
+			return m, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		if obj.Object == nil {
+			// empty YAML document
+			continue
+		}
+		objs := []unstructured.Unstructured{*obj}
+		if obj.IsList() {
+			list, err := obj.ToList()
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode list: %w", err)
+			}
+			objs = list.Items
+		}
+		for _, o := range objs {
+			if err := m.add(o); err != nil {
+				return nil, fmt.Errorf("failed to decode %s %s: %w", o.GetKind(), o.GetName(), err)
+			}
+		}
+	}
+}
+
+func (m *Manifests) add(obj unstructured.Unstructured) error {
+	from := func(into interface{}) error {
+		return runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, into)
+	}
+	switch obj.GetKind() {
+	case "PodSecurityPolicy":
+		var psp v1beta1.PodSecurityPolicy
+		if err := from(&psp); err != nil {
+			return err
+		}
+		m.PSPs = append(m.PSPs, psp)
+	case "Role":
+		var role rbacv1.Role
+		if err := from(&role); err != nil {
+			return err
+		}
+		m.Roles = append(m.Roles, role)
+	case "ClusterRole":
+		var role rbacv1.ClusterRole
+		if err := from(&role); err != nil {
+			return err
+		}
+		m.ClusterRoles = append(m.ClusterRoles, role)
+	case "RoleBinding":
+		var binding rbacv1.RoleBinding
+		if err := from(&binding); err != nil {
+			return err
+		}
+		m.RoleBindings = append(m.RoleBindings, binding)
+	case "ClusterRoleBinding":
+		var binding rbacv1.ClusterRoleBinding
+		if err := from(&binding); err != nil {
+			return err
+		}
+		m.ClusterRoleBindings = append(m.ClusterRoleBindings, binding)
+	}
+	return nil
+}
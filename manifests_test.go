@@ -0,0 +1,68 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDecodePSPsFromFixture(t *testing.T) {
+	f, err := os.Open("tests/psp-policy.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	psps, err := DecodePSPs(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(psps) != 1 {
+		t.Fatalf("Expected a single PodSecurityPolicy, but got %v", len(psps))
+	}
+	if psps[0].Name != "my-psp" || len(psps[0].Spec.DefaultAddCapabilities) != 1 {
+		t.Errorf("Unexpected PodSecurityPolicy %#v", psps[0])
+	}
+}
+
+func TestDecodePSPsFromList(t *testing.T) {
+	list := `
+apiVersion: v1
+kind: List
+items:
+- apiVersion: policy/v1beta1
+  kind: PodSecurityPolicy
+  metadata:
+    name: a
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: b
+- apiVersion: policy/v1beta1
+  kind: PodSecurityPolicy
+  metadata:
+    name: c
+`
+	psps, err := DecodePSPs(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(psps) != 2 || psps[0].Name != "a" || psps[1].Name != "c" {
+		t.Errorf("Expected PodSecurityPolicies a and c, but got %v", psps)
+	}
+}
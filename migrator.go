@@ -35,6 +35,10 @@ func init() {
 	}
 }
 
+// SuggestedPodSecurityStandard returns the most restrictive Pod Security
+// Standard the pod is allowed to run under. All containers are evaluated like
+// the PodSecurity admission plugin does, use ContainerExclusions to leave out
+// e.g. ephemeral containers first.
 func SuggestedPodSecurityStandard(pod *v1.Pod) (psaapi.Level, error) {
 	for _, apiLevel := range []psaapi.Level{psaapi.LevelRestricted, psaapi.LevelBaseline} {
 		result := policy.AggregateCheckResults(evaluator.EvaluatePod(
@@ -46,3 +50,20 @@ func SuggestedPodSecurityStandard(pod *v1.Pod) (psaapi.Level, error) {
 	}
 	return psaapi.LevelPrivileged, nil
 }
+
+// SuggestedNamespacePodSecurityStandard returns the most restrictive Pod
+// Security Standard that all the given pods, e.g. the pods of a single
+// namespace, are allowed to run under.
+func SuggestedNamespacePodSecurityStandard(pods []v1.Pod) (psaapi.Level, error) {
+	suggested := psaapi.LevelRestricted
+	for i := range pods {
+		level, err := SuggestedPodSecurityStandard(&pods[i])
+		if err != nil {
+			return "", err
+		}
+		if psaapi.CompareLevels(level, suggested) < 0 {
+			suggested = level
+		}
+	}
+	return suggested, nil
+}
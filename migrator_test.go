@@ -86,3 +86,38 @@ func TestSuggestPrivileged(t *testing.T) {
 		t.Errorf("Expected privileged, but got %v\n", level)
 	}
 }
+
+func TestSuggestNamespaceLeastRestrictive(t *testing.T) {
+	pods := []v1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "nginx"},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Name: "nginx", Image: "nginx"}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "nginx-privileged"},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{
+					{Name: "nginx", Image: "nginx", SecurityContext: &v1.SecurityContext{Privileged: newTrue()}},
+				},
+			},
+		},
+	}
+
+	level, err := SuggestedNamespacePodSecurityStandard(pods)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if level != "privileged" {
+		t.Errorf("Expected privileged, but got %v\n", level)
+	}
+
+	level, err = SuggestedNamespacePodSecurityStandard(pods[:1])
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if level != "baseline" {
+		t.Errorf("Expected baseline, but got %v\n", level)
+	}
+}
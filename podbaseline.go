@@ -0,0 +1,170 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+)
+
+// ErrNoBaseline is returned when checking whether a pod without a controller
+// is mutated, but the pod has neither a last-applied configuration nor
+// managed fields to reconstruct the submitted pod from.
+var ErrNoBaseline = errors.New("no baseline to compare the pod with, it has no controller, last-applied configuration or managed fields")
+
+// isBarePodMutated checks whether a pod without a controller is mutated. The
+// submitted pod is taken from the kubectl.kubernetes.io/last-applied-configuration
+// annotation. Otherwise the security context fields that aren't owned by any
+// field manager are reported, since mutating admission plugins such as PSP
+// change the pod after the managed fields are recorded.
+func isBarePodMutated(pod *v1.Pod, excluded []ExcludedContainer) (mutating bool, diff []string, err error) {
+	if applied, ok := pod.Annotations[v1.LastAppliedConfigAnnotation]; ok {
+		submitted := &v1.Pod{}
+		if err := json.Unmarshal([]byte(applied), submitted); err != nil {
+			return false, make([]string, 0), fmt.Errorf("failed to decode %s annotation: %w", v1.LastAppliedConfigAnnotation, err)
+		}
+		// the API server defaults the pod security context to an empty one
+		if submitted.Spec.SecurityContext == nil {
+			submitted.Spec.SecurityContext = &v1.PodSecurityContext{}
+		}
+		spec := &submitted.Spec
+		if len(excluded) > 0 {
+			spec = excludeNames(spec, excluded)
+		}
+		mutating, diff = diffPodSecurity(&submitted.ObjectMeta, spec, pod, "last-applied configuration")
+		return mutating, diff, nil
+	}
+	if len(pod.ManagedFields) > 0 {
+		diff, err = unmanagedSecurityFields(pod)
+		return len(diff) > 0, diff, err
+	}
+	return false, make([]string, 0), ErrNoBaseline
+}
+
+// unmanagedSecurityFields returns the security context fields and PSP
+// annotations of the pod that aren't owned by any field manager.
+func unmanagedSecurityFields(pod *v1.Pod) ([]string, error) {
+	managed := &fieldpath.Set{}
+	for _, entry := range pod.ManagedFields {
+		if entry.FieldsV1 == nil {
+			continue
+		}
+		set := &fieldpath.Set{}
+		if err := set.FromJSON(bytes.NewReader(entry.FieldsV1.Raw)); err != nil {
+			return nil, fmt.Errorf("failed to decode managed fields of %s: %w", entry.Manager, err)
+		}
+		managed = managed.Union(set)
+	}
+
+	diff := make([]string, 0)
+	check := func(prefix string, parts []interface{}, obj interface{}) error {
+		fields, err := leafFields(obj)
+		if err != nil {
+			return err
+		}
+		for _, field := range fields {
+			path := append(append([]interface{}{}, parts...), field...)
+			if !managed.Has(fieldpath.MakePathOrDie(path...)) {
+				diff = append(diff, fmt.Sprintf("%s: %s isn't set by any field manager", prefix, joinFields(field)))
+			}
+		}
+		return nil
+	}
+	containers := func(containerType string, name string, sc *v1.SecurityContext) error {
+		if sc == nil {
+			return nil
+		}
+		prefix := fmt.Sprintf("%s[%s]", containerType, name)
+		return check(prefix, []interface{}{"spec", containerType, fieldpath.KeyByFields("name", name), "securityContext"}, sc)
+	}
+	if sc := pod.Spec.SecurityContext; sc != nil {
+		if err := check("securityContext", []interface{}{"spec", "securityContext"}, sc); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range pod.Spec.Containers {
+		if err := containers("containers", c.Name, c.SecurityContext); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range pod.Spec.InitContainers {
+		if err := containers("initContainers", c.Name, c.SecurityContext); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		if err := containers("ephemeralContainers", c.Name, c.SecurityContext); err != nil {
+			return nil, err
+		}
+	}
+	annotations := GetPSPAnnotations(pod.Annotations)
+	names := make([]string, 0, len(annotations))
+	for name := range annotations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !managed.Has(fieldpath.MakePathOrDie("metadata", "annotations", name)) {
+			diff = append(diff, fmt.Sprintf("annotations: %s isn't set by any field manager", name))
+		}
+	}
+	return diff, nil
+}
+
+// leafFields returns the paths of the fields that are set in obj, in order.
+// Lists are leaves, since the lists of security contexts are atomic.
+func leafFields(obj interface{}) ([][]interface{}, error) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([][]interface{}, 0)
+	var walk func(path []interface{}, m map[string]interface{})
+	walk = func(path []interface{}, m map[string]interface{}) {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			field := append(append([]interface{}{}, path...), k)
+			if nested, ok := m[k].(map[string]interface{}); ok {
+				walk(field, nested)
+			} else {
+				fields = append(fields, field)
+			}
+		}
+	}
+	walk(nil, u)
+	return fields, nil
+}
+
+func joinFields(field []interface{}) string {
+	names := make([]string, 0, len(field))
+	for _, name := range field {
+		names = append(names, fmt.Sprint(name))
+	}
+	return strings.Join(names, ".")
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newBarePod returns a pod without a controller whose nginx container was
+// given a user by a PSP object.
+func newBarePod() *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "debug", Namespace: "default"},
+		Spec: v1.PodSpec{
+			SecurityContext: &v1.PodSecurityContext{},
+			Containers: []v1.Container{{
+				Name:            "nginx",
+				SecurityContext: &v1.SecurityContext{RunAsNonRoot: newTrue(), RunAsUser: newInt64(1000)},
+			}},
+		},
+	}
+}
+
+func TestBarePodWithoutBaseline(t *testing.T) {
+	checker := &MutationChecker{}
+	mutated, _, err := checker.IsPodBeingMutatedByPSP(newBarePod())
+	if !errors.Is(err, ErrNoBaseline) || mutated {
+		t.Errorf("Expected ErrNoBaseline, got %v, %v", mutated, err)
+	}
+}
+
+func TestBarePodLastAppliedConfiguration(t *testing.T) {
+	checker := &MutationChecker{}
+	pod := newBarePod()
+	pod.Annotations = map[string]string{
+		v1.LastAppliedConfigAnnotation: `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"debug"},` +
+			`"spec":{"containers":[{"name":"nginx","securityContext":{"runAsNonRoot":true}}]}}`,
+	}
+	mutated, diff, err := checker.IsPodBeingMutatedByPSP(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"containers[nginx]: RunAsUser: <nil pointer> != int64"}
+	if !mutated || !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected mutated with diff %v, got %v, %v", expected, mutated, diff)
+	}
+
+	pod.Spec.Containers[0].SecurityContext.RunAsUser = nil
+	if mutated, diff, err := checker.IsPodBeingMutatedByPSP(pod); err != nil || mutated {
+		t.Errorf("Expected the applied pod to not be mutated, got %v, %v, %v", mutated, diff, err)
+	}
+}
+
+func TestBarePodManagedFields(t *testing.T) {
+	checker := &MutationChecker{}
+	pod := newBarePod()
+	pod.ManagedFields = []metav1.ManagedFieldsEntry{{
+		Manager:   "kubectl-run",
+		Operation: metav1.ManagedFieldsOperationUpdate,
+		FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:containers":{"k:{\"name\":\"nginx\"}":{` +
+			`".":{},"f:name":{},"f:securityContext":{".":{},"f:runAsNonRoot":{}}}},"f:securityContext":{}}}`)},
+	}}
+	mutated, diff, err := checker.IsPodBeingMutatedByPSP(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"containers[nginx]: runAsUser isn't set by any field manager"}
+	if !mutated || !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected mutated with diff %v, got %v, %v", expected, mutated, diff)
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata"
+)
+
+// PodFilter excludes the pods that no longer matter from the suggestion of a
+// namespace level, so e.g. a single old debug pod doesn't force a namespace
+// to privileged.
+type PodFilter struct {
+	// IncludeFinished includes pods in the Succeeded or Failed phase, e.g.
+	// the pods of completed Jobs and evicted pods.
+	IncludeFinished bool
+	// MaxUnmanagedAge excludes pods without a controller that were created
+	// longer ago, e.g. forgotten debug pods. Zero includes them all.
+	MaxUnmanagedAge time.Duration
+	// IncludeOrphans includes pods whose controller no longer exists.
+	IncludeOrphans bool
+	// GetOwner looks up the controllers of pods to check whether they still
+	// exist. Orphans can't be detected if it's nil.
+	GetOwner OwnerGetter
+}
+
+// OwnerGetter looks up the owner of an object in the given namespace, and
+// returns a NotFound error if it doesn't exist.
+type OwnerGetter func(owner *metav1.OwnerReference, namespace string) error
+
+// MetadataOwnerGetter returns an OwnerGetter that looks up owners of any kind
+// with a metadata-only Get on the resource the RESTMapper maps their kind to.
+func MetadataOwnerGetter(client metadata.Interface, mapper meta.RESTMapper) OwnerGetter {
+	return func(owner *metav1.OwnerReference, namespace string) error {
+		gv, err := schema.ParseGroupVersion(owner.APIVersion)
+		if err != nil {
+			return err
+		}
+		mapping, err := mapper.RESTMapping(gv.WithKind(owner.Kind).GroupKind(), gv.Version)
+		if err != nil {
+			return err
+		}
+		if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+			namespace = ""
+		}
+		_, err = client.Resource(mapping.Resource).Namespace(namespace).Get(context.TODO(), owner.Name, metav1.GetOptions{})
+		return err
+	}
+}
+
+// ExcludedPod is a pod that was excluded by a PodFilter.
+type ExcludedPod struct {
+	Pod    *v1.Pod
+	Reason string
+}
+
+// Filter returns the pods that are included by the filter, and the excluded
+// pods with the reason. A controller is assumed to exist if it can't be
+// looked up for another reason than not being found, e.g. a kind that isn't
+// served by the cluster.
+func (f *PodFilter) Filter(pods []v1.Pod, now time.Time) (included []v1.Pod, excluded []ExcludedPod) {
+	included = make([]v1.Pod, 0, len(pods))
+	excluded = make([]ExcludedPod, 0)
+	for i := range pods {
+		if reason := f.exclusionReason(&pods[i], now); reason != "" {
+			excluded = append(excluded, ExcludedPod{Pod: &pods[i], Reason: reason})
+		} else {
+			included = append(included, pods[i])
+		}
+	}
+	return included, excluded
+}
+
+func (f *PodFilter) exclusionReason(pod *v1.Pod, now time.Time) string {
+	if !f.IncludeFinished {
+		switch pod.Status.Phase {
+		case v1.PodSucceeded, v1.PodFailed:
+			if pod.Status.Reason == "Evicted" {
+				return "evicted"
+			}
+			return fmt.Sprintf("phase %s", pod.Status.Phase)
+		}
+	}
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		if f.MaxUnmanagedAge > 0 && now.Sub(pod.CreationTimestamp.Time) > f.MaxUnmanagedAge {
+			return fmt.Sprintf("no controller and older than %v", f.MaxUnmanagedAge)
+		}
+		return ""
+	}
+	if !f.IncludeOrphans && f.GetOwner != nil {
+		if err := f.GetOwner(owner, pod.Namespace); apierrors.IsNotFound(err) {
+			return fmt.Sprintf("%s %s no longer exists", owner.Kind, owner.Name)
+		}
+	}
+	return ""
+}
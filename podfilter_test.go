@@ -0,0 +1,134 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	metadatafake "k8s.io/client-go/metadata/fake"
+)
+
+func TestPodFilter(t *testing.T) {
+	now := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	controller := true
+	pod := func(name string, phase v1.PodPhase, age time.Duration, owner string) v1.Pod {
+		p := v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "apps",
+				CreationTimestamp: metav1.NewTime(now.Add(-age)),
+			},
+			Status: v1.PodStatus{Phase: phase},
+		}
+		if owner != "" {
+			p.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: owner, Controller: &controller}}
+		}
+		return p
+	}
+	evicted := pod("evicted", v1.PodFailed, time.Hour, "web")
+	evicted.Status.Reason = "Evicted"
+	pods := []v1.Pod{
+		pod("web-1", v1.PodRunning, 100*24*time.Hour, "web"),
+		pod("job-1", v1.PodSucceeded, time.Hour, ""),
+		evicted,
+		pod("debug", v1.PodRunning, 30*24*time.Hour, ""),
+		pod("fresh", v1.PodRunning, time.Hour, ""),
+		pod("orphan", v1.PodRunning, time.Hour, "deleted"),
+		pod("unknown", v1.PodRunning, time.Hour, "forbidden"),
+	}
+	getOwner := func(owner *metav1.OwnerReference, namespace string) error {
+		switch owner.Name {
+		case "deleted":
+			return apierrors.NewNotFound(appsv1.Resource("replicasets"), owner.Name)
+		case "forbidden":
+			return fmt.Errorf("forbidden")
+		}
+		return nil
+	}
+
+	filter := &PodFilter{MaxUnmanagedAge: 7 * 24 * time.Hour, GetOwner: getOwner}
+	included, excluded := filter.Filter(pods, now)
+	names := make([]string, 0)
+	for _, p := range included {
+		names = append(names, p.Name)
+	}
+	if fmt.Sprint(names) != "[web-1 fresh unknown]" {
+		t.Errorf("Unexpected included pods %v", names)
+	}
+	reasons := make(map[string]string)
+	for _, e := range excluded {
+		reasons[e.Pod.Name] = e.Reason
+	}
+	expected := map[string]string{
+		"job-1":   "phase Succeeded",
+		"evicted": "evicted",
+		"debug":   "no controller and older than 168h0m0s",
+		"orphan":  "ReplicaSet deleted no longer exists",
+	}
+	if fmt.Sprint(reasons) != fmt.Sprint(expected) {
+		t.Errorf("Expected exclusions %v, got %v", expected, reasons)
+	}
+
+	filter = &PodFilter{IncludeFinished: true, IncludeOrphans: true, GetOwner: getOwner}
+	if included, excluded := filter.Filter(pods, now); len(included) != len(pods) || len(excluded) != 0 {
+		t.Errorf("Expected all pods to be included, got %d excluded", len(excluded))
+	}
+}
+
+func TestPodFilterOrphanedJobPod(t *testing.T) {
+	jobs := schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(jobs, meta.RESTScopeNamespace)
+	job := &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "apps"},
+	}
+	scheme := metadatafake.NewTestScheme()
+	if err := metav1.AddMetaToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	client := metadatafake.NewSimpleMetadataClient(scheme, job)
+
+	controller := true
+	pod := func(name, job string) v1.Pod {
+		return v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "apps",
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "batch/v1", Kind: "Job", Name: job, Controller: &controller},
+			},
+		}}
+	}
+	pods := []v1.Pod{pod("backup-1", "backup"), pod("migrate-1", "migrate")}
+
+	filter := &PodFilter{GetOwner: MetadataOwnerGetter(client, mapper)}
+	included, excluded := filter.Filter(pods, time.Now())
+	if len(included) != 1 || included[0].Name != "backup-1" {
+		t.Errorf("Expected only backup-1 to be included, got %v", included)
+	}
+	if len(excluded) != 1 || excluded[0].Pod.Name != "migrate-1" || excluded[0].Reason != "Job migrate no longer exists" {
+		t.Errorf("Expected migrate-1 to be excluded as an orphan, got %v", excluded)
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Permission is an API request on all namespaces that pspmigrator needs to be
+// allowed to make.
+type Permission struct {
+	Verb     string
+	Group    string
+	Resource string
+}
+
+// PermissionCheck is the result of reviewing a Permission.
+type PermissionCheck struct {
+	Permission
+	Allowed bool
+	// Reason is the explanation of the authorizer, if any.
+	Reason string
+}
+
+// workloadResources are the workloads whose pod templates are read, by API
+// group.
+var workloadResources = map[string][]string{
+	"":      {"replicationcontrollers"},
+	"apps":  {"deployments", "replicasets", "statefulsets", "daemonsets"},
+	"batch": {"jobs", "cronjobs"},
+}
+
+// RequiredPermissions returns the permissions needed to migrate namespaces:
+// reading pods, namespaces, workloads and, if the cluster still serves them,
+// PSP objects, and updating and patching namespaces unless it's a dry-run.
+func RequiredPermissions(dryRun, psp bool) []Permission {
+	permissions := make([]Permission, 0)
+	read := func(group, resource string) {
+		for _, verb := range []string{"get", "list"} {
+			permissions = append(permissions, Permission{Verb: verb, Group: group, Resource: resource})
+		}
+	}
+	read("", "pods")
+	read("", "namespaces")
+	if psp {
+		read("policy", "podsecuritypolicies")
+	}
+	for _, group := range []string{"", "apps", "batch"} {
+		for _, resource := range workloadResources[group] {
+			read(group, resource)
+		}
+	}
+	if !dryRun {
+		for _, verb := range []string{"update", "patch"} {
+			permissions = append(permissions, Permission{Verb: verb, Group: "", Resource: "namespaces"})
+		}
+	}
+	return permissions
+}
+
+// ProbePermissions returns the permissions needed to detect whether PSP
+// admission is enabled with a probe pod, see PSPAdmissionOptions.
+func ProbePermissions() []Permission {
+	return []Permission{
+		{Verb: "create", Group: "", Resource: "namespaces"},
+		{Verb: "delete", Group: "", Resource: "namespaces"},
+		{Verb: "get", Group: "", Resource: "serviceaccounts"},
+		{Verb: "create", Group: "", Resource: "pods"},
+	}
+}
+
+// CheckPermissions reviews whether the current user has the permissions,
+// using a SelfSubjectAccessReview for each of them.
+func CheckPermissions(ctx context.Context, clientset kubernetes.Interface, permissions []Permission) ([]PermissionCheck, error) {
+	checks := make([]PermissionCheck, 0, len(permissions))
+	for _, permission := range permissions {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Verb:     permission.Verb,
+					Group:    permission.Group,
+					Resource: permission.Resource,
+				},
+			},
+		}
+		result, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to review permission to %s %s: %w", permission.Verb, permission.Resource, err)
+		}
+		reason := result.Status.Reason
+		if reason == "" {
+			reason = result.Status.EvaluationError
+		}
+		checks = append(checks, PermissionCheck{Permission: permission, Allowed: result.Status.Allowed, Reason: reason})
+	}
+	return checks, nil
+}
+
+// ClusterRoleForPermissions returns a ClusterRole that grants the
+// permissions, with a rule per API group and resource.
+func ClusterRoleForPermissions(name string, permissions []Permission) *rbacv1.ClusterRole {
+	type groupResource struct{ group, resource string }
+	verbs := make(map[groupResource][]string)
+	keys := make([]groupResource, 0)
+	for _, permission := range permissions {
+		key := groupResource{permission.Group, permission.Resource}
+		if _, ok := verbs[key]; !ok {
+			keys = append(keys, key)
+		}
+		if !matches(verbs[key], permission.Verb) {
+			verbs[key] = append(verbs[key], permission.Verb)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		if keys[i].group != keys[j].group {
+			return keys[i].group < keys[j].group
+		}
+		return keys[i].resource < keys[j].resource
+	})
+	clusterRole := &rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+	}
+	for _, key := range keys {
+		clusterRole.Rules = append(clusterRole.Rules, rbacv1.PolicyRule{
+			APIGroups: []string{key.group},
+			Resources: []string{key.resource},
+			Verbs:     verbs[key],
+		})
+	}
+	return clusterRole
+}
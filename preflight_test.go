@@ -0,0 +1,90 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRequiredPermissions(t *testing.T) {
+	dryRun := RequiredPermissions(true, true)
+	apply := RequiredPermissions(false, true)
+	if len(apply) != len(dryRun)+2 {
+		t.Errorf("Expected update and patch on namespaces to be added without dry-run, got %v", apply[len(dryRun):])
+	}
+	for _, permission := range RequiredPermissions(true, false) {
+		if permission.Resource == "podsecuritypolicies" {
+			t.Errorf("Expected no permissions on PSP objects if the PSP API isn't served, got %v", permission)
+		}
+	}
+	for _, permission := range dryRun {
+		if permission.Verb != "get" && permission.Verb != "list" {
+			t.Errorf("Expected only read permissions in dry-run, got %v", permission)
+		}
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = review.Spec.ResourceAttributes.Resource == "pods"
+		if !review.Status.Allowed {
+			review.Status.Reason = "no RBAC policy matched"
+		}
+		return true, review, nil
+	})
+	permissions := []Permission{
+		{Verb: "list", Resource: "pods"},
+		{Verb: "get", Resource: "namespaces"},
+		{Verb: "list", Resource: "namespaces"},
+		{Verb: "list", Group: "policy", Resource: "podsecuritypolicies"},
+	}
+	checks, err := CheckPermissions(context.TODO(), clientset, permissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := make([]Permission, 0)
+	for _, check := range checks {
+		if !check.Allowed {
+			missing = append(missing, check.Permission)
+			if check.Reason != "no RBAC policy matched" {
+				t.Errorf("Expected the reason of the authorizer, got %q", check.Reason)
+			}
+		}
+	}
+	if !reflect.DeepEqual(missing, permissions[1:]) {
+		t.Errorf("Expected missing permissions %v, got %v", permissions[1:], missing)
+	}
+
+	clusterRole := ClusterRoleForPermissions("pspmigrator", missing)
+	expected := []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"get", "list"}},
+		{APIGroups: []string{"policy"}, Resources: []string{"podsecuritypolicies"}, Verbs: []string{"list"}},
+	}
+	if clusterRole.Kind != "ClusterRole" || !reflect.DeepEqual(clusterRole.Rules, expected) {
+		t.Errorf("Expected rules %v, got %v", expected, clusterRole.Rules)
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PSPAdmissionAnnotation is the annotation the PodSecurityPolicy admission
+// plugin adds to the pods it admits, with the name of the PSP object.
+const PSPAdmissionAnnotation = "kubernetes.io/psp"
+
+// PSPAdmissionStatus is whether the PodSecurityPolicy admission plugin is
+// enabled. PSP objects can exist while the plugin is off.
+type PSPAdmissionStatus string
+
+const (
+	PSPAdmissionEnabled  PSPAdmissionStatus = "enabled"
+	PSPAdmissionDisabled PSPAdmissionStatus = "disabled"
+	PSPAdmissionUnknown  PSPAdmissionStatus = "unknown"
+)
+
+// PSPAdmission is the inferred status of the PodSecurityPolicy admission
+// plugin, with the evidence it was inferred from.
+type PSPAdmission struct {
+	Status   PSPAdmissionStatus
+	Evidence []string
+}
+
+// String returns e.g. "PSP enabled: probe pod was admitted by PSP restricted".
+func (a *PSPAdmission) String() string {
+	return fmt.Sprintf("PSP %s: %s", a.Status, strings.Join(a.Evidence, "; "))
+}
+
+// PSPAdmissionOptions configures DetectPSPAdmission.
+type PSPAdmissionOptions struct {
+	// RecentPods is how long ago pods may have been created to count as
+	// evidence, older pods may have been admitted before the plugin was
+	// turned on or off.
+	RecentPods time.Duration
+	// Probe creates a temporary namespace to create a probe pod in with a
+	// server-side dry-run. It needs the ProbePermissions.
+	Probe bool
+	Now   time.Time
+}
+
+// DetectPSPAdmission infers whether the PodSecurityPolicy admission plugin is
+// enabled. The plugin is disabled if the PSP API isn't served. Otherwise the
+// admission of a probe pod, created with a server-side dry-run, decides. If
+// there's no probe, or its result is inconclusive, the plugin is enabled if
+// recently created pods have the kubernetes.io/psp annotation, and disabled
+// if none of them have it.
+func DetectPSPAdmission(ctx context.Context, clientset kubernetes.Interface, pods []v1.Pod, opts PSPAdmissionOptions) *PSPAdmission {
+	result := &PSPAdmission{Status: PSPAdmissionUnknown, Evidence: make([]string, 0)}
+	served, err := PSPAPIServed(clientset.Discovery())
+	if err != nil {
+		result.Evidence = append(result.Evidence, err.Error())
+	} else if !served {
+		result.Status = PSPAdmissionDisabled
+		result.Evidence = append(result.Evidence, "the PodSecurityPolicy API isn't served")
+		return result
+	}
+
+	if opts.Probe {
+		status, evidence := probePSPAdmission(ctx, clientset)
+		result.Evidence = append(result.Evidence, evidence...)
+		if status != PSPAdmissionUnknown {
+			result.Status = status
+			return result
+		}
+	}
+
+	recent, annotated := 0, 0
+	for i := range pods {
+		pod := &pods[i]
+		if _, mirror := pod.Annotations[v1.MirrorPodAnnotationKey]; mirror ||
+			opts.Now.Sub(pod.CreationTimestamp.Time) > opts.RecentPods {
+			continue
+		}
+		recent++
+		if _, ok := pod.Annotations[PSPAdmissionAnnotation]; ok {
+			annotated++
+		}
+	}
+	switch {
+	case recent == 0:
+		result.Evidence = append(result.Evidence, fmt.Sprintf("no pods were created in the last %v", opts.RecentPods))
+	case annotated > 0:
+		result.Status = PSPAdmissionEnabled
+		result.Evidence = append(result.Evidence, fmt.Sprintf("%d of %d pods created in the last %v have the %s annotation",
+			annotated, recent, opts.RecentPods, PSPAdmissionAnnotation))
+	default:
+		result.Status = PSPAdmissionDisabled
+		result.Evidence = append(result.Evidence, fmt.Sprintf("none of the %d pods created in the last %v have the %s annotation",
+			recent, opts.RecentPods, PSPAdmissionAnnotation))
+	}
+	return result
+}
+
+// probePSPAdmission creates a probe pod with a server-side dry-run in a
+// temporary namespace, so it's admitted by all admission plugins without
+// being persisted. The probe pod passes the restricted Pod Security Standard,
+// so PodSecurity admission doesn't deny it before the result is known. A
+// failure to delete the namespace again is added to the evidence.
+func probePSPAdmission(ctx context.Context, clientset kubernetes.Interface) (status PSPAdmissionStatus, evidence []string) {
+	namespace, err := clientset.CoreV1().Namespaces().Create(ctx, &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "pspmigrator-probe-"},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return PSPAdmissionUnknown, []string{fmt.Sprintf("failed to create a namespace for the probe pod: %v", err)}
+	}
+	defer func() {
+		if err := clientset.CoreV1().Namespaces().Delete(context.TODO(), namespace.Name, metav1.DeleteOptions{}); err != nil {
+			evidence = append(evidence, fmt.Sprintf("failed to delete the probe namespace %s, delete it manually: %v", namespace.Name, err))
+		}
+	}()
+
+	// pods are only admitted once the service account of the namespace exists
+	err = wait.PollImmediate(500*time.Millisecond, 30*time.Second, func() (bool, error) {
+		_, err := clientset.CoreV1().ServiceAccounts(namespace.Name).Get(ctx, "default", metav1.GetOptions{})
+		return err == nil, nil
+	})
+	if err != nil {
+		return PSPAdmissionUnknown, []string{"timed out waiting for the service account of the probe namespace"}
+	}
+
+	pod, err := clientset.CoreV1().Pods(namespace.Name).Create(ctx, probePod(), metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}})
+	if err != nil {
+		if strings.Contains(err.Error(), "PodSecurityPolicy") {
+			return PSPAdmissionEnabled, []string{fmt.Sprintf("probe pod was denied by PSP admission: %v", err)}
+		}
+		return PSPAdmissionUnknown, []string{fmt.Sprintf("failed to create the probe pod: %v", err)}
+	}
+	if psp, ok := pod.Annotations[PSPAdmissionAnnotation]; ok {
+		return PSPAdmissionEnabled, []string{fmt.Sprintf("probe pod was admitted by PSP %s", psp)}
+	}
+	return PSPAdmissionDisabled, []string{fmt.Sprintf("probe pod was admitted without the %s annotation", PSPAdmissionAnnotation)}
+}
+
+func probePod() *v1.Pod {
+	yes, no, nobody := true, false, int64(65534)
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pspmigrator-probe"},
+		Spec: v1.PodSpec{
+			AutomountServiceAccountToken: &no,
+			SecurityContext: &v1.PodSecurityContext{
+				RunAsNonRoot:   &yes,
+				RunAsUser:      &nobody,
+				SeccompProfile: &v1.SeccompProfile{Type: v1.SeccompProfileTypeRuntimeDefault},
+			},
+			Containers: []v1.Container{{
+				Name:  "probe",
+				Image: "k8s.gcr.io/pause:3.7",
+				SecurityContext: &v1.SecurityContext{
+					AllowPrivilegeEscalation: &no,
+					Capabilities:             &v1.Capabilities{Drop: []v1.Capability{"ALL"}},
+				},
+			}},
+		},
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var pspAPIResources = []*metav1.APIResourceList{
+	{GroupVersion: "policy/v1beta1", APIResources: []metav1.APIResource{{Name: "podsecuritypolicies"}}},
+}
+
+func newRecentPod(name string, age time.Duration, now time.Time, psp string) v1.Pod {
+	pod := v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:              name,
+		Namespace:         "default",
+		CreationTimestamp: metav1.NewTime(now.Add(-age)),
+	}}
+	if psp != "" {
+		pod.Annotations = map[string]string{PSPAdmissionAnnotation: psp}
+	}
+	return pod
+}
+
+// newProbeClientset returns a clientset that admits the dry-run probe pod
+// with the annotations, or denies it with the error.
+func newProbeClientset(annotations map[string]string, denied error) *fake.Clientset {
+	clientset := fake.NewSimpleClientset()
+	clientset.Resources = pspAPIResources
+	clientset.PrependReactor("create", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		namespace := action.(k8stesting.CreateAction).GetObject().(*v1.Namespace).DeepCopy()
+		namespace.Name = namespace.GenerateName + "abcde"
+		return true, namespace, clientset.Tracker().Add(namespace)
+	})
+	clientset.PrependReactor("get", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default"}}, nil
+	})
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if denied != nil {
+			return true, nil, denied
+		}
+		pod := action.(k8stesting.CreateAction).GetObject().(*v1.Pod).DeepCopy()
+		pod.Annotations = annotations
+		return true, pod, nil
+	})
+	return clientset
+}
+
+func TestDetectPSPAdmission(t *testing.T) {
+	now := time.Now()
+	recent := []v1.Pod{
+		newRecentPod("old", 48*time.Hour, now, ""),
+		newRecentPod("nginx", time.Hour, now, "restricted"),
+		newRecentPod("redis", time.Hour, now, ""),
+	}
+	unannotated := []v1.Pod{newRecentPod("redis", time.Hour, now, "")}
+	old := []v1.Pod{newRecentPod("old", 48*time.Hour, now, "restricted")}
+
+	notServed := fake.NewSimpleClientset()
+	notServed.Resources = []*metav1.APIResourceList{
+		{GroupVersion: "policy/v1", APIResources: []metav1.APIResource{{Name: "poddisruptionbudgets"}}},
+	}
+
+	cases := []struct {
+		Name      string
+		Clientset *fake.Clientset
+		Pods      []v1.Pod
+		Probe     bool
+		Expected  PSPAdmissionStatus
+		Evidence  []string
+	}{
+		{"API not served", notServed, recent, true, PSPAdmissionDisabled,
+			[]string{"the PodSecurityPolicy API isn't served"}},
+		{"probe pod admitted by PSP", newProbeClientset(map[string]string{PSPAdmissionAnnotation: "restricted"}, nil),
+			unannotated, true, PSPAdmissionEnabled,
+			[]string{"probe pod was admitted by PSP restricted"}},
+		{"probe pod denied by PSP",
+			newProbeClientset(nil, fmt.Errorf("pods \"pspmigrator-probe\" is forbidden: PodSecurityPolicy: unable to admit pod: []")),
+			nil, true, PSPAdmissionEnabled,
+			[]string{"probe pod was denied by PSP admission: pods \"pspmigrator-probe\" is forbidden: PodSecurityPolicy: unable to admit pod: []"}},
+		{"probe pod admitted without PSP", newProbeClientset(nil, nil), recent, true, PSPAdmissionDisabled,
+			[]string{"probe pod was admitted without the kubernetes.io/psp annotation"}},
+		{"probe pod inconclusive", newProbeClientset(nil, fmt.Errorf("quota exceeded")), recent, true, PSPAdmissionEnabled,
+			[]string{"failed to create the probe pod: quota exceeded",
+				"1 of 2 pods created in the last 24h0m0s have the kubernetes.io/psp annotation"}},
+		{"recent pods annotated", newProbeClientset(nil, nil), recent, false, PSPAdmissionEnabled,
+			[]string{"1 of 2 pods created in the last 24h0m0s have the kubernetes.io/psp annotation"}},
+		{"recent pods not annotated", newProbeClientset(nil, nil), unannotated, false, PSPAdmissionDisabled,
+			[]string{"none of the 1 pods created in the last 24h0m0s have the kubernetes.io/psp annotation"}},
+		{"no recent pods", newProbeClientset(nil, nil), old, false, PSPAdmissionUnknown,
+			[]string{"no pods were created in the last 24h0m0s"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			result := DetectPSPAdmission(context.TODO(), tc.Clientset, tc.Pods, PSPAdmissionOptions{
+				RecentPods: 24 * time.Hour,
+				Probe:      tc.Probe,
+				Now:        now,
+			})
+			if result.Status != tc.Expected {
+				t.Errorf("Expected status %v, got %v (%v)", tc.Expected, result.Status, result)
+			}
+			if fmt.Sprint(result.Evidence) != fmt.Sprint(tc.Evidence) {
+				t.Errorf("Expected evidence %q, got %q", tc.Evidence, result.Evidence)
+			}
+		})
+	}
+}
+
+func TestDetectPSPAdmissionDeletesProbeNamespace(t *testing.T) {
+	clientset := newProbeClientset(nil, nil)
+	DetectPSPAdmission(context.TODO(), clientset, nil, PSPAdmissionOptions{Probe: true, Now: time.Now()})
+	deleted := ""
+	for _, action := range clientset.Actions() {
+		if action.Matches("delete", "namespaces") {
+			deleted = action.(k8stesting.DeleteAction).GetName()
+		}
+	}
+	if deleted != "pspmigrator-probe-abcde" {
+		t.Errorf("Expected the probe namespace to be deleted, got %q", deleted)
+	}
+}
+
+func TestDetectPSPAdmissionReportsFailedProbeNamespaceDeletion(t *testing.T) {
+	clientset := newProbeClientset(nil, nil)
+	clientset.PrependReactor("delete", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("forbidden")
+	})
+	result := DetectPSPAdmission(context.TODO(), clientset, nil, PSPAdmissionOptions{Probe: true, Now: time.Now()})
+	expected := []string{
+		"probe pod was admitted without the kubernetes.io/psp annotation",
+		"failed to delete the probe namespace pspmigrator-probe-abcde, delete it manually: forbidden",
+	}
+	if result.Status != PSPAdmissionDisabled || fmt.Sprint(result.Evidence) != fmt.Sprint(expected) {
+		t.Errorf("Expected the failed deletion to be reported, got %v", result)
+	}
+}
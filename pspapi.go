@@ -0,0 +1,57 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"fmt"
+
+	"k8s.io/api/policy/v1beta1"
+	"k8s.io/client-go/discovery"
+)
+
+// PSPAPIServed returns whether the API server serves PodSecurityPolicy
+// objects, using API discovery. The policy/v1beta1 API they were served in
+// was removed in Kubernetes 1.25.
+func PSPAPIServed(client discovery.DiscoveryInterface) (bool, error) {
+	groups, err := client.ServerGroups()
+	if err != nil {
+		return false, fmt.Errorf("failed to discover the API groups: %w", err)
+	}
+	groupVersion := v1beta1.SchemeGroupVersion
+	served := false
+	for _, group := range groups.Groups {
+		if group.Name != groupVersion.Group {
+			continue
+		}
+		for _, version := range group.Versions {
+			served = served || version.Version == groupVersion.Version
+		}
+	}
+	if !served {
+		return false, nil
+	}
+	resources, err := client.ServerResourcesForGroupVersion(groupVersion.String())
+	if err != nil {
+		return false, fmt.Errorf("failed to discover the resources of %s: %w", groupVersion, err)
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == "podsecuritypolicies" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPSPAPIServed(t *testing.T) {
+	cases := []struct {
+		Name      string
+		Resources []*metav1.APIResourceList
+		Expected  bool
+	}{
+		{"Kubernetes 1.24", []*metav1.APIResourceList{
+			{GroupVersion: "policy/v1", APIResources: []metav1.APIResource{{Name: "poddisruptionbudgets"}}},
+			{GroupVersion: "policy/v1beta1", APIResources: []metav1.APIResource{
+				{Name: "poddisruptionbudgets"}, {Name: "podsecuritypolicies"}}},
+		}, true},
+		{"Kubernetes 1.25", []*metav1.APIResourceList{
+			{GroupVersion: "policy/v1", APIResources: []metav1.APIResource{{Name: "poddisruptionbudgets"}}},
+		}, false},
+		{"PSP API disabled", []*metav1.APIResourceList{
+			{GroupVersion: "policy/v1beta1", APIResources: []metav1.APIResource{{Name: "poddisruptionbudgets"}}},
+		}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			discovery := fake.NewSimpleClientset().Discovery().(*fakediscovery.FakeDiscovery)
+			discovery.Resources = tc.Resources
+			served, err := PSPAPIServed(discovery)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if served != tc.Expected {
+				t.Errorf("Expected served to be %v, got %v", tc.Expected, served)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/api/policy/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// PSPCleanup is the plan to remove the PSP objects and the RBAC rules that
+// refer to them once PSP admission is no longer needed.
+type PSPCleanup struct {
+	// Delete are the PSP objects, the Roles and ClusterRoles that only have
+	// PSP rules and the bindings of these roles. Only their kind, name and
+	// namespace are set, which is enough for `kubectl delete -f`.
+	Delete []runtime.Object
+	// Replace are the Roles and ClusterRoles whose other rules are kept,
+	// with the PSP rules stripped. They keep their resourceVersion, so
+	// `kubectl replace -f` fails if they changed in the meantime.
+	Replace []runtime.Object
+}
+
+// PlanPSPCleanup returns the plan to remove the PSP objects and the RBAC
+// rules of the manifests that refer to podsecuritypolicies. Rules that refer
+// to other resources as well only lose the podsecuritypolicies resource.
+// Aggregated ClusterRoles are left alone since their rules are aggregated
+// from the ClusterRoles that are cleaned up.
+func PlanPSPCleanup(m *Manifests) *PSPCleanup {
+	cleanup := &PSPCleanup{Delete: make([]runtime.Object, 0), Replace: make([]runtime.Object, 0)}
+	deleteObj := func(gv, kind, name, namespace string) {
+		cleanup.Delete = append(cleanup.Delete, &metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: gv, Kind: kind},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		})
+	}
+	rbacGV := rbacv1.SchemeGroupVersion.String()
+
+	for _, psp := range m.PSPs {
+		deleteObj(v1beta1.SchemeGroupVersion.String(), "PodSecurityPolicy", psp.Name, "")
+	}
+
+	deletedClusterRoles := make(map[string]bool)
+	for _, role := range m.ClusterRoles {
+		if role.AggregationRule != nil {
+			continue
+		}
+		rules, stripped := StripPSPRules(role.Rules)
+		switch {
+		case !stripped:
+		case len(rules) == 0:
+			deletedClusterRoles[role.Name] = true
+			deleteObj(rbacGV, "ClusterRole", role.Name, "")
+		default:
+			role := role.DeepCopy()
+			role.TypeMeta = metav1.TypeMeta{APIVersion: rbacGV, Kind: "ClusterRole"}
+			role.ManagedFields = nil
+			role.Rules = rules
+			cleanup.Replace = append(cleanup.Replace, role)
+		}
+	}
+	for _, binding := range m.ClusterRoleBindings {
+		if deletedClusterRoles[binding.RoleRef.Name] {
+			deleteObj(rbacGV, "ClusterRoleBinding", binding.Name, "")
+		}
+	}
+
+	deletedRoles := make(map[string]bool)
+	for _, role := range m.Roles {
+		rules, stripped := StripPSPRules(role.Rules)
+		switch {
+		case !stripped:
+		case len(rules) == 0:
+			deletedRoles[role.Namespace+"/"+role.Name] = true
+			deleteObj(rbacGV, "Role", role.Name, role.Namespace)
+		default:
+			role := role.DeepCopy()
+			role.TypeMeta = metav1.TypeMeta{APIVersion: rbacGV, Kind: "Role"}
+			role.ManagedFields = nil
+			role.Rules = rules
+			cleanup.Replace = append(cleanup.Replace, role)
+		}
+	}
+	for _, binding := range m.RoleBindings {
+		if (binding.RoleRef.Kind == "ClusterRole" && deletedClusterRoles[binding.RoleRef.Name]) ||
+			(binding.RoleRef.Kind == "Role" && deletedRoles[binding.Namespace+"/"+binding.RoleRef.Name]) {
+			deleteObj(rbacGV, "RoleBinding", binding.Name, binding.Namespace)
+		}
+	}
+	return cleanup
+}
+
+// StripPSPRules returns the rules without the podsecuritypolicies resource
+// of the policy and extensions API groups, and whether any rule referred to
+// it. Rules that are left without resources are dropped. Rules with the *
+// resource are kept as is, since they don't refer to PSP objects in
+// particular.
+func StripPSPRules(rules []rbacv1.PolicyRule) ([]rbacv1.PolicyRule, bool) {
+	result := make([]rbacv1.PolicyRule, 0, len(rules))
+	stripped := false
+	for _, rule := range rules {
+		if !isPSPRule(rule) {
+			result = append(result, rule)
+			continue
+		}
+		stripped = true
+		resources := make([]string, 0, len(rule.Resources))
+		for _, resource := range rule.Resources {
+			if resource != "podsecuritypolicies" {
+				resources = append(resources, resource)
+			}
+		}
+		if len(resources) > 0 {
+			rule = *rule.DeepCopy()
+			rule.Resources = resources
+			result = append(result, rule)
+		}
+	}
+	return result, stripped
+}
+
+func isPSPRule(rule rbacv1.PolicyRule) bool {
+	if !matches(rule.APIGroups, "policy") && !matches(rule.APIGroups, "extensions") {
+		return false
+	}
+	for _, resource := range rule.Resources {
+		if resource == "podsecuritypolicies" {
+			return true
+		}
+	}
+	return false
+}
+
+// RecentlyAdmittedPods returns the pods created in the last since that were
+// admitted by a PSP object, according to their kubernetes.io/psp annotation.
+func RecentlyAdmittedPods(pods []v1.Pod, since time.Duration, now time.Time) []v1.Pod {
+	admitted := make([]v1.Pod, 0)
+	for _, pod := range pods {
+		if _, ok := pod.Annotations[PSPAdmissionAnnotation]; ok && now.Sub(pod.CreationTimestamp.Time) <= since {
+			admitted = append(admitted, pod)
+		}
+	}
+	return admitted
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/api/policy/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStripPSPRules(t *testing.T) {
+	rules := []rbacv1.PolicyRule{
+		{APIGroups: []string{"policy"}, Resources: []string{"podsecuritypolicies"}, Verbs: []string{"use"}},
+		{APIGroups: []string{"policy", "extensions"}, Resources: []string{"poddisruptionbudgets", "podsecuritypolicies"},
+			Verbs: []string{"get", "list"}},
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}},
+		{APIGroups: []string{"policy"}, Resources: []string{"*"}, Verbs: []string{"*"}},
+	}
+	stripped, ok := StripPSPRules(rules)
+	if !ok {
+		t.Fatal("Expected PSP rules to be stripped")
+	}
+	expected := []rbacv1.PolicyRule{
+		{APIGroups: []string{"policy", "extensions"}, Resources: []string{"poddisruptionbudgets"}, Verbs: []string{"get", "list"}},
+		rules[2],
+		rules[3],
+	}
+	if !reflect.DeepEqual(stripped, expected) {
+		t.Errorf("Expected rules %v, got %v", expected, stripped)
+	}
+	if rules[1].Resources[1] != "podsecuritypolicies" {
+		t.Errorf("Expected the rules to be left unmodified, got %v", rules[1])
+	}
+	if _, ok := StripPSPRules(rules[2:]); ok {
+		t.Errorf("Expected rules without PSP rules not to be stripped")
+	}
+}
+
+func TestPlanPSPCleanup(t *testing.T) {
+	useRule := rbacv1.PolicyRule{
+		APIGroups: []string{"policy"}, Resources: []string{"podsecuritypolicies"}, Verbs: []string{"use"},
+	}
+	podsRule := rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}
+	m := &Manifests{
+		PSPs: []v1beta1.PodSecurityPolicy{*newRestrictedPSP()},
+		ClusterRoles: []rbacv1.ClusterRole{
+			{ObjectMeta: metav1.ObjectMeta{Name: "psp:restricted"}, Rules: []rbacv1.PolicyRule{useRule}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "edit", ResourceVersion: "42"}, Rules: []rbacv1.PolicyRule{podsRule, useRule}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "view"}, Rules: []rbacv1.PolicyRule{podsRule}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "aggregated"}, Rules: []rbacv1.PolicyRule{useRule},
+				AggregationRule: &rbacv1.AggregationRule{}},
+		},
+		ClusterRoleBindings: []rbacv1.ClusterRoleBinding{
+			{ObjectMeta: metav1.ObjectMeta{Name: "psp:restricted"}, RoleRef: rbacv1.RoleRef{Kind: "ClusterRole", Name: "psp:restricted"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "edit"}, RoleRef: rbacv1.RoleRef{Kind: "ClusterRole", Name: "edit"}},
+		},
+		Roles: []rbacv1.Role{
+			{ObjectMeta: metav1.ObjectMeta{Name: "psp", Namespace: "team-a"}, Rules: []rbacv1.PolicyRule{useRule}},
+		},
+		RoleBindings: []rbacv1.RoleBinding{
+			{ObjectMeta: metav1.ObjectMeta{Name: "psp", Namespace: "team-a"}, RoleRef: rbacv1.RoleRef{Kind: "Role", Name: "psp"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "psp", Namespace: "team-b"}, RoleRef: rbacv1.RoleRef{Kind: "Role", Name: "psp"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "restricted", Namespace: "team-b"},
+				RoleRef: rbacv1.RoleRef{Kind: "ClusterRole", Name: "psp:restricted"}},
+		},
+	}
+	cleanup := PlanPSPCleanup(m)
+
+	deleted := make([]string, 0)
+	for _, obj := range cleanup.Delete {
+		meta := obj.(*metav1.PartialObjectMetadata)
+		deleted = append(deleted, fmt.Sprintf("%s %s/%s", meta.Kind, meta.Namespace, meta.Name))
+	}
+	expected := []string{
+		"PodSecurityPolicy /restricted",
+		"ClusterRole /psp:restricted",
+		"ClusterRoleBinding /psp:restricted",
+		"Role team-a/psp",
+		"RoleBinding team-a/psp",
+		"RoleBinding team-b/restricted",
+	}
+	if !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("Expected deletions %v, got %v", expected, deleted)
+	}
+
+	if len(cleanup.Replace) != 1 {
+		t.Fatalf("Expected 1 replaced role, got %v", cleanup.Replace)
+	}
+	edit := cleanup.Replace[0].(*rbacv1.ClusterRole)
+	if edit.Name != "edit" || edit.Kind != "ClusterRole" || edit.ResourceVersion != "42" ||
+		!reflect.DeepEqual(edit.Rules, []rbacv1.PolicyRule{podsRule}) {
+		t.Errorf("Expected edit to only keep the pods rule, got %v", edit)
+	}
+}
+
+func TestRecentlyAdmittedPods(t *testing.T) {
+	now := time.Now()
+	pods := []v1.Pod{
+		newRecentPod("nginx", time.Hour, now, "restricted"),
+		newRecentPod("old", 48*time.Hour, now, "restricted"),
+		newRecentPod("redis", time.Hour, now, ""),
+	}
+	admitted := RecentlyAdmittedPods(pods, 24*time.Hour, now)
+	if len(admitted) != 1 || admitted[0].Name != "nginx" {
+		t.Errorf("Expected only nginx to be recently admitted, got %v", admitted)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/api/policy/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	psaapi "k8s.io/pod-security-admission/api"
+)
+
+// PSPUsage is the inventory of a PodSecurityPolicy object: what it does to
+// pods and who uses it.
+type PSPUsage struct {
+	Name string
+	// Level is the most restrictive Pod Security Standard the PSP object
+	// maps to.
+	Level               psaapi.Level
+	MutatingFields      []string
+	MutatingAnnotations []string
+	// Pods is the number of running pods admitted by the PSP object, and
+	// Namespaces the namespaces of these pods, sorted by name.
+	Pods       int
+	Namespaces []string
+	// Subjects are the subjects RBAC authorizes to use the PSP object,
+	// formatted by SubjectString, without duplicates.
+	Subjects []string
+}
+
+// Unused returns whether no running pod was admitted by the PSP object. It
+// can still be authorized for subjects, but deleting it doesn't affect any
+// running pod.
+func (u *PSPUsage) Unused() bool {
+	return u.Pods == 0
+}
+
+// PSPInventory returns the usage of every PSP object. The pods admitted by a
+// PSP object are found by their kubernetes.io/psp annotation, pods in the
+// Succeeded or Failed phase aren't counted.
+func PSPInventory(psps []v1beta1.PodSecurityPolicy, pods []v1.Pod, authorizations *PSPAuthorizations) []PSPUsage {
+	podCounts := make(map[string]int)
+	namespaces := make(map[string]sets.String)
+	for _, pod := range pods {
+		psp, ok := pod.Annotations[PSPAdmissionAnnotation]
+		if !ok || pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		podCounts[psp]++
+		if namespaces[psp] == nil {
+			namespaces[psp] = sets.NewString()
+		}
+		namespaces[psp].Insert(pod.Namespace)
+	}
+
+	inventory := make([]PSPUsage, 0, len(psps))
+	for i := range psps {
+		psp := &psps[i]
+		_, fields, annotations := IsPSPMutating(psp)
+		usage := PSPUsage{
+			Name:                psp.Name,
+			Level:               PSPPodSecurityStandard(psp),
+			MutatingFields:      fields,
+			MutatingAnnotations: annotations,
+			Pods:                podCounts[psp.Name],
+			Namespaces:          namespaces[psp.Name].List(),
+			Subjects:            make([]string, 0),
+		}
+		seen := sets.NewString()
+		for _, subject := range authorizations.Subjects(psp.Name) {
+			if s := SubjectString(subject); !seen.Has(s) {
+				seen.Insert(s)
+				usage.Subjects = append(usage.Subjects, s)
+			}
+		}
+		inventory = append(inventory, usage)
+	}
+	return inventory
+}
+
+// SubjectString returns e.g. "ServiceAccount default/nginx", "User alice" or
+// "Group system:authenticated".
+func SubjectString(subject rbacv1.Subject) string {
+	if subject.Namespace != "" {
+		return fmt.Sprintf("%s %s/%s", subject.Kind, subject.Namespace, subject.Name)
+	}
+	return fmt.Sprintf("%s %s", subject.Kind, subject.Name)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/api/policy/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPSPInventory(t *testing.T) {
+	psps := []v1beta1.PodSecurityPolicy{*newRestrictedPSP(), *newPrivilegedPSP()}
+	admittedPod := func(name, namespace, psp string, phase v1.PodPhase) v1.Pod {
+		return v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace,
+				Annotations: map[string]string{PSPAdmissionAnnotation: psp}},
+			Status: v1.PodStatus{Phase: phase},
+		}
+	}
+	pods := []v1.Pod{
+		admittedPod("nginx", "team-b", "restricted", v1.PodRunning),
+		admittedPod("redis", "team-a", "restricted", v1.PodRunning),
+		admittedPod("web", "team-a", "restricted", v1.PodPending),
+		admittedPod("job", "team-c", "restricted", v1.PodSucceeded),
+		admittedPod("debug", "kube-system", "privileged", v1.PodFailed),
+	}
+	clusterRoles := []rbacv1.ClusterRole{{
+		ObjectMeta: metav1.ObjectMeta{Name: "use-all"},
+		Rules: []rbacv1.PolicyRule{{
+			APIGroups: []string{"policy"}, Resources: []string{"podsecuritypolicies"}, Verbs: []string{"use"},
+		}},
+	}}
+	clusterRoleBindings := []rbacv1.ClusterRoleBinding{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "authenticated"},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "use-all"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: "system:authenticated"}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "authenticated-again"},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "use-all"},
+			Subjects: []rbacv1.Subject{
+				{Kind: rbacv1.GroupKind, Name: "system:authenticated"},
+				{Kind: rbacv1.ServiceAccountKind, Name: "daemon", Namespace: "kube-system"},
+			},
+		},
+	}
+	authorizations := NewPSPAuthorizations(nil, clusterRoles, nil, clusterRoleBindings)
+
+	inventory := PSPInventory(psps, pods, authorizations)
+	if len(inventory) != 2 {
+		t.Fatalf("Expected 2 PSP objects, got %v", inventory)
+	}
+	restricted, privileged := inventory[0], inventory[1]
+	if restricted.Name != "restricted" || restricted.Level != "restricted" {
+		t.Errorf("Expected restricted to map to restricted, got %v %v", restricted.Name, restricted.Level)
+	}
+	if !reflect.DeepEqual(restricted.MutatingFields, []string{"RequiredDropCapabilities", "SELinux", "RunAsUser", "AllowPrivilegeEscalation"}) {
+		t.Errorf("Unexpected mutating fields of restricted: %v", restricted.MutatingFields)
+	}
+	if restricted.Pods != 3 || !reflect.DeepEqual(restricted.Namespaces, []string{"team-a", "team-b"}) || restricted.Unused() {
+		t.Errorf("Expected 3 pods in team-a and team-b admitted by restricted, got %v in %v", restricted.Pods, restricted.Namespaces)
+	}
+	expectedSubjects := []string{"Group system:authenticated", "ServiceAccount kube-system/daemon"}
+	if !reflect.DeepEqual(restricted.Subjects, expectedSubjects) {
+		t.Errorf("Expected subjects %v, got %v", expectedSubjects, restricted.Subjects)
+	}
+	if privileged.Level != "privileged" || len(privileged.MutatingFields) != 0 {
+		t.Errorf("Expected privileged to map to privileged without mutating fields, got %v %v",
+			privileged.Level, privileged.MutatingFields)
+	}
+	if privileged.Pods != 0 || len(privileged.Namespaces) != 0 || !privileged.Unused() {
+		t.Errorf("Expected privileged to be unused, got %v pods in %v", privileged.Pods, privileged.Namespaces)
+	}
+}
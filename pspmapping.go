@@ -0,0 +1,358 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	psaapi "k8s.io/pod-security-admission/api"
+)
+
+// The mapping of PodSecurityPolicy fields to Pod Security Standards follows
+// https://kubernetes.io/docs/reference/access-authn-authz/psp-to-pod-security-standards/
+
+const (
+	SeccompAllowedProfilesAnnotation  = "seccomp.security.alpha.kubernetes.io/allowedProfileNames"
+	SeccompDefaultProfileAnnotation   = "seccomp.security.alpha.kubernetes.io/defaultProfileName"
+	AppArmorAllowedProfilesAnnotation = v1.AppArmorBetaAllowedProfilesAnnotationKey
+	AppArmorDefaultProfileAnnotation  = v1.AppArmorBetaDefaultProfileAnnotationKey
+)
+
+var (
+	// BaselineCapabilities are the capabilities that may be added under the
+	// baseline Pod Security Standard.
+	BaselineCapabilities = []string{
+		"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD",
+		"NET_BIND_SERVICE", "SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT",
+	}
+	// RestrictedCapabilities are the capabilities that may be added under
+	// the restricted Pod Security Standard.
+	RestrictedCapabilities = []string{"NET_BIND_SERVICE"}
+	// RestrictedVolumes are the volume types allowed under the restricted
+	// Pod Security Standard.
+	RestrictedVolumes = []string{
+		"configMap", "csi", "downwardAPI", "emptyDir", "ephemeral",
+		"persistentVolumeClaim", "projected", "secret",
+	}
+	// SafeSysctls are the sysctls allowed without being listed in
+	// allowedUnsafeSysctls.
+	SafeSysctls = []string{
+		"kernel.shm_rmid_forced", "net.ipv4.ip_local_port_range", "net.ipv4.tcp_syncookies",
+		"net.ipv4.ping_group_range", "net.ipv4.ip_unprivileged_port_start",
+	}
+	// AllVolumes are all the volume types a PodSecurityPolicy can allow.
+	AllVolumes = []string{
+		"azureFile", "flocker", "flexVolume", "hostPath", "emptyDir", "gcePersistentDisk",
+		"awsElasticBlockStore", "gitRepo", "secret", "nfs", "iscsi", "glusterfs",
+		"persistentVolumeClaim", "rbd", "cinder", "cephFS", "downwardAPI", "fc", "configMap",
+		"vsphereVolume", "quobyte", "azureDisk", "photonPersistentDisk", "storageos",
+		"projected", "portworxVolume", "scaleIO", "csi", "ephemeral",
+	}
+	baselineSELinuxTypes = sets.NewString("", "container_t", "container_init_t", "container_kvm_t")
+)
+
+// PSPControl is a restriction a PodSecurityPolicy can place on pods. It's
+// named after the PodSecurityPolicy field it comes from.
+type PSPControl string
+
+const (
+	ControlPrivileged               PSPControl = "privileged"
+	ControlHostNamespaces           PSPControl = "hostNamespaces"
+	ControlHostPorts                PSPControl = "hostPorts"
+	ControlCapabilities             PSPControl = "allowedCapabilities"
+	ControlRequiredDropCapabilities PSPControl = "requiredDropCapabilities"
+	ControlVolumes                  PSPControl = "volumes"
+	ControlHostPaths                PSPControl = "allowedHostPaths"
+	ControlFlexVolumes              PSPControl = "allowedFlexVolumes"
+	ControlCSIDrivers               PSPControl = "allowedCSIDrivers"
+	ControlReadOnlyRootFilesystem   PSPControl = "readOnlyRootFilesystem"
+	ControlRunAsUser                PSPControl = "runAsUser"
+	ControlRunAsGroup               PSPControl = "runAsGroup"
+	ControlSupplementalGroups       PSPControl = "supplementalGroups"
+	ControlFSGroup                  PSPControl = "fsGroup"
+	ControlAllowPrivilegeEscalation PSPControl = "allowPrivilegeEscalation"
+	ControlSELinux                  PSPControl = "seLinux"
+	ControlProcMount                PSPControl = "allowedProcMountTypes"
+	ControlSysctls                  PSPControl = "sysctls"
+	ControlSeccomp                  PSPControl = "seccomp"
+	ControlAppArmor                 PSPControl = "appArmor"
+)
+
+// PSPPodSecurityStandard returns the most restrictive Pod Security Standard
+// that allows every pod the PodSecurityPolicy allows. Enforcing this level
+// instead of the PodSecurityPolicy doesn't block any pods that are currently
+// allowed.
+func PSPPodSecurityStandard(psp *v1beta1.PodSecurityPolicy) psaapi.Level {
+	if !pspIsBaseline(psp) {
+		return psaapi.LevelPrivileged
+	}
+	if !pspIsRestricted(psp) {
+		return psaapi.LevelBaseline
+	}
+	return psaapi.LevelRestricted
+}
+
+func pspIsBaseline(psp *v1beta1.PodSecurityPolicy) bool {
+	spec := &psp.Spec
+	if spec.Privileged || spec.HostNetwork || spec.HostPID || spec.HostIPC || len(spec.HostPorts) > 0 {
+		return false
+	}
+	if !sets.NewString(BaselineCapabilities...).IsSuperset(pspAllowedCapabilities(psp)) {
+		return false
+	}
+	volumes := pspVolumes(psp)
+	if volumes.Has(string(v1beta1.All)) || volumes.Has(string(v1beta1.HostPath)) {
+		return false
+	}
+	if spec.SELinux.Rule != v1beta1.SELinuxStrategyMustRunAs {
+		return false
+	}
+	if opts := spec.SELinux.SELinuxOptions; opts != nil {
+		if opts.User != "" || opts.Role != "" || !baselineSELinuxTypes.Has(opts.Type) {
+			return false
+		}
+	}
+	for _, procMount := range spec.AllowedProcMountTypes {
+		if procMount != v1.DefaultProcMount {
+			return false
+		}
+	}
+	if len(spec.AllowedUnsafeSysctls) > 0 {
+		return false
+	}
+	if profiles, ok := pspProfiles(psp, SeccompAllowedProfilesAnnotation); ok {
+		for _, profile := range profiles {
+			if profile != "" && !isRuntimeDefaultOrLocalhost(profile) {
+				return false
+			}
+		}
+	}
+	// Without the annotation a PodSecurityPolicy allows any AppArmor
+	// profile, including unconfined.
+	profiles, ok := pspProfiles(psp, AppArmorAllowedProfilesAnnotation)
+	if !ok {
+		return false
+	}
+	for _, profile := range profiles {
+		if profile != "" && !isRuntimeDefaultOrLocalhost(profile) {
+			return false
+		}
+	}
+	return true
+}
+
+func pspIsRestricted(psp *v1beta1.PodSecurityPolicy) bool {
+	spec := &psp.Spec
+	if !sets.NewString(RestrictedVolumes...).IsSuperset(pspVolumes(psp)) {
+		return false
+	}
+	if spec.AllowPrivilegeEscalation == nil || *spec.AllowPrivilegeEscalation {
+		return false
+	}
+	switch spec.RunAsUser.Rule {
+	case v1beta1.RunAsUserStrategyMustRunAsNonRoot:
+	case v1beta1.RunAsUserStrategyMustRunAs:
+		for _, r := range spec.RunAsUser.Ranges {
+			if r.Min <= 0 {
+				return false
+			}
+		}
+	default:
+		return false
+	}
+	if !pspDropsAllCapabilities(psp) {
+		return false
+	}
+	if !sets.NewString(RestrictedCapabilities...).IsSuperset(pspAllowedCapabilities(psp)) {
+		return false
+	}
+	profiles, ok := pspProfiles(psp, SeccompAllowedProfilesAnnotation)
+	if !ok {
+		return false
+	}
+	for _, profile := range profiles {
+		if !isRuntimeDefaultOrLocalhost(profile) {
+			return false
+		}
+	}
+	return true
+}
+
+// ResidualPSPControls returns the Pod Security Standard the PodSecurityPolicy
+// maps to, see PSPPodSecurityStandard, and the controls of the
+// PodSecurityPolicy that are more restrictive than that Pod Security
+// Standard. These controls are lost when only the Pod Security Standard is
+// enforced, and need another policy engine to be kept.
+func ResidualPSPControls(psp *v1beta1.PodSecurityPolicy) (psaapi.Level, []PSPControl) {
+	level := PSPPodSecurityStandard(psp)
+	atLeast := func(l psaapi.Level) bool { return psaapi.CompareLevels(level, l) >= 0 }
+	spec := &psp.Spec
+	volumes := pspVolumes(psp)
+	allowsVolume := func(volume v1beta1.FSType) bool {
+		return volumes.Has(string(v1beta1.All)) || volumes.Has(string(volume))
+	}
+
+	controls := make([]PSPControl, 0)
+	add := func(restricts, implied bool, control PSPControl) {
+		if restricts && !implied {
+			controls = append(controls, control)
+		}
+	}
+	add(!spec.Privileged, atLeast(psaapi.LevelBaseline), ControlPrivileged)
+	add(!spec.HostNetwork || !spec.HostPID || !spec.HostIPC, atLeast(psaapi.LevelBaseline), ControlHostNamespaces)
+	add(!pspAllowsAllHostPorts(psp), atLeast(psaapi.LevelBaseline), ControlHostPorts)
+
+	allowedCapabilities := pspAllowedCapabilities(psp)
+	add(!allowedCapabilities.Has(string(v1beta1.AllowAllCapabilities)),
+		allowedCapabilities.IsSuperset(sets.NewString(levelCapabilities(level)...)), ControlCapabilities)
+	add(len(spec.RequiredDropCapabilities) > 0, level == psaapi.LevelRestricted, ControlRequiredDropCapabilities)
+
+	add(!volumes.Has(string(v1beta1.All)), volumes.IsSuperset(sets.NewString(levelVolumes(level)...)), ControlVolumes)
+	add(allowsVolume(v1beta1.HostPath) && len(spec.AllowedHostPaths) > 0, atLeast(psaapi.LevelBaseline), ControlHostPaths)
+	add(allowsVolume(v1beta1.FlexVolume) && len(spec.AllowedFlexVolumes) > 0, level == psaapi.LevelRestricted, ControlFlexVolumes)
+	add(allowsVolume(v1beta1.CSI) && len(spec.AllowedCSIDrivers) > 0, false, ControlCSIDrivers)
+
+	add(spec.ReadOnlyRootFilesystem, false, ControlReadOnlyRootFilesystem)
+	add(spec.RunAsUser.Rule != v1beta1.RunAsUserStrategyRunAsAny,
+		level == psaapi.LevelRestricted && spec.RunAsUser.Rule == v1beta1.RunAsUserStrategyMustRunAsNonRoot, ControlRunAsUser)
+	add(spec.RunAsGroup != nil && spec.RunAsGroup.Rule != v1beta1.RunAsGroupStrategyRunAsAny, false, ControlRunAsGroup)
+	add(spec.SupplementalGroups.Rule != v1beta1.SupplementalGroupsStrategyRunAsAny, false, ControlSupplementalGroups)
+	add(spec.FSGroup.Rule != v1beta1.FSGroupStrategyRunAsAny, false, ControlFSGroup)
+	add(spec.AllowPrivilegeEscalation != nil && !*spec.AllowPrivilegeEscalation,
+		level == psaapi.LevelRestricted, ControlAllowPrivilegeEscalation)
+	// MustRunAs pins the exact SELinux options, which is always more
+	// restrictive than the Pod Security Standards.
+	add(spec.SELinux.Rule == v1beta1.SELinuxStrategyMustRunAs, false, ControlSELinux)
+	add(!pspAllowsProcMount(psp, v1.UnmaskedProcMount), atLeast(psaapi.LevelBaseline), ControlProcMount)
+	add(!sets.NewString(spec.AllowedUnsafeSysctls...).Has("*") || len(spec.ForbiddenSysctls) > 0,
+		atLeast(psaapi.LevelBaseline) && len(spec.ForbiddenSysctls) == 0, ControlSysctls)
+
+	// Without the annotation pods can't set a seccomp profile at all. That
+	// only blocks hardening, so it isn't carried over.
+	seccompProfiles, ok := pspProfiles(psp, SeccompAllowedProfilesAnnotation)
+	add(ok && !sets.NewString(seccompProfiles...).Has("*"),
+		atLeast(psaapi.LevelBaseline) && coversProfiles(seccompProfiles, level), ControlSeccomp)
+	appArmorProfiles, ok := pspProfiles(psp, AppArmorAllowedProfilesAnnotation)
+	add(ok && !sets.NewString(appArmorProfiles...).Has("*"),
+		atLeast(psaapi.LevelBaseline) && coversProfiles(appArmorProfiles, level), ControlAppArmor)
+
+	return level, controls
+}
+
+func levelCapabilities(level psaapi.Level) []string {
+	switch level {
+	case psaapi.LevelRestricted:
+		return RestrictedCapabilities
+	case psaapi.LevelBaseline:
+		return BaselineCapabilities
+	default:
+		return []string{string(v1beta1.AllowAllCapabilities)}
+	}
+}
+
+func levelVolumes(level psaapi.Level) []string {
+	switch level {
+	case psaapi.LevelRestricted:
+		return RestrictedVolumes
+	case psaapi.LevelBaseline:
+		return sets.NewString(AllVolumes...).Delete(string(v1beta1.HostPath)).List()
+	default:
+		return []string{string(v1beta1.All)}
+	}
+}
+
+// coversProfiles returns whether the allowed profiles include every profile
+// the Pod Security Standard allows.
+func coversProfiles(profiles []string, level psaapi.Level) bool {
+	allowed := sets.NewString(profiles...)
+	if !allowed.Has("runtime/default") && !allowed.Has("docker/default") {
+		return false
+	}
+	if !allowed.Has("localhost/*") {
+		return false
+	}
+	// restricted requires a profile to be set
+	return level == psaapi.LevelRestricted || allowed.Has("")
+}
+
+func pspAllowedCapabilities(psp *v1beta1.PodSecurityPolicy) sets.String {
+	capabilities := sets.NewString()
+	for _, c := range psp.Spec.AllowedCapabilities {
+		capabilities.Insert(string(c))
+	}
+	for _, c := range psp.Spec.DefaultAddCapabilities {
+		capabilities.Insert(string(c))
+	}
+	return capabilities
+}
+
+func pspDropsAllCapabilities(psp *v1beta1.PodSecurityPolicy) bool {
+	for _, c := range psp.Spec.RequiredDropCapabilities {
+		if strings.EqualFold(string(c), "ALL") {
+			return true
+		}
+	}
+	return false
+}
+
+func pspVolumes(psp *v1beta1.PodSecurityPolicy) sets.String {
+	volumes := sets.NewString()
+	for _, v := range psp.Spec.Volumes {
+		volumes.Insert(string(v))
+	}
+	return volumes
+}
+
+func pspAllowsAllHostPorts(psp *v1beta1.PodSecurityPolicy) bool {
+	for _, r := range psp.Spec.HostPorts {
+		if r.Min <= 0 && r.Max >= 65535 {
+			return true
+		}
+	}
+	return false
+}
+
+func pspAllowsProcMount(psp *v1beta1.PodSecurityPolicy, procMount v1.ProcMountType) bool {
+	for _, p := range psp.Spec.AllowedProcMountTypes {
+		if p == procMount {
+			return true
+		}
+	}
+	return false
+}
+
+// pspProfiles returns the comma separated profiles of the annotation and
+// whether the annotation is set. An empty profile means that pods may leave
+// the profile unset.
+func pspProfiles(psp *v1beta1.PodSecurityPolicy, annotation string) ([]string, bool) {
+	value, ok := psp.Annotations[annotation]
+	if !ok {
+		return nil, false
+	}
+	profiles := strings.Split(value, ",")
+	for i := range profiles {
+		profiles[i] = strings.TrimSpace(profiles[i])
+	}
+	return profiles, true
+}
+
+func isRuntimeDefaultOrLocalhost(profile string) bool {
+	return profile == "runtime/default" || profile == "docker/default" || strings.HasPrefix(profile, "localhost/")
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newFalse() *bool {
+	b := false
+	return &b
+}
+
+// newBaselinePSP returns a PodSecurityPolicy that allows exactly what the
+// baseline Pod Security Standard allows.
+func newBaselinePSP() *v1beta1.PodSecurityPolicy {
+	return &v1beta1.PodSecurityPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "baseline",
+			Annotations: map[string]string{
+				AppArmorAllowedProfilesAnnotation: "runtime/default,localhost/*,",
+				SeccompAllowedProfilesAnnotation:  "runtime/default,localhost/*,",
+			},
+		},
+		Spec: v1beta1.PodSecurityPolicySpec{
+			AllowedCapabilities: []v1.Capability{
+				"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD",
+				"NET_BIND_SERVICE", "SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT",
+			},
+			Volumes: []v1beta1.FSType{
+				"azureFile", "flocker", "flexVolume", "emptyDir", "gcePersistentDisk",
+				"awsElasticBlockStore", "gitRepo", "secret", "nfs", "iscsi", "glusterfs",
+				"persistentVolumeClaim", "rbd", "cinder", "cephFS", "downwardAPI", "fc", "configMap",
+				"vsphereVolume", "quobyte", "azureDisk", "photonPersistentDisk", "storageos",
+				"projected", "portworxVolume", "scaleIO", "csi", "ephemeral",
+			},
+			SELinux:            v1beta1.SELinuxStrategyOptions{Rule: v1beta1.SELinuxStrategyMustRunAs},
+			RunAsUser:          v1beta1.RunAsUserStrategyOptions{Rule: v1beta1.RunAsUserStrategyRunAsAny},
+			SupplementalGroups: v1beta1.SupplementalGroupsStrategyOptions{Rule: v1beta1.SupplementalGroupsStrategyRunAsAny},
+			FSGroup:            v1beta1.FSGroupStrategyOptions{Rule: v1beta1.FSGroupStrategyRunAsAny},
+		},
+	}
+}
+
+// newRestrictedPSP returns a PodSecurityPolicy that allows exactly what the
+// restricted Pod Security Standard allows.
+func newRestrictedPSP() *v1beta1.PodSecurityPolicy {
+	psp := newBaselinePSP()
+	psp.Name = "restricted"
+	psp.Annotations[SeccompAllowedProfilesAnnotation] = "runtime/default,localhost/*"
+	psp.Spec.AllowedCapabilities = []v1.Capability{"NET_BIND_SERVICE"}
+	psp.Spec.RequiredDropCapabilities = []v1.Capability{"ALL"}
+	psp.Spec.Volumes = []v1beta1.FSType{
+		"configMap", "csi", "downwardAPI", "emptyDir", "ephemeral", "persistentVolumeClaim", "projected", "secret",
+	}
+	psp.Spec.AllowPrivilegeEscalation = newFalse()
+	psp.Spec.RunAsUser.Rule = v1beta1.RunAsUserStrategyMustRunAsNonRoot
+	return psp
+}
+
+// newPrivilegedPSP returns a PodSecurityPolicy that allows everything.
+func newPrivilegedPSP() *v1beta1.PodSecurityPolicy {
+	return &v1beta1.PodSecurityPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "privileged",
+			Annotations: map[string]string{
+				AppArmorAllowedProfilesAnnotation: "*",
+				SeccompAllowedProfilesAnnotation:  "*",
+			},
+		},
+		Spec: v1beta1.PodSecurityPolicySpec{
+			Privileged:            true,
+			HostNetwork:           true,
+			HostPID:               true,
+			HostIPC:               true,
+			HostPorts:             []v1beta1.HostPortRange{{Min: 0, Max: 65535}},
+			AllowedCapabilities:   []v1.Capability{v1beta1.AllowAllCapabilities},
+			Volumes:               []v1beta1.FSType{v1beta1.All},
+			AllowedUnsafeSysctls:  []string{"*"},
+			AllowedProcMountTypes: []v1.ProcMountType{v1.DefaultProcMount, v1.UnmaskedProcMount},
+			SELinux:               v1beta1.SELinuxStrategyOptions{Rule: v1beta1.SELinuxStrategyRunAsAny},
+			RunAsUser:             v1beta1.RunAsUserStrategyOptions{Rule: v1beta1.RunAsUserStrategyRunAsAny},
+			SupplementalGroups:    v1beta1.SupplementalGroupsStrategyOptions{Rule: v1beta1.SupplementalGroupsStrategyRunAsAny},
+			FSGroup:               v1beta1.FSGroupStrategyOptions{Rule: v1beta1.FSGroupStrategyRunAsAny},
+		},
+	}
+}
+
+func TestPSPPodSecurityStandard(t *testing.T) {
+	privileged := newBaselinePSP()
+	privileged.Spec.Privileged = true
+	noAppArmor := newBaselinePSP()
+	delete(noAppArmor.Annotations, AppArmorAllowedProfilesAnnotation)
+	rootUser := newRestrictedPSP()
+	rootUser.Spec.RunAsUser = v1beta1.RunAsUserStrategyOptions{
+		Rule:   v1beta1.RunAsUserStrategyMustRunAs,
+		Ranges: []v1beta1.IDRange{{Min: 0, Max: 1000}},
+	}
+
+	cases := []struct {
+		Name     string
+		PSP      *v1beta1.PodSecurityPolicy
+		Expected string
+	}{
+		{"baseline", newBaselinePSP(), "baseline"},
+		{"restricted", newRestrictedPSP(), "restricted"},
+		{"privileged", privileged, "privileged"},
+		{"allow-all", newPrivilegedPSP(), "privileged"},
+		{"apparmor-unset", noAppArmor, "privileged"},
+		{"root-user", rootUser, "baseline"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if level := PSPPodSecurityStandard(tc.PSP); string(level) != tc.Expected {
+				t.Errorf("Expected %v, but got %v", tc.Expected, level)
+			}
+		})
+	}
+}
+
+func TestResidualPSPControls(t *testing.T) {
+	level, controls := ResidualPSPControls(newRestrictedPSP())
+	if level != "restricted" {
+		t.Errorf("Expected restricted, but got %v", level)
+	}
+	// MustRunAs pins the SELinux options, which no Pod Security Standard does
+	if len(controls) != 1 || controls[0] != ControlSELinux {
+		t.Errorf("Expected only the SELinux control, but got %v", controls)
+	}
+
+	psp := newBaselinePSP()
+	psp.Spec.ReadOnlyRootFilesystem = true
+	psp.Spec.AllowedCSIDrivers = []v1beta1.AllowedCSIDriver{{Name: "csi.example.com"}}
+	psp.Spec.FSGroup = v1beta1.FSGroupStrategyOptions{
+		Rule:   v1beta1.FSGroupStrategyMustRunAs,
+		Ranges: []v1beta1.IDRange{{Min: 1000, Max: 2000}},
+	}
+	psp.Spec.AllowedCapabilities = []v1.Capability{"CHOWN"}
+	level, controls = ResidualPSPControls(psp)
+	if level != "baseline" {
+		t.Errorf("Expected baseline, but got %v", level)
+	}
+	expected := []PSPControl{ControlCapabilities, ControlCSIDrivers, ControlReadOnlyRootFilesystem, ControlFSGroup, ControlSELinux}
+	if len(controls) != len(expected) {
+		t.Fatalf("Expected %v, but got %v", expected, controls)
+	}
+	for i := range expected {
+		if controls[i] != expected[i] {
+			t.Errorf("Expected %v, but got %v", expected, controls)
+		}
+	}
+}
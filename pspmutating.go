@@ -18,18 +18,23 @@ package pspmigrator
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/go-test/deep"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	psaadmission "k8s.io/pod-security-admission/admission"
 )
 
+// GetContainerSecurityContexts returns the security contexts of all
+// containers by position. Use DiffContainerSecurityContexts to compare the
+// containers of two pod specs.
 func GetContainerSecurityContexts(podSpec *v1.PodSpec) []*v1.SecurityContext {
 	// TODO reuse VisitContainers from k8s pkg/api/pod/util.go
 	scs := make([]*v1.SecurityContext, 0)
@@ -45,6 +50,67 @@ func GetContainerSecurityContexts(podSpec *v1.PodSpec) []*v1.SecurityContext {
 	return scs
 }
 
+// SecurityContextDiff is the difference between the security contexts of the
+// containers of a pod template and a pod. Containers are identified by type
+// and name, e.g. "initContainers[istio-init]".
+type SecurityContextDiff struct {
+	// Changed are the differences of the containers in both specs, prefixed
+	// with the container, e.g. "containers[nginx]: RunAsUser: <nil pointer> != int64".
+	Changed []string
+	// OnlyInPod are the containers that aren't in the template, e.g. the
+	// sidecars added by other mutating webhooks.
+	OnlyInPod []string
+	// OnlyInTemplate are the containers that were removed from the pod.
+	OnlyInTemplate []string
+}
+
+// namedSecurityContexts returns the security contexts of the containers by
+// their type and name, and the containers in order.
+func namedSecurityContexts(podSpec *v1.PodSpec) (map[string]*v1.SecurityContext, []string) {
+	scs := make(map[string]*v1.SecurityContext)
+	names := make([]string, 0)
+	add := func(containerType, name string, sc *v1.SecurityContext) {
+		key := fmt.Sprintf("%s[%s]", containerType, name)
+		scs[key] = sc
+		names = append(names, key)
+	}
+	for _, c := range podSpec.Containers {
+		add("containers", c.Name, c.SecurityContext)
+	}
+	for _, c := range podSpec.InitContainers {
+		add("initContainers", c.Name, c.SecurityContext)
+	}
+	for _, c := range podSpec.EphemeralContainers {
+		add("ephemeralContainers", c.Name, c.SecurityContext)
+	}
+	return scs, names
+}
+
+// DiffContainerSecurityContexts compares the security contexts of the
+// containers of a pod template and a pod. Containers are paired by type and
+// name, so a container added to the pod doesn't shift the others.
+func DiffContainerSecurityContexts(template, pod *v1.PodSpec) SecurityContextDiff {
+	diff := SecurityContextDiff{Changed: make([]string, 0), OnlyInPod: make([]string, 0), OnlyInTemplate: make([]string, 0)}
+	templateSCs, templateNames := namedSecurityContexts(template)
+	podSCs, podNames := namedSecurityContexts(pod)
+	for _, name := range templateNames {
+		podSC, ok := podSCs[name]
+		if !ok {
+			diff.OnlyInTemplate = append(diff.OnlyInTemplate, name)
+			continue
+		}
+		for _, d := range deep.Equal(templateSCs[name], podSC) {
+			diff.Changed = append(diff.Changed, fmt.Sprintf("%s: %s", name, d))
+		}
+	}
+	for _, name := range podNames {
+		if _, ok := templateSCs[name]; !ok {
+			diff.OnlyInPod = append(diff.OnlyInPod, name)
+		}
+	}
+	return diff
+}
+
 func GetPSPAnnotations(annotations map[string]string) map[string]string {
 	pspAnnotations := make(map[string]string)
 	for ann, val := range annotations {
@@ -55,64 +121,186 @@ func GetPSPAnnotations(annotations map[string]string) map[string]string {
 	return pspAnnotations
 }
 
-func FetchControllerPod(kind, name, namespace string, clientset *kubernetes.Clientset) (*metav1.ObjectMeta, *v1.PodSpec, error) {
-	obj, err := FetchControllerObj(kind, name, namespace, clientset)
+func FetchControllerPod(kind, name, namespace string, clientset kubernetes.Interface) (*metav1.ObjectMeta, *v1.PodSpec, error) {
+	return fetchControllerPod(kind, name, namespace, ClientsetControllerFetcher(clientset))
+}
+
+func fetchControllerPod(kind, name, namespace string, fetch ControllerFetcher) (*metav1.ObjectMeta, *v1.PodSpec, error) {
+	obj, err := fetch(kind, name, namespace)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to fetch controller: %w", err)
 	}
 	return psaadmission.DefaultPodSpecExtractor{}.ExtractPodSpec(obj)
 }
 
-func FetchControllerObj(kind, name, namespace string, clientset *kubernetes.Clientset) (runtime.Object, error) {
+// FetchControllerObj gets the controller of a pod. An UnsupportedOwnerError,
+// OwnerNotFoundError or ForbiddenError is returned if it can't be looked up.
+func FetchControllerObj(kind, name, namespace string, clientset kubernetes.Interface) (runtime.Object, error) {
 	// TODO review and document which controllers don't require special handling
 	// https://github.com/kubernetes/pod-security-admission/blob/master/admission/admission.go#L93
 	// for example, Deployments would fall under the ReplicaSet case so no need to have a case
 	// statement for Deployments.
+	var obj runtime.Object
+	var err error
 	switch kind {
 	case "ReplicaSet":
-		return clientset.AppsV1().ReplicaSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		obj, err = clientset.AppsV1().ReplicaSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	case "DaemonSet":
-		return clientset.AppsV1().DaemonSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		obj, err = clientset.AppsV1().DaemonSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	default:
-		return nil, fmt.Errorf("unsupported controller kind %s", kind)
+		return nil, &UnsupportedOwnerError{Kind: kind}
+	}
+	if err != nil {
+		return nil, typedAPIError(err, kind, name, namespace)
 	}
+	return obj, nil
+}
+
+// MutationChecker checks whether pods are being mutated by PSP objects. The
+// controllers of the pods are looked up with FetchController, which allows
+// callers to plug in a ControllerCache or an informer backed lookup.
+type MutationChecker struct {
+	FetchController ControllerFetcher
+	// Exclusions are the containers that are left out of the comparison,
+	// e.g. injected sidecars that aren't part of the controller's template.
+	Exclusions *ContainerExclusions
+}
+
+// NewMutationChecker returns a MutationChecker that fetches every controller
+// only once from the API server and uses the DefaultContainerExclusions. It
+// is safe for concurrent use.
+func NewMutationChecker(clientset kubernetes.Interface) *MutationChecker {
+	cache := NewControllerCache(ClientsetControllerFetcher(clientset))
+	exclusions := DefaultContainerExclusions
+	return &MutationChecker{FetchController: cache.Fetch, Exclusions: &exclusions}
+}
+
+// PodMutationStatus is the outcome of checking whether a pod is mutated by a
+// PSP object.
+type PodMutationStatus string
+
+const (
+	PodMutated    PodMutationStatus = "Mutated"
+	PodNotMutated PodMutationStatus = "NotMutated"
+	// PodMutationUnknown is the status of pods that can't be compared with
+	// the pod they were created from, e.g. because their controller no
+	// longer exists.
+	PodMutationUnknown PodMutationStatus = "Unknown"
+	// PodMutationNoBaseline is the status of pods without a controller that
+	// have no last-applied configuration or managed fields, see ErrNoBaseline.
+	PodMutationNoBaseline PodMutationStatus = "NoBaseline"
+)
+
+// PodMutation is the result of checking whether a pod is mutated by a PSP
+// object.
+type PodMutation struct {
+	Status PodMutationStatus
+	// Diff is the difference between the pod and the pod it was created
+	// from.
+	Diff []string
+	// Reason explains the status if the pod wasn't compared, e.g. "mirror
+	// pod of a static pod".
+	Reason string
 }
 
 // IsPodBeingMutatedByPSP returns whether a pod is likely mutated by a PSP object. It also returns the difference
-// of the securityContext attribute between the parent controller (e.g. Deployment) and the running pod.
-func IsPodBeingMutatedByPSP(pod *v1.Pod, clientset *kubernetes.Clientset) (mutating bool, diff []string, err error) {
-	diff = make([]string, 0)
-	if len(pod.ObjectMeta.OwnerReferences) > 0 {
-		var owner metav1.OwnerReference
-		for _, reference := range pod.ObjectMeta.OwnerReferences {
-			if reference.Controller != nil && *reference.Controller == true {
-				owner = reference
-				break
+// of the securityContext attribute between the parent controller (e.g. Deployment) and the running pod. Containers
+// that are only in the pod or only in the controller are part of the difference, but don't make the pod mutated.
+// Pods without a controller are compared with their last-applied configuration or managed fields, ErrNoBaseline is
+// returned if they have neither.
+func IsPodBeingMutatedByPSP(pod *v1.Pod, clientset kubernetes.Interface) (mutating bool, diff []string, err error) {
+	checker := &MutationChecker{FetchController: ClientsetControllerFetcher(clientset)}
+	return checker.IsPodBeingMutatedByPSP(pod)
+}
+
+// IsPodBeingMutatedByPSP is like the package level IsPodBeingMutatedByPSP,
+// but looks up the controller of the pod using the checker. An error is
+// returned for pods with an unknown status, use CheckPod to tell them apart.
+func (m *MutationChecker) IsPodBeingMutatedByPSP(pod *v1.Pod) (mutating bool, diff []string, err error) {
+	result, err := m.CheckPod(pod)
+	if err != nil {
+		return false, make([]string, 0), err
+	}
+	switch result.Status {
+	case PodMutationNoBaseline:
+		return false, result.Diff, ErrNoBaseline
+	case PodMutationUnknown:
+		return false, result.Diff, fmt.Errorf("unable to check if pod %s is mutated: %s", pod.Name, result.Reason)
+	}
+	return result.Status == PodMutated, result.Diff, nil
+}
+
+// CheckPod checks whether a pod is mutated by a PSP object. Mirror pods of
+// static pods aren't mutated, since their containers are created from the
+// manifest on the node. Pods whose controller no longer exists have an
+// unknown status. Errors are only returned if the pod can't be checked for
+// another reason, e.g. a controller of an unsupported kind.
+func (m *MutationChecker) CheckPod(pod *v1.Pod) (*PodMutation, error) {
+	pod, excluded := m.Exclusions.Apply(pod)
+	if _, ok := pod.Annotations[v1.MirrorPodAnnotationKey]; ok {
+		return &PodMutation{Status: PodNotMutated, Diff: make([]string, 0), Reason: "mirror pod of a static pod"}, nil
+	}
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		mutating, diff, err := isBarePodMutated(pod, excluded)
+		if errors.Is(err, ErrNoBaseline) {
+			reason := "no controller, last-applied configuration or managed fields"
+			if len(pod.OwnerReferences) > 0 {
+				reason = "none of the owners is a controller, and no last-applied configuration or managed fields"
 			}
+			return &PodMutation{Status: PodMutationNoBaseline, Diff: diff, Reason: reason}, nil
+		} else if err != nil {
+			return nil, err
 		}
-		if owner.Kind == "Node" {
-			// static pods launched by the node that can't be mutated
-			return false, diff, nil
-		}
-		parentPodMeta, parentPodSpec, err := FetchControllerPod(owner.Kind, owner.Name, pod.Namespace, clientset)
-		if err != nil {
-			return false, diff, err
-		}
-		// TODO investigate if 1st party library can be used such as github.com/google/go-cmp or smth from k8s
-		if diffNew := deep.Equal(GetContainerSecurityContexts(parentPodSpec), GetContainerSecurityContexts(&pod.Spec)); diffNew != nil {
-			diff = append(diff, diffNew...)
-		}
-		if diffNew := deep.Equal(parentPodSpec.SecurityContext, pod.Spec.SecurityContext); diffNew != nil {
-			diff = append(diff, diffNew...)
-		}
-		if diffNew := deep.Equal(GetPSPAnnotations(parentPodMeta.Annotations), GetPSPAnnotations(pod.ObjectMeta.Annotations)); diffNew != nil {
-			diff = append(diff, diffNew...)
-		}
+		return newPodMutation(mutating, diff), nil
+	}
+	if owner.Kind == "Node" {
+		// static pods launched by the node that can't be mutated
+		return &PodMutation{Status: PodNotMutated, Diff: make([]string, 0), Reason: "static pod of node " + owner.Name}, nil
+	}
+	parentPodMeta, parentPodSpec, err := fetchControllerPod(owner.Kind, owner.Name, pod.Namespace, m.FetchController)
+	if apierrors.IsNotFound(err) {
+		reason := fmt.Sprintf("%s %s no longer exists", owner.Kind, owner.Name)
+		return &PodMutation{Status: PodMutationUnknown, Diff: make([]string, 0), Reason: reason}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(excluded) > 0 {
+		parentPodSpec = excludeNames(parentPodSpec, excluded)
+	}
+	return newPodMutation(diffPodSecurity(parentPodMeta, parentPodSpec, pod, owner.Kind)), nil
+}
+
+func newPodMutation(mutating bool, diff []string) *PodMutation {
+	if mutating {
+		return &PodMutation{Status: PodMutated, Diff: diff}
+	}
+	return &PodMutation{Status: PodNotMutated, Diff: diff}
+}
+
+// diffPodSecurity compares the security contexts and PSP annotations of a pod
+// with the pod it was created from, e.g. the template of its controller. The
+// containers that are only in either of them are reported as well, but don't
+// make the pod mutated.
+func diffPodSecurity(templateMeta *metav1.ObjectMeta, templateSpec *v1.PodSpec, pod *v1.Pod, source string) (mutating bool, diff []string) {
+	diff = make([]string, 0)
+	containersDiff := DiffContainerSecurityContexts(templateSpec, &pod.Spec)
+	diff = append(diff, containersDiff.Changed...)
+	// TODO investigate if 1st party library can be used such as github.com/google/go-cmp or smth from k8s
+	for _, d := range deep.Equal(templateSpec.SecurityContext, pod.Spec.SecurityContext) {
+		diff = append(diff, fmt.Sprintf("securityContext: %s", d))
+	}
+	if diffNew := deep.Equal(GetPSPAnnotations(templateMeta.Annotations), GetPSPAnnotations(pod.ObjectMeta.Annotations)); diffNew != nil {
+		diff = append(diff, diffNew...)
+	}
+	mutating = len(diff) > 0
+	for _, name := range containersDiff.OnlyInPod {
+		diff = append(diff, fmt.Sprintf("%s: only in pod", name))
 	}
-	if len(diff) > 0 {
-		return true, diff, nil
+	for _, name := range containersDiff.OnlyInTemplate {
+		diff = append(diff, fmt.Sprintf("%s: only in %s", name, source))
 	}
-	return false, diff, nil
+	return mutating, diff
 }
 
 // IsPSPMutating checks wheter a PodSecurityPolicy is potentially mutating
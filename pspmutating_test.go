@@ -23,6 +23,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -33,6 +35,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
 
@@ -289,3 +292,85 @@ func TestIngreation(t *testing.T) {
 		})
 	}
 }
+
+func TestDiffContainerSecurityContextsPairsByName(t *testing.T) {
+	template := &v1.PodSpec{
+		Containers:     []v1.Container{{Name: "nginx"}, {Name: "logger"}},
+		InitContainers: []v1.Container{{Name: "setup"}},
+	}
+	pod := &v1.PodSpec{
+		Containers: []v1.Container{
+			{Name: "sidecar", SecurityContext: &v1.SecurityContext{RunAsUser: newInt64(1337)}},
+			{Name: "nginx", SecurityContext: &v1.SecurityContext{RunAsUser: newInt64(1000)}},
+		},
+		InitContainers: []v1.Container{{Name: "setup"}},
+	}
+	diff := DiffContainerSecurityContexts(template, pod)
+	if len(diff.Changed) != 1 || !strings.HasPrefix(diff.Changed[0], "containers[nginx]: ") {
+		t.Errorf("Expected only nginx to be changed, got %v", diff.Changed)
+	}
+	if !reflect.DeepEqual(diff.OnlyInPod, []string{"containers[sidecar]"}) {
+		t.Errorf("Expected the sidecar to be only in the pod, got %v", diff.OnlyInPod)
+	}
+	if !reflect.DeepEqual(diff.OnlyInTemplate, []string{"containers[logger]"}) {
+		t.Errorf("Expected the logger to be only in the template, got %v", diff.OnlyInTemplate)
+	}
+}
+
+func TestMutationCheckerAttributesDiffToContainer(t *testing.T) {
+	clientset := fake.NewSimpleClientset(newReplicaSet("nginx", "default"))
+	checker := &MutationChecker{FetchController: ClientsetControllerFetcher(clientset)}
+	pod := newOwnedPod("nginx-1", "default", "ReplicaSet", "nginx")
+	pod.Spec.Containers = []v1.Container{
+		{Name: "sidecar"},
+		{Name: "nginx", SecurityContext: &v1.SecurityContext{AllowPrivilegeEscalation: newFalse()}},
+	}
+	mutated, diff, err := checker.IsPodBeingMutatedByPSP(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"containers[nginx]: <nil pointer> != v1.SecurityContext",
+		"containers[sidecar]: only in pod",
+	}
+	if !mutated || !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected mutated with diff %v, got %v, %v", expected, mutated, diff)
+	}
+}
+
+func TestMutationCheckerCheckPodStatus(t *testing.T) {
+	checker := &MutationChecker{FetchController: ClientsetControllerFetcher(fake.NewSimpleClientset())}
+
+	mirror := newOwnedPod("etcd-node1", "kube-system", "Node", "node1")
+	mirror.Annotations = map[string]string{v1.MirrorPodAnnotationKey: "abc"}
+	deleted := newOwnedPod("nginx-1", "default", "ReplicaSet", "nginx")
+	notController := newOwnedPod("nginx-2", "default", "ReplicaSet", "nginx")
+	notController.OwnerReferences[0].Controller = nil
+
+	cases := []struct {
+		Name   string
+		Pod    *v1.Pod
+		Status PodMutationStatus
+		Reason string
+	}{
+		{"mirror pod", mirror, PodNotMutated, "mirror pod of a static pod"},
+		{"deleted controller", deleted, PodMutationUnknown, "ReplicaSet nginx no longer exists"},
+		{"owner without controller flag", notController, PodMutationNoBaseline,
+			"none of the owners is a controller, and no last-applied configuration or managed fields"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			result, err := checker.CheckPod(tc.Pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Status != tc.Status || result.Reason != tc.Reason {
+				t.Errorf("Expected %v (%v), got %v (%v)", tc.Status, tc.Reason, result.Status, result.Reason)
+			}
+		})
+	}
+
+	if _, _, err := checker.IsPodBeingMutatedByPSP(deleted); err == nil {
+		t.Error("Expected an error for a pod with an unknown status")
+	}
+}
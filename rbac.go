@@ -0,0 +1,163 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const serviceAccountsGroupPrefix = "system:serviceaccounts:"
+
+// PSPAuthorizations records which subjects RBAC authorizes to use which PSP
+// objects. A pod can only be admitted by a PSP object if its service account,
+// or the user creating it, may use the PSP object in the namespace of the pod.
+type PSPAuthorizations struct {
+	roles        map[string][]rbacv1.PolicyRule
+	clusterRoles map[string][]rbacv1.PolicyRule
+	roleBindings []rbacv1.RoleBinding
+	crBindings   []rbacv1.ClusterRoleBinding
+}
+
+// NewPSPAuthorizations returns the PSP authorizations granted by the roles and
+// their bindings.
+func NewPSPAuthorizations(roles []rbacv1.Role, clusterRoles []rbacv1.ClusterRole,
+	roleBindings []rbacv1.RoleBinding, clusterRoleBindings []rbacv1.ClusterRoleBinding) *PSPAuthorizations {
+	a := &PSPAuthorizations{
+		roles:        make(map[string][]rbacv1.PolicyRule),
+		clusterRoles: make(map[string][]rbacv1.PolicyRule),
+		roleBindings: roleBindings,
+		crBindings:   clusterRoleBindings,
+	}
+	for _, role := range roles {
+		a.roles[role.Namespace+"/"+role.Name] = role.Rules
+	}
+	for _, role := range clusterRoles {
+		a.clusterRoles[role.Name] = role.Rules
+	}
+	return a
+}
+
+// Subjects returns the subjects of the bindings that authorize the use of
+// the PSP object. Subjects of RoleBindings without a namespace get the
+// namespace of the RoleBinding.
+func (a *PSPAuthorizations) Subjects(psp string) []rbacv1.Subject {
+	subjects := make([]rbacv1.Subject, 0)
+	for _, binding := range a.crBindings {
+		if allowsUse(a.clusterRoles[binding.RoleRef.Name], psp) {
+			subjects = append(subjects, binding.Subjects...)
+		}
+	}
+	for _, binding := range a.roleBindings {
+		if !allowsUse(a.roleRules(binding), psp) {
+			continue
+		}
+		for _, subject := range binding.Subjects {
+			if subject.Kind == rbacv1.ServiceAccountKind && subject.Namespace == "" {
+				subject.Namespace = binding.Namespace
+			}
+			subjects = append(subjects, subject)
+		}
+	}
+	return subjects
+}
+
+// Namespaces returns the namespaces whose service accounts may use the PSP
+// object, sorted by name. allNamespaces is true if the service accounts of
+// every namespace may use it, e.g. because it's bound to the
+// system:serviceaccounts group. Users and other groups are ignored since
+// they don't restrict the namespaces pods are created in.
+func (a *PSPAuthorizations) Namespaces(psp string) (namespaces []string, allNamespaces bool) {
+	result := sets.NewString()
+	for _, binding := range a.crBindings {
+		if !allowsUse(a.clusterRoles[binding.RoleRef.Name], psp) {
+			continue
+		}
+		for _, subject := range binding.Subjects {
+			switch ns, all := serviceAccountNamespace(subject); {
+			case all:
+				return nil, true
+			case ns != "":
+				result.Insert(ns)
+			}
+		}
+	}
+	for _, binding := range a.roleBindings {
+		if !allowsUse(a.roleRules(binding), psp) {
+			continue
+		}
+		for _, subject := range binding.Subjects {
+			if subject.Kind == rbacv1.ServiceAccountKind && subject.Namespace == "" {
+				subject.Namespace = binding.Namespace
+			}
+			// a RoleBinding only grants access within its own namespace
+			if ns, all := serviceAccountNamespace(subject); all || ns == binding.Namespace {
+				result.Insert(binding.Namespace)
+			}
+		}
+	}
+	return result.List(), false
+}
+
+func (a *PSPAuthorizations) roleRules(binding rbacv1.RoleBinding) []rbacv1.PolicyRule {
+	if binding.RoleRef.Kind == "ClusterRole" {
+		return a.clusterRoles[binding.RoleRef.Name]
+	}
+	return a.roles[binding.Namespace+"/"+binding.RoleRef.Name]
+}
+
+// serviceAccountNamespace returns the namespace of the service accounts the
+// subject refers to, or all if it refers to the service accounts of all
+// namespaces.
+func serviceAccountNamespace(subject rbacv1.Subject) (namespace string, all bool) {
+	switch subject.Kind {
+	case rbacv1.ServiceAccountKind:
+		return subject.Namespace, false
+	case rbacv1.GroupKind:
+		switch {
+		case subject.Name == "system:serviceaccounts" || subject.Name == "system:authenticated":
+			return "", true
+		case strings.HasPrefix(subject.Name, serviceAccountsGroupPrefix):
+			return strings.TrimPrefix(subject.Name, serviceAccountsGroupPrefix), false
+		}
+	}
+	return "", false
+}
+
+// allowsUse returns whether the rules allow the use of the PSP object.
+func allowsUse(rules []rbacv1.PolicyRule, psp string) bool {
+	for _, rule := range rules {
+		if matches(rule.Verbs, "use") &&
+			(matches(rule.APIGroups, "policy") || matches(rule.APIGroups, "extensions")) &&
+			matches(rule.Resources, "podsecuritypolicies") &&
+			(len(rule.ResourceNames) == 0 || sets.NewString(rule.ResourceNames...).Has(psp)) {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(values []string, value string) bool {
+	for _, v := range values {
+		if v == value || v == "*" {
+			return true
+		}
+	}
+	return false
+}
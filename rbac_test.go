@@ -0,0 +1,119 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPSPAuthorizationsFromFixture(t *testing.T) {
+	f, err := os.Open("tests/rolebindings.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := DecodeManifests(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespaces, all := m.PSPAuthorizations().Namespaces("my-psp")
+	if all || !reflect.DeepEqual(namespaces, []string{"default"}) {
+		t.Errorf("Expected my-psp to be authorized in namespace default, but got %v, all namespaces %v", namespaces, all)
+	}
+	if namespaces, all := m.PSPAuthorizations().Namespaces("other-psp"); all || len(namespaces) != 0 {
+		t.Errorf("Expected other-psp not to be authorized, but got %v, all namespaces %v", namespaces, all)
+	}
+}
+
+func TestPSPAuthorizationsNamespaces(t *testing.T) {
+	useRule := func(names ...string) []rbacv1.PolicyRule {
+		return []rbacv1.PolicyRule{{
+			APIGroups: []string{"policy"}, Resources: []string{"podsecuritypolicies"},
+			Verbs: []string{"use"}, ResourceNames: names,
+		}}
+	}
+	roles := []rbacv1.Role{
+		{ObjectMeta: metav1.ObjectMeta{Name: "use-restricted", Namespace: "team-b"}, Rules: useRule("restricted")},
+	}
+	clusterRoles := []rbacv1.ClusterRole{
+		{ObjectMeta: metav1.ObjectMeta{Name: "use-restricted"}, Rules: useRule("restricted")},
+		{ObjectMeta: metav1.ObjectMeta{Name: "use-all"}, Rules: useRule()},
+		{ObjectMeta: metav1.ObjectMeta{Name: "edit"}, Rules: []rbacv1.PolicyRule{{
+			APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"*"},
+		}}},
+	}
+	roleBindings := []rbacv1.RoleBinding{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "sa", Namespace: "team-a"},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "use-restricted"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "default"}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "group", Namespace: "team-b"},
+			RoleRef:    rbacv1.RoleRef{Kind: "Role", Name: "use-restricted"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: "system:serviceaccounts:team-b"}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "user", Namespace: "team-c"},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "use-restricted"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: "jane"}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "edit", Namespace: "team-d"},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "edit"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: "system:serviceaccounts:team-d"}},
+		},
+	}
+	clusterRoleBindings := []rbacv1.ClusterRoleBinding{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "privileged"},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "use-all"},
+			Subjects: []rbacv1.Subject{
+				{Kind: rbacv1.ServiceAccountKind, Name: "daemon", Namespace: "kube-system"},
+				{Kind: rbacv1.GroupKind, Name: "system:masters"},
+			},
+		},
+	}
+	a := NewPSPAuthorizations(roles, clusterRoles, roleBindings, clusterRoleBindings)
+
+	namespaces, all := a.Namespaces("restricted")
+	if all || !reflect.DeepEqual(namespaces, []string{"kube-system", "team-a", "team-b"}) {
+		t.Errorf("Unexpected namespaces for restricted: %v, all namespaces %v", namespaces, all)
+	}
+	namespaces, all = a.Namespaces("privileged")
+	if all || !reflect.DeepEqual(namespaces, []string{"kube-system"}) {
+		t.Errorf("Unexpected namespaces for privileged: %v, all namespaces %v", namespaces, all)
+	}
+	if subjects := a.Subjects("privileged"); len(subjects) != 2 {
+		t.Errorf("Expected 2 subjects for privileged, but got %v", subjects)
+	}
+
+	clusterRoleBindings = append(clusterRoleBindings, rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "everyone"},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "use-restricted"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: "system:serviceaccounts"}},
+	})
+	a = NewPSPAuthorizations(roles, clusterRoles, roleBindings, clusterRoleBindings)
+	if _, all := a.Namespaces("restricted"); !all {
+		t.Errorf("Expected restricted to be authorized in all namespaces")
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	psaapi "k8s.io/pod-security-admission/api"
+	"k8s.io/pod-security-admission/policy"
+)
+
+// levels are the Pod Security Standards from least to most restrictive.
+var levels = []psaapi.Level{psaapi.LevelPrivileged, psaapi.LevelBaseline, psaapi.LevelRestricted}
+
+// LevelRule derives the level of the warn or audit mode from the enforced
+// level. It's either a fixed level such as "restricted", or "+N" for the
+// level N levels above the enforced one, capped at restricted.
+type LevelRule string
+
+// ParseLevelRule validates the rule.
+func ParseLevelRule(rule string) (LevelRule, error) {
+	if strings.HasPrefix(rule, "+") {
+		if n, err := strconv.Atoi(rule[1:]); err != nil || n < 0 {
+			return "", fmt.Errorf("invalid level rule %q, must be a level or +N", rule)
+		}
+		return LevelRule(rule), nil
+	}
+	if _, err := psaapi.ParseLevel(rule); err != nil {
+		return "", fmt.Errorf("invalid level rule %q, must be a level or +N", rule)
+	}
+	return LevelRule(rule), nil
+}
+
+// Level returns the level of the rule for the enforced level. The result is
+// never less restrictive than the enforced level.
+func (r LevelRule) Level(enforce psaapi.Level) psaapi.Level {
+	if strings.HasPrefix(string(r), "+") {
+		n, _ := strconv.Atoi(string(r)[1:])
+		i := 0
+		for i < len(levels) && levels[i] != enforce {
+			i++
+		}
+		if i+n >= len(levels) {
+			return psaapi.LevelRestricted
+		}
+		return levels[i+n]
+	}
+	if level := psaapi.Level(r); psaapi.CompareLevels(level, enforce) > 0 {
+		return level
+	}
+	return enforce
+}
+
+// RecommendationPolicy configures how the warn and audit levels of a
+// namespace are derived from the level that is enforced.
+type RecommendationPolicy struct {
+	Warn  LevelRule
+	Audit LevelRule
+}
+
+// DefaultRecommendationPolicy warns and audits one level above the enforced
+// level, so teams learn about the next level without breaking.
+var DefaultRecommendationPolicy = RecommendationPolicy{Warn: "+1", Audit: "+1"}
+
+// PodViolation is a pod that isn't allowed to run under a level.
+type PodViolation struct {
+	Pod   string
+	Level psaapi.Level
+	// Reason is the violation as reported by the PodSecurity admission
+	// plugin, e.g. "runAsNonRoot != true (pod or container "nginx" must
+	// set securityContext.runAsNonRoot=true)".
+	Reason string
+}
+
+// LevelRecommendation is the suggested level of every mode of a namespace.
+type LevelRecommendation struct {
+	Enforce psaapi.Level
+	Warn    psaapi.Level
+	Audit   psaapi.Level
+	// Violations explain the recommendation: the pods that aren't allowed
+	// to run under the levels above the enforced one.
+	Violations []PodViolation
+}
+
+// Labels returns the pod-security.kubernetes.io labels of the
+// recommendation.
+func (r *LevelRecommendation) Labels() map[string]string {
+	return map[string]string{
+		psaapi.EnforceLevelLabel: string(r.Enforce),
+		psaapi.WarnLevelLabel:    string(r.Warn),
+		psaapi.AuditLevelLabel:   string(r.Audit),
+	}
+}
+
+// RecommendNamespaceLevels recommends the levels of all modes for a
+// namespace with the given pods. The most restrictive level all pods are
+// allowed to run under is enforced, the warn and audit levels are derived
+// from it by the policy.
+func RecommendNamespaceLevels(pods []v1.Pod, recommendationPolicy RecommendationPolicy) (*LevelRecommendation, error) {
+	enforce, err := SuggestedNamespacePodSecurityStandard(pods)
+	if err != nil {
+		return nil, err
+	}
+	recommendation := &LevelRecommendation{
+		Enforce: enforce,
+		Warn:    recommendationPolicy.Warn.Level(enforce),
+		Audit:   recommendationPolicy.Audit.Level(enforce),
+	}
+	for _, level := range levels {
+		if psaapi.CompareLevels(level, enforce) <= 0 {
+			continue
+		}
+		for i := range pods {
+			pod := &pods[i]
+			result := policy.AggregateCheckResults(evaluator.EvaluatePod(
+				psaapi.LevelVersion{Level: level, Version: psaapi.LatestVersion()}, &pod.ObjectMeta, &pod.Spec))
+			if !result.Allowed {
+				recommendation.Violations = append(recommendation.Violations, PodViolation{
+					Pod:    pod.Name,
+					Level:  level,
+					Reason: result.ForbiddenDetail(),
+				})
+			}
+		}
+	}
+	return recommendation, nil
+}
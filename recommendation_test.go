@@ -0,0 +1,94 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	psaapi "k8s.io/pod-security-admission/api"
+)
+
+func TestLevelRule(t *testing.T) {
+	tests := []struct {
+		rule     string
+		enforce  psaapi.Level
+		expected psaapi.Level
+	}{
+		{"+1", psaapi.LevelPrivileged, psaapi.LevelBaseline},
+		{"+1", psaapi.LevelBaseline, psaapi.LevelRestricted},
+		{"+1", psaapi.LevelRestricted, psaapi.LevelRestricted},
+		{"+2", psaapi.LevelPrivileged, psaapi.LevelRestricted},
+		{"+0", psaapi.LevelBaseline, psaapi.LevelBaseline},
+		{"restricted", psaapi.LevelPrivileged, psaapi.LevelRestricted},
+		{"baseline", psaapi.LevelRestricted, psaapi.LevelRestricted},
+	}
+	for _, test := range tests {
+		rule, err := ParseLevelRule(test.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if level := rule.Level(test.enforce); level != test.expected {
+			t.Errorf("Expected %v for rule %v and enforce %v, got %v", test.expected, test.rule, test.enforce, level)
+		}
+	}
+
+	for _, invalid := range []string{"", "+", "+x", "-1", "strict"} {
+		if _, err := ParseLevelRule(invalid); err == nil {
+			t.Errorf("Expected rule %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestRecommendNamespaceLevels(t *testing.T) {
+	baseline := fixturePod(t, "nginx.yaml")
+	baseline.Name = "web"
+	recommendation, err := RecommendNamespaceLevels([]v1.Pod{*baseline}, DefaultRecommendationPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"pod-security.kubernetes.io/enforce": "baseline",
+		"pod-security.kubernetes.io/warn":    "restricted",
+		"pod-security.kubernetes.io/audit":   "restricted",
+	}
+	if !reflect.DeepEqual(recommendation.Labels(), expected) {
+		t.Errorf("Expected labels %v, got %v", expected, recommendation.Labels())
+	}
+	if len(recommendation.Violations) != 1 || recommendation.Violations[0].Pod != "web" ||
+		recommendation.Violations[0].Level != psaapi.LevelRestricted || recommendation.Violations[0].Reason == "" {
+		t.Errorf("Expected pod web to violate restricted, got %+v", recommendation.Violations)
+	}
+
+	privileged := fixturePod(t, "nginx-privileged.yaml")
+	privileged.Name = "privileged"
+	recommendation, err = RecommendNamespaceLevels([]v1.Pod{*baseline, *privileged},
+		RecommendationPolicy{Warn: "+1", Audit: "restricted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recommendation.Enforce != psaapi.LevelPrivileged || recommendation.Warn != psaapi.LevelBaseline ||
+		recommendation.Audit != psaapi.LevelRestricted {
+		t.Errorf("Unexpected recommendation %+v", recommendation)
+	}
+	// the privileged pod violates baseline and restricted, the other pod
+	// only restricted
+	if len(recommendation.Violations) != 3 {
+		t.Errorf("Expected 3 violations, got %+v", recommendation.Violations)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	psaapi "k8s.io/pod-security-admission/api"
+)
+
+// RolloutStage is the mode a staged rollout of a Pod Security Standard has
+// reached in a namespace. The stages are audit, warn and enforce, each one
+// keeps the labels of the previous stages.
+type RolloutStage string
+
+const (
+	RolloutStageNone    RolloutStage = ""
+	RolloutStageAudit   RolloutStage = "audit"
+	RolloutStageWarn    RolloutStage = "warn"
+	RolloutStageEnforce RolloutStage = "enforce"
+)
+
+// Annotations that persist the RolloutStatus of a namespace.
+const (
+	RolloutStageAnnotation = "pspmigrator.kubernetes.io/rollout-stage"
+	RolloutLevelAnnotation = "pspmigrator.kubernetes.io/rollout-level"
+	RolloutSinceAnnotation = "pspmigrator.kubernetes.io/rollout-since"
+)
+
+// RolloutStatus is the state of the staged rollout of a namespace.
+type RolloutStatus struct {
+	Stage RolloutStage `json:"stage"`
+	Level psaapi.Level `json:"level"`
+	// Since is the start of the soak window of the current stage, i.e. the
+	// time the stage was reached or a violating pod was last observed.
+	Since time.Time `json:"since"`
+	// ViolatingPods are the pods that didn't pass the level when the
+	// rollout was last advanced, see AdvanceRollout.
+	ViolatingPods []string `json:"violatingPods,omitempty"`
+}
+
+// AdvanceRollout returns the status after the next step of the rollout. A
+// rollout that hasn't started yet moves to the audit stage right away, since
+// auditing can't break anything. Afterwards the rollout only moves to the
+// next stage once the soak window has passed without violating pods. Any
+// violating pod restarts the soak window. The violating pods are only those
+// the caller observed, e.g. the existing pods and, to include pods created
+// and deleted between two steps, AuditReport.ViolationsSince the status.
+func AdvanceRollout(status RolloutStatus, violatingPods []string, now time.Time, soak time.Duration) RolloutStatus {
+	next := status
+	next.ViolatingPods = violatingPods
+	switch {
+	case status.Stage == RolloutStageNone:
+		next.Stage, next.Since = RolloutStageAudit, now
+	case status.Stage == RolloutStageEnforce:
+	case len(violatingPods) > 0:
+		next.Since = now
+	case now.Sub(status.Since) >= soak:
+		next.Since = now
+		if status.Stage == RolloutStageAudit {
+			next.Stage = RolloutStageWarn
+		} else {
+			next.Stage = RolloutStageEnforce
+		}
+	}
+	return next
+}
+
+// Labels returns the pod-security.kubernetes.io labels of the stage.
+func (s RolloutStatus) Labels() map[string]string {
+	labels := make(map[string]string)
+	switch s.Stage {
+	case RolloutStageEnforce:
+		labels[psaapi.EnforceLevelLabel] = string(s.Level)
+		fallthrough
+	case RolloutStageWarn:
+		labels[psaapi.WarnLevelLabel] = string(s.Level)
+		fallthrough
+	case RolloutStageAudit:
+		labels[psaapi.AuditLevelLabel] = string(s.Level)
+	}
+	return labels
+}
+
+// Annotations returns the annotations that persist the status on the
+// namespace.
+func (s RolloutStatus) Annotations() map[string]string {
+	return map[string]string{
+		RolloutStageAnnotation: string(s.Stage),
+		RolloutLevelAnnotation: string(s.Level),
+		RolloutSinceAnnotation: s.Since.UTC().Format(time.RFC3339),
+	}
+}
+
+// RolloutStatusFromAnnotations returns the status persisted on a namespace,
+// and false if the namespace has no rollout in progress.
+func RolloutStatusFromAnnotations(annotations map[string]string) (RolloutStatus, bool, error) {
+	stage, ok := annotations[RolloutStageAnnotation]
+	if !ok {
+		return RolloutStatus{}, false, nil
+	}
+	status := RolloutStatus{Stage: RolloutStage(stage)}
+	switch status.Stage {
+	case RolloutStageNone, RolloutStageAudit, RolloutStageWarn, RolloutStageEnforce:
+	default:
+		return status, false, fmt.Errorf("invalid %s annotation %q", RolloutStageAnnotation, stage)
+	}
+	level, err := psaapi.ParseLevel(annotations[RolloutLevelAnnotation])
+	if err != nil {
+		return status, false, fmt.Errorf("invalid %s annotation: %w", RolloutLevelAnnotation, err)
+	}
+	status.Level = level
+	if since := annotations[RolloutSinceAnnotation]; since != "" {
+		if status.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return status, false, fmt.Errorf("invalid %s annotation: %w", RolloutSinceAnnotation, err)
+		}
+	}
+	return status, true, nil
+}
+
+// ViolatingPods returns the names of the pods that aren't allowed to run
+// under the level.
+func ViolatingPods(pods []v1.Pod, level psaapi.Level) ([]string, error) {
+	violating := make([]string, 0)
+	for i := range pods {
+		suggested, err := SuggestedPodSecurityStandard(&pods[i])
+		if err != nil {
+			return nil, err
+		}
+		if psaapi.CompareLevels(suggested, level) < 0 {
+			violating = append(violating, pods[i].Name)
+		}
+	}
+	return violating, nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	psaapi "k8s.io/pod-security-admission/api"
+)
+
+func TestAdvanceRollout(t *testing.T) {
+	start := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	soak := 24 * time.Hour
+
+	status := AdvanceRollout(RolloutStatus{Level: psaapi.LevelBaseline}, nil, start, soak)
+	if status.Stage != RolloutStageAudit || !status.Since.Equal(start) {
+		t.Fatalf("Expected the rollout to start with audit, got %+v", status)
+	}
+	if status = AdvanceRollout(status, nil, start.Add(time.Hour), soak); status.Stage != RolloutStageAudit {
+		t.Fatalf("Expected audit until the soak window passed, got %+v", status)
+	}
+	// a violating pod restarts the soak window
+	status = AdvanceRollout(status, []string{"privileged"}, start.Add(12*time.Hour), soak)
+	if status.Stage != RolloutStageAudit || !status.Since.Equal(start.Add(12*time.Hour)) {
+		t.Fatalf("Expected the soak window to restart, got %+v", status)
+	}
+	if status = AdvanceRollout(status, nil, start.Add(25*time.Hour), soak); status.Stage != RolloutStageAudit {
+		t.Fatalf("Expected audit until the restarted soak window passed, got %+v", status)
+	}
+	if status = AdvanceRollout(status, nil, start.Add(36*time.Hour), soak); status.Stage != RolloutStageWarn {
+		t.Fatalf("Expected warn after the soak window, got %+v", status)
+	}
+	if status = AdvanceRollout(status, nil, start.Add(60*time.Hour), soak); status.Stage != RolloutStageEnforce {
+		t.Fatalf("Expected enforce after the soak window, got %+v", status)
+	}
+	if next := AdvanceRollout(status, []string{"privileged"}, start.Add(100*time.Hour), soak); !reflect.DeepEqual(next.Since, status.Since) || next.Stage != RolloutStageEnforce {
+		t.Errorf("Expected enforce to be final, got %+v", next)
+	}
+
+	expected := map[string]string{
+		"pod-security.kubernetes.io/enforce": "baseline",
+		"pod-security.kubernetes.io/warn":    "baseline",
+		"pod-security.kubernetes.io/audit":   "baseline",
+	}
+	if !reflect.DeepEqual(status.Labels(), expected) {
+		t.Errorf("Expected labels %v, got %v", expected, status.Labels())
+	}
+	status.Stage = RolloutStageAudit
+	if expected := map[string]string{"pod-security.kubernetes.io/audit": "baseline"}; !reflect.DeepEqual(status.Labels(), expected) {
+		t.Errorf("Expected labels %v, got %v", expected, status.Labels())
+	}
+}
+
+func TestRolloutStatusAnnotations(t *testing.T) {
+	status := RolloutStatus{
+		Stage: RolloutStageWarn,
+		Level: psaapi.LevelRestricted,
+		Since: time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC),
+	}
+	parsed, ok, err := RolloutStatusFromAnnotations(status.Annotations())
+	if err != nil || !ok {
+		t.Fatalf("Expected the status to be parsed, got %v, %v", ok, err)
+	}
+	if !reflect.DeepEqual(parsed, status) {
+		t.Errorf("Expected %+v, got %+v", status, parsed)
+	}
+
+	if _, ok, err := RolloutStatusFromAnnotations(map[string]string{"foo": "bar"}); ok || err != nil {
+		t.Errorf("Expected no rollout, got %v, %v", ok, err)
+	}
+	invalid := status.Annotations()
+	invalid[RolloutStageAnnotation] = "deny"
+	if _, _, err := RolloutStatusFromAnnotations(invalid); err == nil {
+		t.Error("Expected an error for an invalid stage")
+	}
+}
+
+func TestViolatingPods(t *testing.T) {
+	baseline := fixturePod(t, "nginx.yaml")
+	baseline.Name = "web"
+	privileged := fixturePod(t, "nginx-privileged.yaml")
+	privileged.Name = "privileged"
+	violating, err := ViolatingPods([]v1.Pod{*baseline, *privileged}, psaapi.LevelBaseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(violating, []string{"privileged"}) {
+		t.Errorf("Expected [privileged], got %v", violating)
+	}
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"context"
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/pod-security-admission/admission"
+	admissionapi "k8s.io/pod-security-admission/admission/api"
+	"k8s.io/pod-security-admission/admission/api/load"
+	psaapi "k8s.io/pod-security-admission/api"
+	"k8s.io/pod-security-admission/metrics"
+	"k8s.io/pod-security-admission/policy"
+)
+
+// Simulator replays namespaces, pods and workloads through the admission
+// logic of the PodSecurity admission plugin, so the decisions, warnings and
+// audit annotations are exactly those of the API server. Unlike
+// SuggestedPodSecurityStandard it takes exemptions, namespace labels and
+// version defaults into account, and evaluates workloads the way the API
+// server does, i.e. they're never denied but only warned about.
+type Simulator struct {
+	// Creator returns the user that creates the pod when replaying the
+	// existing pods, ControllerUsername by default.
+	Creator func(pod *v1.Pod) string
+
+	admission  *admission.Admission
+	namespaces map[string]*v1.Namespace
+	pods       map[string][]*v1.Pod
+}
+
+// ControllerUsernames are the users the controllers of the
+// kube-controller-manager create pods as, by the kind of the pod's
+// controller. These are the service accounts used with
+// --use-service-account-credentials, otherwise all controllers create pods as
+// system:kube-controller-manager.
+var ControllerUsernames = map[string]string{
+	"ReplicaSet":            "system:serviceaccount:kube-system:replicaset-controller",
+	"ReplicationController": "system:serviceaccount:kube-system:replication-controller",
+	"DaemonSet":             "system:serviceaccount:kube-system:daemon-set-controller",
+	"StatefulSet":           "system:serviceaccount:kube-system:statefulset-controller",
+	"Job":                   "system:serviceaccount:kube-system:job-controller",
+}
+
+// ControllerUsername returns the user that created the pod: the service
+// account of its controller, see ControllerUsernames, or the node of a mirror
+// pod. It returns "" if the user isn't known, e.g. for bare pods.
+func ControllerUsername(pod *v1.Pod) string {
+	if _, mirror := pod.Annotations[v1.MirrorPodAnnotationKey]; mirror && pod.Spec.NodeName != "" {
+		return "system:node:" + pod.Spec.NodeName
+	}
+	if owner := metav1.GetControllerOfNoCopy(pod); owner != nil {
+		return ControllerUsernames[owner.Kind]
+	}
+	return ""
+}
+
+// SimulationResult is the response of the PodSecurity admission plugin to a
+// simulated request.
+type SimulationResult struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Allowed   bool
+	// Message is the error returned to the client if the request is denied.
+	Message          string
+	Warnings         []string
+	AuditAnnotations map[string]string
+}
+
+// NewSimulator returns a simulator for a cluster with the given namespaces
+// and pods. The PodSecurity admission plugin is configured with the
+// configuration, or with the default configuration of the API server if it's
+// nil.
+func NewSimulator(configuration *admissionapi.PodSecurityConfiguration, namespaces []v1.Namespace, pods []v1.Pod) (*Simulator, error) {
+	if configuration == nil {
+		var err error
+		if configuration, err = load.LoadFromData(nil); err != nil {
+			return nil, fmt.Errorf("failed to load default PodSecurityConfiguration: %w", err)
+		}
+	}
+	evaluator, err := policy.NewEvaluator(policy.DefaultChecks())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create evaluator: %w", err)
+	}
+	s := &Simulator{
+		Creator:    ControllerUsername,
+		namespaces: make(map[string]*v1.Namespace, len(namespaces)),
+		pods:       make(map[string][]*v1.Pod),
+	}
+	for i := range namespaces {
+		s.namespaces[namespaces[i].Name] = namespaces[i].DeepCopy()
+	}
+	for i := range pods {
+		s.pods[pods[i].Namespace] = append(s.pods[pods[i].Namespace], &pods[i])
+	}
+	s.admission = &admission.Admission{
+		Configuration:    configuration,
+		Evaluator:        evaluator,
+		Metrics:          nopRecorder{},
+		PodSpecExtractor: admission.DefaultPodSpecExtractor{},
+		NamespaceGetter:  s,
+		PodLister:        s,
+	}
+	if err := s.admission.CompleteConfiguration(); err != nil {
+		return nil, err
+	}
+	if err := s.admission.ValidateConfiguration(); err != nil {
+		return nil, fmt.Errorf("invalid PodSecurityConfiguration: %w", err)
+	}
+	return s, nil
+}
+
+// GetNamespace implements admission.NamespaceGetter.
+func (s *Simulator) GetNamespace(ctx context.Context, name string) (*v1.Namespace, error) {
+	namespace, ok := s.namespaces[name]
+	if !ok {
+		return nil, apierrors.NewNotFound(v1.Resource("namespaces"), name)
+	}
+	return namespace, nil
+}
+
+// ListPods implements admission.PodLister.
+func (s *Simulator) ListPods(ctx context.Context, namespace string) ([]*v1.Pod, error) {
+	return s.pods[namespace], nil
+}
+
+// SetNamespaceLabels simulates updating the labels of the namespace. The
+// result contains the warnings the API server returns for existing pods
+// that violate a new enforce level. The labels are only applied to the
+// simulated namespace if the update is allowed.
+func (s *Simulator) SetNamespaceLabels(namespace string, labels map[string]string) (*SimulationResult, error) {
+	old, err := s.GetNamespace(context.TODO(), namespace)
+	if err != nil {
+		return nil, err
+	}
+	updated := old.DeepCopy()
+	updated.Labels = labels
+	attrs := &psaapi.AttributesRecord{
+		Name:      namespace,
+		Kind:      v1.SchemeGroupVersion.WithKind("Namespace"),
+		Resource:  v1.SchemeGroupVersion.WithResource("namespaces"),
+		Operation: admissionv1.Update,
+		Object:    updated,
+		OldObject: old,
+	}
+	result := simulationResult(attrs, s.admission.Validate(context.TODO(), attrs))
+	if result.Allowed {
+		s.namespaces[namespace] = updated
+	}
+	return result, nil
+}
+
+// ValidatePod simulates the creation of the pod by the user.
+func (s *Simulator) ValidatePod(pod *v1.Pod, username string) *SimulationResult {
+	attrs := &psaapi.AttributesRecord{
+		Name:      pod.Name,
+		Namespace: pod.Namespace,
+		Kind:      v1.SchemeGroupVersion.WithKind("Pod"),
+		Resource:  v1.SchemeGroupVersion.WithResource("pods"),
+		Operation: admissionv1.Create,
+		Object:    pod,
+		Username:  username,
+	}
+	return simulationResult(attrs, s.admission.Validate(context.TODO(), attrs))
+}
+
+// ValidateWorkload simulates the creation of the workload, e.g. a
+// Deployment, by the user.
+func (s *Simulator) ValidateWorkload(obj runtime.Object, username string) (*SimulationResult, error) {
+	kind, resource, err := workloadKindAndResource(obj)
+	if err != nil {
+		return nil, err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	attrs := &psaapi.AttributesRecord{
+		Name:      accessor.GetName(),
+		Namespace: accessor.GetNamespace(),
+		Kind:      kind,
+		Resource:  resource,
+		Operation: admissionv1.Create,
+		Object:    obj,
+		Username:  username,
+	}
+	return simulationResult(attrs, s.admission.Validate(context.TODO(), attrs)), nil
+}
+
+// ReplayPods simulates recreating the existing pods of the namespace, each
+// by the user returned by Creator, so username exemptions apply to them.
+func (s *Simulator) ReplayPods(namespace string) []*SimulationResult {
+	results := make([]*SimulationResult, 0, len(s.pods[namespace]))
+	for _, pod := range s.pods[namespace] {
+		results = append(results, s.ValidatePod(pod, s.Creator(pod)))
+	}
+	return results
+}
+
+func simulationResult(attrs psaapi.Attributes, response *admissionv1.AdmissionResponse) *SimulationResult {
+	result := &SimulationResult{
+		Kind:             attrs.GetKind().Kind,
+		Namespace:        attrs.GetNamespace(),
+		Name:             attrs.GetName(),
+		Allowed:          response.Allowed,
+		Warnings:         response.Warnings,
+		AuditAnnotations: response.AuditAnnotations,
+	}
+	if response.Result != nil {
+		result.Message = response.Result.Message
+	}
+	return result
+}
+
+func workloadKindAndResource(obj runtime.Object) (schema.GroupVersionKind, schema.GroupVersionResource, error) {
+	var gv schema.GroupVersion
+	var kind, resource string
+	switch obj.(type) {
+	case *v1.Pod:
+		gv, kind, resource = v1.SchemeGroupVersion, "Pod", "pods"
+	case *v1.PodTemplate:
+		gv, kind, resource = v1.SchemeGroupVersion, "PodTemplate", "podtemplates"
+	case *v1.ReplicationController:
+		gv, kind, resource = v1.SchemeGroupVersion, "ReplicationController", "replicationcontrollers"
+	case *appsv1.ReplicaSet:
+		gv, kind, resource = appsv1.SchemeGroupVersion, "ReplicaSet", "replicasets"
+	case *appsv1.Deployment:
+		gv, kind, resource = appsv1.SchemeGroupVersion, "Deployment", "deployments"
+	case *appsv1.DaemonSet:
+		gv, kind, resource = appsv1.SchemeGroupVersion, "DaemonSet", "daemonsets"
+	case *appsv1.StatefulSet:
+		gv, kind, resource = appsv1.SchemeGroupVersion, "StatefulSet", "statefulsets"
+	case *batchv1.Job:
+		gv, kind, resource = batchv1.SchemeGroupVersion, "Job", "jobs"
+	case *batchv1.CronJob:
+		gv, kind, resource = batchv1.SchemeGroupVersion, "CronJob", "cronjobs"
+	default:
+		return schema.GroupVersionKind{}, schema.GroupVersionResource{}, fmt.Errorf("unsupported workload type %T", obj)
+	}
+	return gv.WithKind(kind), gv.WithResource(resource), nil
+}
+
+// nopRecorder discards the metrics of the simulated requests.
+type nopRecorder struct{}
+
+func (nopRecorder) RecordEvaluation(metrics.Decision, psaapi.LevelVersion, metrics.Mode, psaapi.Attributes) {
+}
+func (nopRecorder) RecordExemption(psaapi.Attributes)   {}
+func (nopRecorder) RecordError(bool, psaapi.Attributes) {}
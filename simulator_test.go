@@ -0,0 +1,181 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	admissionapi "k8s.io/pod-security-admission/admission/api"
+	"sigs.k8s.io/yaml"
+)
+
+func newSimulator(t *testing.T, configuration *admissionapi.PodSecurityConfiguration) *Simulator {
+	privileged := fixturePod(t, "nginx-privileged.yaml")
+	privileged.Namespace, privileged.Name = "apps", "privileged"
+	baseline := fixturePod(t, "nginx.yaml")
+	baseline.Namespace, baseline.Name = "apps", "baseline"
+	namespaces := []v1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "apps", Labels: map[string]string{"kubernetes.io/metadata.name": "apps"}}},
+	}
+	s, err := NewSimulator(configuration, namespaces, []v1.Pod{*privileged, *baseline})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSimulatorSetNamespaceLabels(t *testing.T) {
+	s := newSimulator(t, nil)
+	result, err := s.SetNamespaceLabels("apps", map[string]string{"pod-security.kubernetes.io/enforce": "baseline"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed {
+		t.Fatalf("Expected the label update to be allowed, got %v", result.Message)
+	}
+	if len(result.Warnings) != 2 ||
+		result.Warnings[0] != `existing pods in namespace "apps" violate the new PodSecurity enforce level "baseline:latest"` ||
+		!strings.HasPrefix(result.Warnings[1], "privileged: ") {
+		t.Errorf("Unexpected warnings %q", result.Warnings)
+	}
+
+	result, err = s.SetNamespaceLabels("apps", map[string]string{"pod-security.kubernetes.io/enforce": "invalid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed {
+		t.Error("Expected invalid labels to be denied")
+	}
+
+	if _, err := s.SetNamespaceLabels("missing", nil); err == nil {
+		t.Error("Expected an error for a missing namespace")
+	}
+}
+
+func TestSimulatorReplayPods(t *testing.T) {
+	s := newSimulator(t, nil)
+	for _, result := range s.ReplayPods("apps") {
+		if !result.Allowed {
+			t.Errorf("Expected pod %v to be allowed without labels, got %v", result.Name, result.Message)
+		}
+	}
+
+	if _, err := s.SetNamespaceLabels("apps", map[string]string{"pod-security.kubernetes.io/enforce": "baseline"}); err != nil {
+		t.Fatal(err)
+	}
+	results := s.ReplayPods("apps")
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+	if results[0].Allowed || !strings.HasPrefix(results[0].Message, `pods "privileged" is forbidden: violates PodSecurity "baseline:latest": `) {
+		t.Errorf("Expected the privileged pod to be denied, got %+v", results[0])
+	}
+	if !results[1].Allowed || results[1].AuditAnnotations["enforce-policy"] != "baseline:latest" {
+		t.Errorf("Expected the baseline pod to be allowed, got %+v", results[1])
+	}
+}
+
+func TestSimulatorValidateWorkload(t *testing.T) {
+	s := newSimulator(t, nil)
+	labels := map[string]string{
+		"pod-security.kubernetes.io/enforce": "baseline",
+		"pod-security.kubernetes.io/warn":    "baseline",
+	}
+	if _, err := s.SetNamespaceLabels("apps", labels); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile("tests/nginx-privileged.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployment := &appsv1.Deployment{}
+	if err := yaml.Unmarshal(data, deployment); err != nil {
+		t.Fatal(err)
+	}
+	deployment.Namespace = "apps"
+
+	// workloads are never denied, only warned about
+	result, err := s.ValidateWorkload(deployment, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed || result.Kind != "Deployment" || len(result.Warnings) != 1 ||
+		!strings.HasPrefix(result.Warnings[0], `would violate PodSecurity "baseline:latest": `) {
+		t.Errorf("Unexpected result %+v", result)
+	}
+
+	if _, err := s.ValidateWorkload(&v1.Service{}, ""); err == nil {
+		t.Error("Expected an error for a Service")
+	}
+}
+
+func TestSimulatorExemptions(t *testing.T) {
+	configuration := &admissionapi.PodSecurityConfiguration{
+		Defaults: admissionapi.PodSecurityDefaults{
+			Enforce: "restricted", EnforceVersion: "latest",
+			Audit: "restricted", AuditVersion: "latest",
+			Warn: "restricted", WarnVersion: "latest",
+		},
+		Exemptions: admissionapi.PodSecurityExemptions{
+			Usernames: []string{"system:serviceaccount:kube-system:operator"},
+		},
+	}
+	s := newSimulator(t, configuration)
+	pod := fixturePod(t, "nginx-privileged.yaml")
+	pod.Namespace, pod.Name = "apps", "new"
+	if result := s.ValidatePod(pod, "alice"); result.Allowed {
+		t.Error("Expected the pod to be denied by the restricted default")
+	}
+	result := s.ValidatePod(pod, "system:serviceaccount:kube-system:operator")
+	if !result.Allowed || result.AuditAnnotations["exempt"] != "user" {
+		t.Errorf("Expected the pod to be allowed by the user exemption, got %+v", result)
+	}
+}
+
+func TestSimulatorReplayPodsAsController(t *testing.T) {
+	configuration := &admissionapi.PodSecurityConfiguration{
+		Defaults: admissionapi.PodSecurityDefaults{
+			Enforce: "baseline", EnforceVersion: "latest",
+			Audit: "privileged", AuditVersion: "latest",
+			Warn: "privileged", WarnVersion: "latest",
+		},
+		Exemptions: admissionapi.PodSecurityExemptions{
+			Usernames: []string{"system:serviceaccount:kube-system:daemon-set-controller"},
+		},
+	}
+	s := newSimulator(t, configuration)
+	privileged := s.pods["apps"][0]
+	if result := s.ReplayPods("apps")[0]; result.Allowed {
+		t.Errorf("Expected the bare privileged pod to be denied, got %+v", result)
+	}
+
+	privileged.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "node-agent", Controller: newTrue()}}
+	result := s.ReplayPods("apps")[0]
+	if !result.Allowed || result.AuditAnnotations["exempt"] != "user" {
+		t.Errorf("Expected the DaemonSet pod to be exempt as its controller, got %+v", result)
+	}
+
+	s.Creator = func(pod *v1.Pod) string { return "alice" }
+	if result := s.ReplayPods("apps")[0]; result.Allowed {
+		t.Errorf("Expected the pod created by alice to be denied, got %+v", result)
+	}
+}
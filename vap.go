@@ -0,0 +1,394 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// celContainers is a CEL expression for all containers, init containers
+	// and ephemeral containers of the pod.
+	celContainers = "(object.spec.containers" +
+		" + (has(object.spec.initContainers) ? object.spec.initContainers : [])" +
+		" + (has(object.spec.ephemeralContainers) ? object.spec.ephemeralContainers : []))"
+	celVolumes = "(has(object.spec.volumes) ? object.spec.volumes : [])"
+)
+
+// CELValidation is a CEL expression that a pod must satisfy to be allowed by
+// a control of a PodSecurityPolicy. The pod is the object variable.
+type CELValidation struct {
+	Control    PSPControl
+	Expression string
+	Message    string
+}
+
+// ValidatingAdmissionPolicyOptions configures the generated
+// ValidatingAdmissionPolicy objects.
+type ValidatingAdmissionPolicyOptions struct {
+	// Namespaces limits the policy to pods in these namespaces. The policy
+	// applies to all namespaces if it's empty.
+	Namespaces []string
+	// ValidationActions of the binding, a combination of Deny, Warn and
+	// Audit. It isn't supported by v1alpha1.
+	ValidationActions []string
+	// APIVersion is v1alpha1 (Kubernetes 1.26), v1beta1 (1.28) or v1
+	// (1.30), defaults to v1.
+	APIVersion string
+}
+
+// PSPCELValidations converts the controls of the PodSecurityPolicy that
+// aren't covered by the Pod Security Standard it maps to into CEL
+// validations, see ResidualPSPControls. The deprecated seccomp annotations
+// aren't checked since they are ignored as of Kubernetes 1.27.
+func PSPCELValidations(psp *v1beta1.PodSecurityPolicy) []CELValidation {
+	_, controls := ResidualPSPControls(psp)
+	validations := make([]CELValidation, 0, len(controls))
+	for _, control := range controls {
+		validations = append(validations, celValidations(psp, control)...)
+	}
+	return validations
+}
+
+// ValidatingAdmissionPolicy converts the PodSecurityPolicy into a
+// ValidatingAdmissionPolicy with the validations of PSPCELValidations and a
+// binding for it. Both are nil if there are no validations.
+func ValidatingAdmissionPolicy(psp *v1beta1.PodSecurityPolicy, opts ValidatingAdmissionPolicyOptions) (
+	policy, binding *unstructured.Unstructured) {
+	validations := PSPCELValidations(psp)
+	if len(validations) == 0 {
+		return nil, nil
+	}
+	apiVersion := "admissionregistration.k8s.io/" + opts.APIVersion
+	if opts.APIVersion == "" {
+		apiVersion = "admissionregistration.k8s.io/v1"
+	}
+	name := "psp-" + psp.Name
+	annotations := map[string]interface{}{
+		PSPAnnotation:                 psp.Name,
+		PodSecurityStandardAnnotation: string(PSPPodSecurityStandard(psp)),
+	}
+
+	rules := make([]interface{}, 0, len(validations))
+	for _, validation := range validations {
+		rules = append(rules, map[string]interface{}{
+			"expression": validation.Expression,
+			"message":    validation.Message,
+		})
+	}
+	policy = &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "ValidatingAdmissionPolicy",
+		"metadata":   map[string]interface{}{"name": name, "annotations": annotations},
+		"spec": map[string]interface{}{
+			"failurePolicy": "Fail",
+			"matchConstraints": map[string]interface{}{
+				"resourceRules": []interface{}{
+					map[string]interface{}{
+						"apiGroups":   []interface{}{""},
+						"apiVersions": []interface{}{"v1"},
+						"operations":  []interface{}{"CREATE", "UPDATE"},
+						"resources":   []interface{}{"pods"},
+					},
+				},
+			},
+			"validations": rules,
+		},
+	}}
+
+	bindingSpec := map[string]interface{}{"policyName": name}
+	if len(opts.Namespaces) > 0 {
+		bindingSpec["matchResources"] = map[string]interface{}{
+			"namespaceSelector": map[string]interface{}{
+				"matchExpressions": []interface{}{
+					map[string]interface{}{
+						"key":      v1.LabelMetadataName,
+						"operator": "In",
+						"values":   toInterfaces(opts.Namespaces),
+					},
+				},
+			},
+		}
+	}
+	if opts.APIVersion != "v1alpha1" {
+		actions := opts.ValidationActions
+		if len(actions) == 0 {
+			actions = []string{"Deny"}
+		}
+		bindingSpec["validationActions"] = toInterfaces(actions)
+	}
+	binding = &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "ValidatingAdmissionPolicyBinding",
+		"metadata":   map[string]interface{}{"name": name, "annotations": annotations},
+		"spec":       bindingSpec,
+	}}
+	return policy, binding
+}
+
+func celValidations(psp *v1beta1.PodSecurityPolicy, control PSPControl) []CELValidation {
+	spec := &psp.Spec
+	validation := func(expression, format string, a ...interface{}) []CELValidation {
+		return []CELValidation{{
+			Control:    control,
+			Expression: expression,
+			Message:    fmt.Sprintf(format, a...) + fmt.Sprintf(" by PodSecurityPolicy %s.", psp.Name),
+		}}
+	}
+	// containerField is true if the field of the container security context
+	// is unset or satisfies the condition.
+	containerField := func(field, condition string) string {
+		return fmt.Sprintf("%s.all(c, !has(c.securityContext) || !has(c.securityContext.%s) || %s)",
+			celContainers, field, strings.ReplaceAll(condition, "$", "c.securityContext."+field))
+	}
+
+	switch control {
+	case ControlPrivileged:
+		return validation(containerField("privileged", "$ == false"), "Privileged containers are not allowed")
+	case ControlHostNamespaces:
+		conditions := make([]string, 0)
+		for field, allowed := range map[string]bool{"hostIPC": spec.HostIPC, "hostNetwork": spec.HostNetwork, "hostPID": spec.HostPID} {
+			if !allowed {
+				conditions = append(conditions, fmt.Sprintf("(!has(object.spec.%s) || object.spec.%s == false)", field, field))
+			}
+		}
+		return validation(strings.Join(sets.NewString(conditions...).List(), " && "),
+			"Sharing the host namespaces is not allowed")
+	case ControlHostPorts:
+		ranges := []string{"p.hostPort == 0"}
+		for _, r := range spec.HostPorts {
+			ranges = append(ranges, fmt.Sprintf("(p.hostPort >= %d && p.hostPort <= %d)", r.Min, r.Max))
+		}
+		return validation(fmt.Sprintf("%s.all(c, !has(c.ports) || c.ports.all(p, !has(p.hostPort) || %s))",
+			celContainers, strings.Join(ranges, " || ")),
+			"Host ports outside of %v are not allowed", idRanges(hostPortRanges(spec.HostPorts)))
+	case ControlCapabilities:
+		allowed := pspAllowedCapabilities(psp).List()
+		return validation(fmt.Sprintf("%s.all(c, !has(c.securityContext) || !has(c.securityContext.capabilities) || "+
+			"!has(c.securityContext.capabilities.add) || c.securityContext.capabilities.add.all(cap, cap in %s))",
+			celContainers, celList(allowed)),
+			"Adding capabilities other than %v is not allowed", allowed)
+	case ControlRequiredDropCapabilities:
+		required := make([]string, 0, len(spec.RequiredDropCapabilities))
+		for _, c := range spec.RequiredDropCapabilities {
+			required = append(required, string(c))
+		}
+		return validation(fmt.Sprintf("%s.all(c, has(c.securityContext) && has(c.securityContext.capabilities) && "+
+			"has(c.securityContext.capabilities.drop) && ('ALL' in c.securityContext.capabilities.drop || "+
+			"%s.all(cap, cap in c.securityContext.capabilities.drop)))", celContainers, celList(required)),
+			"Containers must drop the capabilities %v", required)
+	case ControlVolumes:
+		allowed := pspVolumes(psp)
+		disallowed := make([]string, 0)
+		for _, volume := range AllVolumes {
+			if !allowed.Has(volume) {
+				disallowed = append(disallowed, fmt.Sprintf("!has(v.%s)", volume))
+			}
+		}
+		if len(disallowed) == 0 {
+			return nil
+		}
+		return validation(fmt.Sprintf("%s.all(v, %s)", celVolumes, strings.Join(disallowed, " && ")),
+			"Only volumes of type %v are allowed", allowed.List())
+	case ControlHostPaths:
+		allowed, writable := make([]string, 0), make([]string, 0)
+		prefixes := make([]string, 0)
+		for _, p := range spec.AllowedHostPaths {
+			prefixes = append(prefixes, p.PathPrefix)
+			allowed = append(allowed, celPathPrefix("v.hostPath.path", p.PathPrefix))
+			if !p.ReadOnly {
+				writable = append(writable, celPathPrefix("v.hostPath.path", p.PathPrefix))
+			}
+		}
+		validations := validation(fmt.Sprintf("%s.all(v, !has(v.hostPath) || %s)", celVolumes, strings.Join(allowed, " || ")),
+			"Only host paths with the prefixes %v are allowed", prefixes)
+		if len(writable) < len(allowed) {
+			readOnly := fmt.Sprintf("%s.all(c, !has(c.volumeMounts) || c.volumeMounts.all(m, m.name != v.name || (has(m.readOnly) && m.readOnly)))",
+				celContainers)
+			validations = append(validations, validation(fmt.Sprintf("%s.all(v, !has(v.hostPath) || %s)",
+				celVolumes, strings.Join(append(writable, readOnly), " || ")),
+				"Host paths must be mounted read-only unless they're in writable prefixes")...)
+		}
+		return validations
+	case ControlFlexVolumes:
+		drivers := make([]string, 0, len(spec.AllowedFlexVolumes))
+		for _, d := range spec.AllowedFlexVolumes {
+			drivers = append(drivers, d.Driver)
+		}
+		return validation(fmt.Sprintf("%s.all(v, !has(v.flexVolume) || v.flexVolume.driver in %s)", celVolumes, celList(drivers)),
+			"Only the flexVolume drivers %v are allowed", drivers)
+	case ControlCSIDrivers:
+		drivers := make([]string, 0, len(spec.AllowedCSIDrivers))
+		for _, d := range spec.AllowedCSIDrivers {
+			drivers = append(drivers, d.Name)
+		}
+		return validation(fmt.Sprintf("%s.all(v, !has(v.csi) || v.csi.driver in %s)", celVolumes, celList(drivers)),
+			"Only the CSI drivers %v are allowed", drivers)
+	case ControlReadOnlyRootFilesystem:
+		return validation(fmt.Sprintf("%s.all(c, has(c.securityContext) && has(c.securityContext.readOnlyRootFilesystem) && "+
+			"c.securityContext.readOnlyRootFilesystem)", celContainers),
+			"Containers must use a read-only root filesystem")
+	case ControlRunAsUser:
+		ids := celContainerIDs("runAsUser")
+		if spec.RunAsUser.Rule == v1beta1.RunAsUserStrategyMustRunAsNonRoot {
+			nonRoot := "(has(c.securityContext) && has(c.securityContext.runAsNonRoot) ? c.securityContext.runAsNonRoot : " +
+				"has(object.spec.securityContext) && has(object.spec.securityContext.runAsNonRoot) && object.spec.securityContext.runAsNonRoot)"
+			return validation(fmt.Sprintf("%s.all(c, [%s].all(ids, ids.all(id, id != 0) && (ids.size() > 0 || %s)))",
+				celContainers, ids, nonRoot),
+				"Containers must run as non-root")
+		}
+		return validation(fmt.Sprintf("%s.all(c, [%s].all(ids, %s))", celContainers, ids, celIDsInRanges(true, spec.RunAsUser.Ranges)),
+			"runAsUser must be set to a value in the ranges %v", idRanges(spec.RunAsUser.Ranges))
+	case ControlRunAsGroup:
+		return validation(fmt.Sprintf("%s.all(c, [%s].all(ids, %s))", celContainers, celContainerIDs("runAsGroup"),
+			celIDsInRanges(spec.RunAsGroup.Rule == v1beta1.RunAsGroupStrategyMustRunAs, spec.RunAsGroup.Ranges)),
+			"runAsGroup must be set to a value in the ranges %v", idRanges(spec.RunAsGroup.Ranges))
+	case ControlSupplementalGroups:
+		ids := "has(object.spec.securityContext) && has(object.spec.securityContext.supplementalGroups) ? " +
+			"object.spec.securityContext.supplementalGroups : []"
+		return validation(fmt.Sprintf("[%s].all(ids, %s)", ids,
+			celIDsInRanges(spec.SupplementalGroups.Rule == v1beta1.SupplementalGroupsStrategyMustRunAs, spec.SupplementalGroups.Ranges)),
+			"supplementalGroups must be in the ranges %v", idRanges(spec.SupplementalGroups.Ranges))
+	case ControlFSGroup:
+		ids := "has(object.spec.securityContext) && has(object.spec.securityContext.fsGroup) ? " +
+			"[object.spec.securityContext.fsGroup] : []"
+		return validation(fmt.Sprintf("[%s].all(ids, %s)", ids,
+			celIDsInRanges(spec.FSGroup.Rule == v1beta1.FSGroupStrategyMustRunAs, spec.FSGroup.Ranges)),
+			"fsGroup must be in the ranges %v", idRanges(spec.FSGroup.Ranges))
+	case ControlAllowPrivilegeEscalation:
+		return validation(fmt.Sprintf("%s.all(c, has(c.securityContext) && has(c.securityContext.allowPrivilegeEscalation) && "+
+			"c.securityContext.allowPrivilegeEscalation == false)", celContainers),
+			"Privilege escalation is not allowed")
+	case ControlSELinux:
+		options := seLinuxOptionsMap(spec.SELinux.SELinuxOptions)
+		conditions := make([]string, 0)
+		for _, field := range []string{"level", "role", "type", "user"} {
+			value, _ := options[field].(string)
+			conditions = append(conditions, fmt.Sprintf("(has(o.%s) ? o.%s : '') == %s", field, field, strconv.Quote(value)))
+		}
+		matches := strings.Join(conditions, " && ")
+		return validation(fmt.Sprintf("(!has(object.spec.securityContext) || !has(object.spec.securityContext.seLinuxOptions) || "+
+			"[object.spec.securityContext.seLinuxOptions].all(o, %s)) && %s", matches,
+			containerField("seLinuxOptions", "[$].all(o, "+matches+")")),
+			"SELinux options must be %v", options)
+	case ControlProcMount:
+		return validation(containerField("procMount", "$ == 'Default'"), "Only the Default procMount is allowed")
+	case ControlSysctls:
+		conditions := make([]string, 0)
+		if !sets.NewString(spec.AllowedUnsafeSysctls...).Has("*") {
+			conditions = append(conditions, celNameMatches("s.name", append(append([]string{}, SafeSysctls...), spec.AllowedUnsafeSysctls...)))
+		}
+		if len(spec.ForbiddenSysctls) > 0 {
+			conditions = append(conditions, "!("+celNameMatches("s.name", spec.ForbiddenSysctls)+")")
+		}
+		return validation(fmt.Sprintf("!has(object.spec.securityContext) || !has(object.spec.securityContext.sysctls) || "+
+			"object.spec.securityContext.sysctls.all(s, %s)", strings.Join(conditions, " && ")),
+			"Sysctls must be safe or in %v and not in %v", spec.AllowedUnsafeSysctls, spec.ForbiddenSysctls)
+	case ControlSeccomp:
+		profiles, _ := pspProfiles(psp, SeccompAllowedProfilesAnnotation)
+		profileType := "(has(c.securityContext) && has(c.securityContext.seccompProfile) ? c.securityContext.seccompProfile.type : " +
+			"has(object.spec.securityContext) && has(object.spec.securityContext.seccompProfile) ? " +
+			"object.spec.securityContext.seccompProfile.type : '')"
+		return validation(fmt.Sprintf("%s.all(c, %s in %s)", celContainers, profileType, celList(SeccompProfileTypes(profiles))),
+			"Only the seccomp profiles %v are allowed", profiles)
+	case ControlAppArmor:
+		profiles, _ := pspProfiles(psp, AppArmorAllowedProfilesAnnotation)
+		annotation := fmt.Sprintf("'%s' + c.name", v1.AppArmorBetaContainerAnnotationKeyPrefix)
+		profile := fmt.Sprintf("(has(object.metadata.annotations) && %s in object.metadata.annotations ? "+
+			"object.metadata.annotations[%s] : '')", annotation, annotation)
+		return validation(fmt.Sprintf("%s.all(c, [%s].all(p, %s))", celContainers, profile, celNameMatches("p", profiles)),
+			"Only the AppArmor profiles %v are allowed", profiles)
+	}
+	return nil
+}
+
+// celContainerIDs returns a CEL expression for a list with the effective user
+// or group ID of the container c, or an empty list if it isn't set.
+func celContainerIDs(field string) string {
+	return fmt.Sprintf("has(c.securityContext) && has(c.securityContext.%[1]s) ? [c.securityContext.%[1]s] : "+
+		"has(object.spec.securityContext) && has(object.spec.securityContext.%[1]s) ? [object.spec.securityContext.%[1]s] : []",
+		field)
+}
+
+// celIDsInRanges returns a CEL expression that checks the list of IDs ids.
+func celIDsInRanges(required bool, ranges []v1beta1.IDRange) string {
+	conditions := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		conditions = append(conditions, fmt.Sprintf("(id >= %d && id <= %d)", r.Min, r.Max))
+	}
+	inRanges := "true"
+	if len(conditions) > 0 {
+		inRanges = fmt.Sprintf("ids.all(id, %s)", strings.Join(conditions, " || "))
+	}
+	if required {
+		return "ids.size() > 0 && " + inRanges
+	}
+	return inRanges
+}
+
+// celPathPrefix returns a CEL expression that checks whether the path is the
+// prefix or below it.
+func celPathPrefix(path, prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return fmt.Sprintf("%s.startsWith('/')", path)
+	}
+	return fmt.Sprintf("(%[1]s == %[2]s || %[1]s.startsWith(%[3]s))", path, strconv.Quote(prefix), strconv.Quote(prefix+"/"))
+}
+
+// celNameMatches returns a CEL expression that checks whether the name
+// matches one of the patterns, which may end with a * wildcard.
+func celNameMatches(name string, patterns []string) string {
+	conditions := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		switch {
+		case pattern == "*":
+			return "true"
+		case strings.HasSuffix(pattern, "*"):
+			conditions = append(conditions, fmt.Sprintf("%s.startsWith(%s)", name, strconv.Quote(strings.TrimSuffix(pattern, "*"))))
+		default:
+			conditions = append(conditions, fmt.Sprintf("%s == %s", name, strconv.Quote(pattern)))
+		}
+	}
+	if len(conditions) == 0 {
+		return "false"
+	}
+	return "(" + strings.Join(conditions, " || ") + ")"
+}
+
+func celList(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, strconv.Quote(value))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func hostPortRanges(ranges []v1beta1.HostPortRange) []v1beta1.IDRange {
+	result := make([]v1beta1.IDRange, 0, len(ranges))
+	for _, r := range ranges {
+		result = append(result, v1beta1.IDRange{Min: int64(r.Min), Max: int64(r.Max)})
+	}
+	return result
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pspmigrator
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// fixturePod returns the pod of the Deployment in the tests directory.
+func fixturePod(t *testing.T, name string) *v1.Pod {
+	data, err := os.ReadFile("tests/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deployment appsv1.Deployment
+	if err := yaml.Unmarshal(data, &deployment); err != nil {
+		t.Fatal(err)
+	}
+	return &v1.Pod{ObjectMeta: deployment.Spec.Template.ObjectMeta, Spec: deployment.Spec.Template.Spec}
+}
+
+// evalCEL evaluates the validations against the pod the same way the API
+// server does, and returns the controls of the failed validations.
+func evalCEL(t *testing.T, validations []CELValidation, pod *v1.Pod) []PSPControl {
+	env, err := cel.NewEnv(cel.Variable("object", cel.DynType))
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := make([]PSPControl, 0)
+	for _, validation := range validations {
+		ast, issues := env.Compile(validation.Expression)
+		if issues != nil && issues.Err() != nil {
+			t.Fatalf("Failed to compile %v: %v", validation.Expression, issues.Err())
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _, err := program.Eval(map[string]interface{}{"object": object})
+		if err != nil {
+			t.Fatalf("Failed to evaluate %v for pod %v: %v", validation.Expression, pod.Name, err)
+		}
+		if out.Value() != true {
+			failed = append(failed, validation.Control)
+		}
+	}
+	return failed
+}
+
+func TestCELValidationsFixtures(t *testing.T) {
+	f, err := os.Open("tests/psp-policy.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	psps, err := DecodePSPs(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validations := PSPCELValidations(&psps[0])
+
+	if failed := evalCEL(t, validations, fixturePod(t, "nginx.yaml")); len(failed) != 0 {
+		t.Errorf("Expected nginx to be allowed, but it failed %v", failed)
+	}
+	failed := evalCEL(t, validations, fixturePod(t, "nginx-privileged.yaml"))
+	if len(failed) != 2 || failed[0] != ControlPrivileged || failed[1] != ControlCapabilities {
+		t.Errorf("Expected the privileged nginx to fail the privileged and capabilities controls, but got %v", failed)
+	}
+}
+
+func TestCELValidations(t *testing.T) {
+	psp := newRestrictedPSP()
+	psp.Spec.ReadOnlyRootFilesystem = true
+	psp.Spec.Volumes = append(psp.Spec.Volumes, v1beta1.HostPath)
+	psp.Spec.AllowedHostPaths = []v1beta1.AllowedHostPath{{PathPrefix: "/var/log", ReadOnly: true}}
+	psp.Spec.FSGroup = v1beta1.FSGroupStrategyOptions{
+		Rule:   v1beta1.FSGroupStrategyMustRunAs,
+		Ranges: []v1beta1.IDRange{{Min: 1000, Max: 2000}},
+	}
+	psp.Spec.SELinux.SELinuxOptions = &v1.SELinuxOptions{Level: "s0:c1"}
+	psp.Spec.AllowedCSIDrivers = []v1beta1.AllowedCSIDriver{{Name: "csi.example.com"}}
+	psp.Spec.AllowedFlexVolumes = []v1beta1.AllowedFlexVolume{{Driver: "example/flex"}}
+	psp.Spec.RunAsGroup = &v1beta1.RunAsGroupStrategyOptions{
+		Rule:   v1beta1.RunAsGroupStrategyMayRunAs,
+		Ranges: []v1beta1.IDRange{{Min: 1000, Max: 2000}},
+	}
+	psp.Spec.ForbiddenSysctls = []string{"kernel.*"}
+	validations := PSPCELValidations(psp)
+
+	// hostPath volumes make the PSP map to privileged, so the restricted
+	// controls have to be validated as well
+	yes, no := true, false
+	fsGroup := int64(1500)
+	allowed := fixturePod(t, "nginx.yaml")
+	allowed.Spec.SecurityContext = &v1.PodSecurityContext{
+		FSGroup:        &fsGroup,
+		RunAsNonRoot:   &yes,
+		SeccompProfile: &v1.SeccompProfile{Type: v1.SeccompProfileTypeRuntimeDefault},
+	}
+	allowed.Spec.Volumes = []v1.Volume{
+		{Name: "logs", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/var/log/nginx"}}},
+	}
+	allowed.Spec.Containers[0].VolumeMounts = []v1.VolumeMount{{Name: "logs", ReadOnly: true}}
+	allowed.Spec.Containers[0].SecurityContext = &v1.SecurityContext{
+		ReadOnlyRootFilesystem:   &yes,
+		AllowPrivilegeEscalation: &no,
+		Capabilities:             &v1.Capabilities{Drop: []v1.Capability{"ALL"}},
+		SELinuxOptions:           &v1.SELinuxOptions{Level: "s0:c1"},
+	}
+	if failed := evalCEL(t, validations, allowed); len(failed) != 0 {
+		t.Errorf("Expected the pod to be allowed, but it failed %v", failed)
+	}
+
+	denied := allowed.DeepCopy()
+	denied.Spec.SecurityContext.FSGroup = nil
+	denied.Spec.Volumes = append(denied.Spec.Volumes,
+		v1.Volume{Name: "data", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/var/logs"}}})
+	denied.Spec.Containers[0].VolumeMounts[0].ReadOnly = false
+	denied.Spec.Containers[0].SecurityContext.SELinuxOptions.Type = "spc_t"
+	expected := []PSPControl{ControlHostPaths, ControlHostPaths, ControlFSGroup, ControlSELinux}
+	failed := evalCEL(t, validations, denied)
+	if len(failed) != len(expected) {
+		t.Fatalf("Expected %v to fail, but got %v", expected, failed)
+	}
+	for i := range expected {
+		if failed[i] != expected[i] {
+			t.Errorf("Expected %v to fail, but got %v", expected, failed)
+		}
+	}
+}
+
+func TestValidatingAdmissionPolicy(t *testing.T) {
+	psp := newBaselinePSP()
+	psp.Spec.ReadOnlyRootFilesystem = true
+	policy, binding := ValidatingAdmissionPolicy(psp, ValidatingAdmissionPolicyOptions{
+		Namespaces: []string{"team-a"},
+		APIVersion: "v1alpha1",
+	})
+	policy, binding = deepCopyUnstructured(t, policy), deepCopyUnstructured(t, binding)
+	if policy.GetAPIVersion() != "admissionregistration.k8s.io/v1alpha1" || policy.GetName() != "psp-baseline" {
+		t.Errorf("Unexpected policy %v %v", policy.GetAPIVersion(), policy.GetName())
+	}
+	validations, _, _ := unstructured.NestedSlice(policy.Object, "spec", "validations")
+	if len(validations) != 2 {
+		t.Errorf("Expected 2 validations, but got %v", validations)
+	}
+	if _, found, _ := unstructured.NestedSlice(binding.Object, "spec", "validationActions"); found {
+		t.Errorf("Expected no validationActions in v1alpha1")
+	}
+	values, _, _ := unstructured.NestedSlice(binding.Object, "spec", "matchResources", "namespaceSelector", "matchExpressions")
+	if len(values) != 1 {
+		t.Errorf("Expected the binding to select namespace team-a, but got %v", values)
+	}
+
+	if policy, binding := ValidatingAdmissionPolicy(newPrivilegedPSP(), ValidatingAdmissionPolicyOptions{}); policy != nil || binding != nil {
+		t.Errorf("Expected no policy for a PSP without residual controls")
+	}
+}